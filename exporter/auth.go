@@ -0,0 +1,217 @@
+package main
+
+import (
+	"crypto/subtle"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// authRealm is the realm advertised to clients that fail to authenticate.
+const authRealm = "brigade-metrics"
+
+// authConfig represents configuration for authenticating requests to an HTTP
+// endpoint. Bearer token authentication, HTTP basic authentication, or both
+// may be enabled. If neither is, requests are not authenticated.
+type authConfig struct {
+	// TokenFile specifies the path to a file containing the bearer tokens that
+	// are accepted, one per line. Listing more than one permits tokens to be
+	// rotated without any client ever being refused. If empty, bearer token
+	// authentication is disabled.
+	TokenFile string
+	// Username specifies the username accepted for HTTP basic authentication.
+	Username string
+	// PasswordFile specifies the path to a file containing the password
+	// accepted for HTTP basic authentication. If empty, HTTP basic
+	// authentication is disabled.
+	PasswordFile string
+}
+
+// enabled returns a bool indicating whether the configuration calls for
+// requests to be authenticated.
+func (a authConfig) enabled() bool {
+	return a.TokenFile != "" || a.PasswordFile != ""
+}
+
+// authenticator is HTTP middleware that refuses requests lacking valid
+// credentials. Credentials are read from files, which are re-read whenever
+// they change, so credentials can be rotated without restarting the exporter.
+type authenticator struct {
+	tokens   *secretFile
+	username string
+	password *secretFile
+}
+
+// newAuthenticator returns an authenticator that accepts the credentials
+// described by the provided configuration. Every credential file is read once
+// up front so that a misconfiguration is reported immediately.
+func newAuthenticator(config authConfig) (*authenticator, error) {
+	a := &authenticator{username: config.Username}
+	if config.TokenFile != "" {
+		a.tokens = &secretFile{path: config.TokenFile}
+		tokens, err := a.tokens.lines()
+		if err != nil {
+			return nil, err
+		}
+		if len(tokens) == 0 {
+			return nil, errors.Errorf(
+				"bearer token file %s contains no tokens",
+				config.TokenFile,
+			)
+		}
+	}
+	if config.PasswordFile != "" {
+		a.password = &secretFile{path: config.PasswordFile}
+		password, err := a.password.value()
+		if err != nil {
+			return nil, err
+		}
+		if password == "" {
+			return nil, errors.Errorf(
+				"password file %s is empty",
+				config.PasswordFile,
+			)
+		}
+	}
+	return a, nil
+}
+
+// wrap returns a handler that passes only authenticated requests to the
+// provided handler.
+func (a *authenticator) wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ok, err := a.authenticate(r)
+		if err != nil {
+			appLogger.error("error authenticating request", "error", err)
+			http.Error(
+				w,
+				http.StatusText(http.StatusInternalServerError),
+				http.StatusInternalServerError,
+			)
+			return
+		}
+		if !ok {
+			if a.tokens != nil {
+				w.Header().Add(
+					"WWW-Authenticate",
+					`Bearer realm="`+authRealm+`"`,
+				)
+			}
+			if a.password != nil {
+				w.Header().Add(
+					"WWW-Authenticate",
+					`Basic realm="`+authRealm+`"`,
+				)
+			}
+			http.Error(
+				w,
+				http.StatusText(http.StatusUnauthorized),
+				http.StatusUnauthorized,
+			)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// authenticate returns a bool indicating whether the provided request bears
+// valid credentials. An error is returned only if the credentials to compare
+// against could not be read.
+func (a *authenticator) authenticate(r *http.Request) (bool, error) {
+	if a.tokens != nil {
+		const bearerPrefix = "Bearer "
+		header := r.Header.Get("Authorization")
+		if strings.HasPrefix(header, bearerPrefix) {
+			tokens, err := a.tokens.lines()
+			if err != nil {
+				return false, err
+			}
+			token := strings.TrimPrefix(header, bearerPrefix)
+			// Every token is compared, even after a match, so that how long this
+			// takes reveals nothing about which token matched
+			var match bool
+			for _, t := range tokens {
+				if secretsEqual(token, t) {
+					match = true
+				}
+			}
+			if match {
+				return true, nil
+			}
+		}
+	}
+	if a.password != nil {
+		if username, password, ok := r.BasicAuth(); ok {
+			expected, err := a.password.value()
+			if err != nil {
+				return false, err
+			}
+			usernameMatch := secretsEqual(username, a.username)
+			passwordMatch := secretsEqual(password, expected)
+			if usernameMatch && passwordMatch {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// secretsEqual compares two secrets in constant time.
+func secretsEqual(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+// secretFile is a file containing a secret. Its contents are cached and
+// re-read only when the file's modification time or size changes.
+type secretFile struct {
+	path     string
+	contents string
+	modTime  time.Time
+	size     int64
+	mu       sync.Mutex
+}
+
+// value returns the contents of the file, with any leading and trailing
+// whitespace removed.
+func (s *secretFile) value() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// Stat follows symlinks, so this also notices when Kubernetes atomically
+	// swaps the contents of a mounted Secret
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return "", errors.Wrapf(err, "error reading secret file %s", s.path)
+	}
+	if info.ModTime().Equal(s.modTime) && info.Size() == s.size {
+		return s.contents, nil
+	}
+	contents, err := ioutil.ReadFile(s.path)
+	if err != nil {
+		return "", errors.Wrapf(err, "error reading secret file %s", s.path)
+	}
+	s.contents = strings.TrimSpace(string(contents))
+	s.modTime = info.ModTime()
+	s.size = info.Size()
+	return s.contents, nil
+}
+
+// lines returns every non-blank line of the file, with any leading and
+// trailing whitespace removed.
+func (s *secretFile) lines() ([]string, error) {
+	contents, err := s.value()
+	if err != nil {
+		return nil, err
+	}
+	lines := []string{}
+	for _, line := range strings.Split(contents, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines, nil
+}
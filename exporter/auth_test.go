@@ -0,0 +1,89 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewAuthenticator(t *testing.T) {
+	testCases := []struct {
+		name       string
+		files      map[string]string
+		config     func(dir string) authConfig
+		assertions func(*testing.T, *authenticator, error)
+	}{
+		{
+			name: "token file does not exist",
+			config: func(dir string) authConfig {
+				return authConfig{TokenFile: filepath.Join(dir, "tokens")}
+			},
+			assertions: func(t *testing.T, _ *authenticator, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "error reading secret file")
+			},
+		},
+		{
+			name:  "token file contains no tokens",
+			files: map[string]string{"tokens": "\n  \n"},
+			config: func(dir string) authConfig {
+				return authConfig{TokenFile: filepath.Join(dir, "tokens")}
+			},
+			assertions: func(t *testing.T, _ *authenticator, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "contains no tokens")
+			},
+		},
+		{
+			name:  "password file is empty",
+			files: map[string]string{"password": "\n"},
+			config: func(dir string) authConfig {
+				return authConfig{
+					Username:     "prometheus",
+					PasswordFile: filepath.Join(dir, "password"),
+				}
+			},
+			assertions: func(t *testing.T, _ *authenticator, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "is empty")
+			},
+		},
+		{
+			name: "success",
+			files: map[string]string{
+				"tokens":   " foo \n\nbar\n",
+				"password": "baz\n",
+			},
+			config: func(dir string) authConfig {
+				return authConfig{
+					TokenFile:    filepath.Join(dir, "tokens"),
+					Username:     "prometheus",
+					PasswordFile: filepath.Join(dir, "password"),
+				}
+			},
+			assertions: func(t *testing.T, a *authenticator, err error) {
+				require.NoError(t, err)
+				tokens, err := a.tokens.lines()
+				require.NoError(t, err)
+				require.Equal(t, []string{"foo", "bar"}, tokens)
+				password, err := a.password.value()
+				require.NoError(t, err)
+				require.Equal(t, "baz", password)
+				require.Equal(t, "prometheus", a.username)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for name, contents := range testCase.files {
+				err := os.WriteFile(filepath.Join(dir, name), []byte(contents), 0600)
+				require.NoError(t, err)
+			}
+			a, err := newAuthenticator(testCase.config(dir))
+			testCase.assertions(t, a, err)
+		})
+	}
+}
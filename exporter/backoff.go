@@ -0,0 +1,38 @@
+package main
+
+import "time"
+
+// backoffJitter is the largest fraction by which a backoff delay is randomly
+// shortened so that collectors that begin failing at the same time, as they
+// all do when the API server is struggling, don't all retry at once.
+const backoffJitter = 0.2
+
+// backoffDelay returns how long to wait before next running a collector that
+// has failed the specified number of consecutive times, given how often it
+// runs normally and the maximum delay. The delay doubles with each consecutive
+// failure until it reaches the maximum and is then shortened by up to
+// backoffJitter, as dictated by random, which must be in the range [0, 1). It
+// is never shorter than the normal interval. A maximum of zero disables
+// backoff, in which case the normal interval is always returned.
+func backoffDelay(
+	interval time.Duration,
+	max time.Duration,
+	failures int,
+	random float64,
+) time.Duration {
+	if failures == 0 || max <= 0 {
+		return interval
+	}
+	delay := interval
+	for i := 0; i < failures && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		delay = max
+	}
+	delay -= time.Duration(float64(delay) * backoffJitter * random)
+	if delay < interval {
+		delay = interval
+	}
+	return delay
+}
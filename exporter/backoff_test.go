@@ -0,0 +1,77 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBackoffDelay(t *testing.T) {
+	testCases := []struct {
+		name     string
+		max      time.Duration
+		failures int
+		random   float64
+		expected time.Duration
+	}{
+		{
+			name:     "no failures",
+			max:      time.Minute,
+			failures: 0,
+			expected: 10 * time.Second,
+		},
+		{
+			name:     "backoff disabled",
+			max:      0,
+			failures: 3,
+			expected: 10 * time.Second,
+		},
+		{
+			name:     "one failure",
+			max:      time.Minute,
+			failures: 1,
+			expected: 20 * time.Second,
+		},
+		{
+			name:     "several failures",
+			max:      time.Minute,
+			failures: 2,
+			expected: 40 * time.Second,
+		},
+		{
+			name:     "capped at the maximum",
+			max:      time.Minute,
+			failures: 100,
+			expected: time.Minute,
+		},
+		{
+			name:     "jitter",
+			max:      time.Minute,
+			failures: 2,
+			random:   0.5,
+			expected: 36 * time.Second,
+		},
+		{
+			name:     "never shorter than the normal interval",
+			max:      5 * time.Second,
+			failures: 3,
+			random:   0.5,
+			expected: 10 * time.Second,
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			require.Equal(
+				t,
+				testCase.expected,
+				backoffDelay(
+					10*time.Second,
+					testCase.max,
+					testCase.failures,
+					testCase.random,
+				),
+			)
+		})
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Names of all collectors.
+const (
+	collectorProjects                   = "projects"
+	collectorUsers                      = "users"
+	collectorServiceAccounts            = "service_accounts"
+	collectorEventsByWorkerPhase        = "events_by_worker_phase"
+	collectorProjectEventsByWorkerPhase = "project_events_by_worker_phase"
+	collectorJobsByPhase                = "jobs_by_phase"
+	collectorEventsBySource             = "events_by_source"
+	collectorDurations                  = "durations"
+	collectorQueue                      = "queue"
+	collectorSubstrateWorkers           = "substrate_workers"
+	collectorSubstrateJobs              = "substrate_jobs"
+)
+
+// Resources of the Brigade API that collectors query. Each is described in the
+// form used in messages about whether it may be listed.
+const (
+	resourceProjects        = "projects"
+	resourceUsers           = "users"
+	resourceServiceAccounts = "service accounts"
+	resourceEvents          = "events"
+	resourceRunningWorkers  = "running workers"
+	resourceRunningJobs     = "running jobs"
+)
+
+// collectorNames returns the names of all collectors.
+func collectorNames() []string {
+	return []string{
+		collectorProjects,
+		collectorUsers,
+		collectorServiceAccounts,
+		collectorEventsByWorkerPhase,
+		collectorProjectEventsByWorkerPhase,
+		collectorJobsByPhase,
+		collectorEventsBySource,
+		collectorDurations,
+		collectorQueue,
+		collectorSubstrateWorkers,
+		collectorSubstrateJobs,
+	}
+}
+
+// isCollectorName returns true if the specified name is the name of a
+// collector and false otherwise.
+func isCollectorName(name string) bool {
+	for _, n := range collectorNames() {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// collector pairs a function that queries the Brigade API and updates one or
+// more metrics accordingly with a name that identifies it in logs, in
+// configuration, and in the exporter's own metrics.
+type collector struct {
+	name     string
+	recordFn func(context.Context) error
+	// metrics are the metrics updated by recordFn. These are only reported
+	// when the collector is enabled.
+	metrics []prometheus.Collector
+	// resources are the Brigade API resources that recordFn queries.
+	resources []string
+}
+
+// collectors returns all of the exporter's collectors, regardless of whether
+// they are enabled. Each collector listed here must also be named in
+// collectorNames().
+func (m *metricsExporter) collectors() []collector {
+	substrateWorkersMetrics := []prometheus.Collector{m.runningWorkersGauge}
+	// Saturation is meaningless without knowing the limit
+	if m.config.MaxConcurrentWorkers > 0 {
+		substrateWorkersMetrics =
+			append(substrateWorkersMetrics, m.saturationGauge)
+	}
+	return []collector{
+		{
+			name:      collectorProjects,
+			recordFn:  m.recordProjectsCount,
+			metrics:   []prometheus.Collector{m.projectsGauge},
+			resources: []string{resourceProjects},
+		},
+		{
+			name:      collectorUsers,
+			recordFn:  m.recordUsersCount,
+			metrics:   []prometheus.Collector{m.usersGauge},
+			resources: []string{resourceUsers},
+		},
+		{
+			name:      collectorServiceAccounts,
+			recordFn:  m.recordServiceAccountsCount,
+			metrics:   []prometheus.Collector{m.serviceAccountsGauge},
+			resources: []string{resourceServiceAccounts},
+		},
+		{
+			name:      collectorEventsByWorkerPhase,
+			recordFn:  m.recordEventCountsByWorkersPhase,
+			metrics:   []prometheus.Collector{m.allWorkersByPhase},
+			resources: []string{resourceEvents},
+		},
+		{
+			name:      collectorProjectEventsByWorkerPhase,
+			recordFn:  m.recordProjectEventCountsByWorkersPhase,
+			metrics:   []prometheus.Collector{m.projectWorkersByPhase},
+			resources: []string{resourceProjects, resourceEvents},
+		},
+		{
+			name:      collectorJobsByPhase,
+			recordFn:  m.recordJobCountsByPhase,
+			metrics:   []prometheus.Collector{m.jobsByPhase},
+			resources: []string{resourceEvents},
+		},
+		{
+			name:     collectorEventsBySource,
+			recordFn: m.recordEventCountsBySource,
+			metrics: []prometheus.Collector{
+				m.eventsBySource,
+				m.sourceEventsByPhase,
+			},
+			resources: []string{resourceEvents},
+		},
+		{
+			name:     collectorDurations,
+			recordFn: m.recordDurations,
+			metrics: []prometheus.Collector{
+				m.workerDurations,
+				m.jobDurations,
+			},
+			resources: []string{resourceEvents},
+		},
+		{
+			name:     collectorQueue,
+			recordFn: m.recordQueue,
+			metrics: []prometheus.Collector{
+				m.oldestPendingAges,
+				m.queueWaits,
+			},
+			resources: []string{resourceEvents},
+		},
+		{
+			name:      collectorSubstrateWorkers,
+			recordFn:  m.recordRunningWorkersCount,
+			metrics:   substrateWorkersMetrics,
+			resources: []string{resourceRunningWorkers},
+		},
+		{
+			name:      collectorSubstrateJobs,
+			recordFn:  m.recordRunningJobsCount,
+			metrics:   []prometheus.Collector{m.runningJobsGauge},
+			resources: []string{resourceRunningJobs},
+		},
+	}
+}
+
+// enabledCollectors returns all of the exporter's collectors that have not
+// been disabled.
+func (m *metricsExporter) enabledCollectors() []collector {
+	enabled := []collector{}
+	for _, c := range m.collectors() {
+		if !m.config.Collectors[c.name].Disabled {
+			enabled = append(enabled, c)
+		}
+	}
+	return enabled
+}
+
+// eventsBySourceMinInterval is how often, at most, the events_by_source
+// collector runs unless it has been configured with an interval of its own.
+// It must list every Event, in every phase, to count Events by source and
+// type, which is far too costly to do every few seconds in an installation
+// with any history.
+const eventsBySourceMinInterval = 5 * time.Minute
+
+// collectorInterval returns how often the named collector should query the
+// Brigade API.
+func (m *metricsExporter) collectorInterval(name string) time.Duration {
+	if interval := m.config.Collectors[name].Interval; interval > 0 {
+		return interval
+	}
+	if minInterval := m.collectorMinInterval(name); minInterval >
+		m.config.ScrapeInterval {
+		return minInterval
+	}
+	return m.config.ScrapeInterval
+}
+
+// collectorMinInterval returns the least amount of time that must elapse
+// between runs of the named collector when it hasn't been configured with an
+// interval of its own. A value of zero means no minimum.
+func (m *metricsExporter) collectorMinInterval(name string) time.Duration {
+	// Counting Events by source is cheap when Events are served from the index
+	if name == collectorEventsBySource && m.eventIndex == nil {
+		return eventsBySourceMinInterval
+	}
+	return 0
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	sdkTesting "github.com/brigadecore/brigade/sdk/v3/testing"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCollectorNames(t *testing.T) {
+	exporter := newMetricsExporter(
+		&sdkTesting.MockAPIClient{
+			CoreClient:  &sdkTesting.MockCoreClient{},
+			AuthnClient: &sdkTesting.MockAuthnClient{},
+		},
+		metricsExporterConfig{},
+	)
+	names := []string{}
+	for _, c := range exporter.collectors() {
+		names = append(names, c.name)
+		require.NotEmpty(t, c.metrics)
+	}
+	require.Equal(t, collectorNames(), names)
+	require.True(t, isCollectorName(collectorUsers))
+	require.False(t, isCollectorName("foo"))
+}
+
+func TestEnabledCollectors(t *testing.T) {
+	exporter := newMetricsExporter(
+		&sdkTesting.MockAPIClient{
+			CoreClient:  &sdkTesting.MockCoreClient{},
+			AuthnClient: &sdkTesting.MockAuthnClient{},
+		},
+		metricsExporterConfig{
+			Collectors: map[string]collectorConfig{
+				collectorUsers: {
+					Disabled: true,
+				},
+				collectorDurations: {
+					Interval: time.Minute,
+				},
+			},
+		},
+	)
+	enabled := exporter.enabledCollectors()
+	require.Len(t, enabled, len(collectorNames())-1)
+	for _, c := range enabled {
+		require.NotEqual(t, collectorUsers, c.name)
+	}
+	// The users gauge should no longer be among the exporter's metrics
+	for _, metric := range exporter.metrics() {
+		require.NotEqual(t, exporter.usersGauge, metric)
+	}
+}
+
+func TestCollectorInterval(t *testing.T) {
+	exporter := newMetricsExporter(
+		&sdkTesting.MockAPIClient{
+			CoreClient:  &sdkTesting.MockCoreClient{},
+			AuthnClient: &sdkTesting.MockAuthnClient{},
+		},
+		metricsExporterConfig{
+			ScrapeInterval: 5 * time.Second,
+			Collectors: map[string]collectorConfig{
+				collectorDurations: {
+					Interval: time.Minute,
+				},
+			},
+		},
+	)
+	require.Equal(t, time.Minute, exporter.collectorInterval(collectorDurations))
+	require.Equal(
+		t,
+		5*time.Second,
+		exporter.collectorInterval(collectorProjects),
+	)
+	// Counting events by source is costly unless events are served from the
+	// index
+	require.Equal(
+		t,
+		eventsBySourceMinInterval,
+		exporter.collectorInterval(collectorEventsBySource),
+	)
+	exporter = newMetricsExporter(
+		&sdkTesting.MockAPIClient{
+			CoreClient:  &sdkTesting.MockCoreClient{},
+			AuthnClient: &sdkTesting.MockAuthnClient{},
+		},
+		metricsExporterConfig{
+			ScrapeInterval:    5 * time.Second,
+			EventIndexEnabled: true,
+		},
+	)
+	require.Equal(
+		t,
+		5*time.Second,
+		exporter.collectorInterval(collectorEventsBySource),
+	)
+}
+
+func TestSaturationReportedOnlyWithLimit(t *testing.T) {
+	for _, limit := range []int{0, 10} {
+		exporter := newMetricsExporter(
+			&sdkTesting.MockAPIClient{
+				CoreClient:  &sdkTesting.MockCoreClient{},
+				AuthnClient: &sdkTesting.MockAuthnClient{},
+			},
+			metricsExporterConfig{
+				MaxConcurrentWorkers: limit,
+			},
+		)
+		var found bool
+		for _, metric := range exporter.metrics() {
+			found = found || metric == exporter.saturationGauge
+		}
+		require.Equal(t, limit > 0, found)
+	}
+}
+
+func TestLabelLimiters(t *testing.T) {
+	exporter := newMetricsExporter(
+		&sdkTesting.MockAPIClient{
+			CoreClient:  &sdkTesting.MockCoreClient{},
+			AuthnClient: &sdkTesting.MockAuthnClient{},
+		},
+		metricsExporterConfig{
+			LabelValueLimit: 10,
+			LabelValueLimits: map[string]int{
+				"brigade_jobs_by_phase": 0,
+			},
+		},
+	)
+	// Every metric with labels of unbounded cardinality should be limited,
+	// except for the one whose limit was disabled
+	require.Len(t, exporter.limiters, len(labelLimitedMetricNames())-1)
+	require.NotContains(t, exporter.limiters, exporter.jobsByPhase)
+	var found bool
+	for _, metric := range exporter.metrics() {
+		found = found || metric == exporter.limiters[exporter.eventsBySource]
+	}
+	require.True(t, found)
+	require.Equal(
+		t,
+		len(labelLimitedMetricNames())-1,
+		testutil.CollectAndCount(exporter.labelOverflows),
+	)
+}
@@ -1,56 +1,1533 @@
 package main
 
 import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/brigadecore/brigade-foundations/http"
 	"github.com/brigadecore/brigade-foundations/os"
 	"github.com/brigadecore/brigade/sdk/v3/restmachinery"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
 )
 
+// fileConfig represents the contents of an optional YAML or JSON configuration
+// file. Every setting has an equivalent environment variable and, when both
+// are set, the environment variable takes precedence. Pointers are used
+// wherever it is necessary to distinguish a value that was not set from a zero
+// value.
+type fileConfig struct {
+	API         apiFileConfig                  `yaml:"api"`
+	Collection  collectionFileConfig           `yaml:"collection"`
+	Collectors  map[string]collectorFileConfig `yaml:"collectors"`
+	Events      eventsFileConfig               `yaml:"events"`
+	EventIndex  eventIndexFileConfig           `yaml:"eventIndex"`
+	Labels      labelsFileConfig               `yaml:"labels"`
+	Log         logFileConfig                  `yaml:"log"`
+	OTLP        otlpFileConfig                 `yaml:"otlp"`
+	Pushgateway pushgatewayFileConfig          `yaml:"pushgateway"`
+	RemoteWrite remoteWriteFileConfig          `yaml:"remoteWrite"`
+	StatsD      statsdFileConfig               `yaml:"statsd"`
+	Substrate   substrateFileConfig            `yaml:"substrate"`
+	Server      serverFileConfig               `yaml:"server"`
+}
+
+// apiFileConfig represents configuration file settings for connecting to the
+// Brigade API.
+type apiFileConfig struct {
+	Address            string         `yaml:"address"`
+	Token              string         `yaml:"token"`
+	IgnoreCertWarnings *bool          `yaml:"ignoreCertWarnings"`
+	RequestTimeout     *time.Duration `yaml:"requestTimeout"`
+	PaginationMode     string         `yaml:"paginationMode"`
+	PageSize           *int           `yaml:"pageSize"`
+	PreflightMode      string         `yaml:"preflightMode"`
+	PreflightTimeout   *time.Duration `yaml:"preflightTimeout"`
+}
+
+// collectionFileConfig represents configuration file settings governing when
+// the exporter queries the Brigade API.
+type collectionFileConfig struct {
+	Mode                      string         `yaml:"mode"`
+	ScrapeInterval            *time.Duration `yaml:"scrapeInterval"`
+	MinRefreshInterval        *time.Duration `yaml:"minRefreshInterval"`
+	ReadinessFailureThreshold *time.Duration `yaml:"readinessFailureThreshold"`
+	BackoffMaxInterval        *time.Duration `yaml:"backoffMaxInterval"`
+}
+
+// collectorFileConfig represents configuration file settings for an individual
+// collector.
+type collectorFileConfig struct {
+	Enabled  *bool          `yaml:"enabled"`
+	Interval *time.Duration `yaml:"interval"`
+}
+
+// eventsFileConfig represents configuration file settings governing how
+// Events are broken down.
+type eventsFileConfig struct {
+	SourcePattern     string         `yaml:"sourcePattern"`
+	TypePattern       string         `yaml:"typePattern"`
+	MaxWorkerLifetime *time.Duration `yaml:"maxWorkerLifetime"`
+}
+
+// eventIndexFileConfig represents configuration file settings for the
+// in-memory event index.
+type eventIndexFileConfig struct {
+	Enabled        *bool          `yaml:"enabled"`
+	SyncInterval   *time.Duration `yaml:"syncInterval"`
+	ResyncInterval *time.Duration `yaml:"resyncInterval"`
+}
+
+// labelsFileConfig represents configuration file settings governing the
+// cardinality of labels.
+type labelsFileConfig struct {
+	ValueLimit        *int           `yaml:"valueLimit"`
+	MetricValueLimits map[string]int `yaml:"metricValueLimits"`
+}
+
+// logFileConfig represents configuration file settings for logging.
+type logFileConfig struct {
+	Level               string         `yaml:"level"`
+	Format              string         `yaml:"format"`
+	ErrorRepeatInterval *time.Duration `yaml:"errorRepeatInterval"`
+}
+
+// otlpFileConfig represents configuration file settings for pushing metrics
+// via OTLP.
+type otlpFileConfig struct {
+	Enabled            *bool             `yaml:"enabled"`
+	Protocol           string            `yaml:"protocol"`
+	Endpoint           string            `yaml:"endpoint"`
+	Insecure           *bool             `yaml:"insecure"`
+	Headers            map[string]string `yaml:"headers"`
+	PushInterval       *time.Duration    `yaml:"pushInterval"`
+	Timeout            *time.Duration    `yaml:"timeout"`
+	ResourceAttributes map[string]string `yaml:"resourceAttributes"`
+}
+
+// pushgatewayFileConfig represents configuration file settings for pushing
+// metrics to a Prometheus Pushgateway.
+type pushgatewayFileConfig struct {
+	Enabled      *bool             `yaml:"enabled"`
+	URL          string            `yaml:"url"`
+	Job          string            `yaml:"job"`
+	Grouping     map[string]string `yaml:"grouping"`
+	Headers      map[string]string `yaml:"headers"`
+	PushInterval *time.Duration    `yaml:"pushInterval"`
+	Timeout      *time.Duration    `yaml:"timeout"`
+}
+
+// remoteWriteFileConfig represents configuration file settings for sending
+// metrics to a Prometheus remote-write endpoint.
+type remoteWriteFileConfig struct {
+	Enabled      *bool             `yaml:"enabled"`
+	URL          string            `yaml:"url"`
+	Headers      map[string]string `yaml:"headers"`
+	Labels       map[string]string `yaml:"labels"`
+	PushInterval *time.Duration    `yaml:"pushInterval"`
+	Timeout      *time.Duration    `yaml:"timeout"`
+}
+
+// statsdFileConfig represents configuration file settings for sending metrics
+// to a StatsD or DogStatsD server.
+type statsdFileConfig struct {
+	Enabled      *bool             `yaml:"enabled"`
+	Flavor       string            `yaml:"flavor"`
+	Host         string            `yaml:"host"`
+	Port         *int              `yaml:"port"`
+	Prefix       string            `yaml:"prefix"`
+	Tags         map[string]string `yaml:"tags"`
+	PushInterval *time.Duration    `yaml:"pushInterval"`
+}
+
+// substrateFileConfig represents configuration file settings describing the
+// capacity of the substrate.
+type substrateFileConfig struct {
+	MaxConcurrentWorkers *int `yaml:"maxConcurrentWorkers"`
+}
+
+// serverFileConfig represents configuration file settings for the HTTP/S
+// server.
+type serverFileConfig struct {
+	Port            *int                 `yaml:"port"`
+	MetricsEndpoint *bool                `yaml:"metricsEndpoint"`
+	MetricsAuth     serverAuthFileConfig `yaml:"metricsAuth"`
+	TLS             serverTLSFileConfig  `yaml:"tls"`
+}
+
+// serverAuthFileConfig represents configuration file settings for
+// authenticating requests to the HTTP/S server.
+type serverAuthFileConfig struct {
+	TokenFile    string `yaml:"tokenFile"`
+	Username     string `yaml:"username"`
+	PasswordFile string `yaml:"passwordFile"`
+}
+
+// serverTLSFileConfig represents configuration file settings for the HTTP/S
+// server's TLS.
+type serverTLSFileConfig struct {
+	Enabled    *bool                      `yaml:"enabled"`
+	CertPath   string                     `yaml:"certPath"`
+	KeyPath    string                     `yaml:"keyPath"`
+	ClientAuth serverClientAuthFileConfig `yaml:"clientAuth"`
+}
+
+// serverClientAuthFileConfig represents configuration file settings for
+// requiring clients of the HTTPS server to present certificates.
+type serverClientAuthFileConfig struct {
+	Enabled        *bool    `yaml:"enabled"`
+	CAPath         string   `yaml:"caPath"`
+	AllowedClients []string `yaml:"allowedClients"`
+}
+
+// loadFileConfig reads and validates the configuration file at the specified
+// path. If the path is empty, an empty fileConfig is returned. Unlike errors
+// in environment variables, which are reported one at a time, every problem
+// found in the file is reported at once.
+func loadFileConfig(path string) (fileConfig, error) {
+	config := fileConfig{}
+	if path == "" {
+		return config, nil
+	}
+	configBytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return config, errors.Wrapf(err, "error reading config file %s", path)
+	}
+	problems := []string{}
+	decoder := yaml.NewDecoder(bytes.NewReader(configBytes))
+	decoder.KnownFields(true)
+	if err = decoder.Decode(&config); err != nil && err != io.EOF {
+		typeErr, ok := err.(*yaml.TypeError)
+		if !ok {
+			return config, errors.Wrapf(err, "error parsing config file %s", path)
+		}
+		// Unknown keys and values of the wrong type are all reported together
+		problems = append(problems, typeErr.Errors...)
+	}
+	problems = append(problems, config.validate()...)
+	if len(problems) > 0 {
+		return config, errors.Errorf(
+			"config file %s is invalid:\n  %s",
+			path,
+			strings.Join(problems, "\n  "),
+		)
+	}
+	return config, nil
+}
+
+// validate returns a description of every invalid value in the fileConfig.
+func (f fileConfig) validate() []string {
+	problems := []string{}
+	for _, sectionProblems := range [][]string{
+		f.API.validate(),
+		f.Collection.validate(),
+		validateCollectors(f.Collectors),
+		f.Events.validate(),
+		f.EventIndex.validate(),
+		f.Labels.validate(),
+		f.Log.validate(),
+		f.OTLP.validate(),
+		f.Pushgateway.validate(),
+		f.RemoteWrite.validate(),
+		f.StatsD.validate(),
+		f.Substrate.validate(),
+	} {
+		problems = append(problems, sectionProblems...)
+	}
+	return problems
+}
+
+// validate returns a description of every invalid value in the api section
+// of the configuration file.
+func (a apiFileConfig) validate() []string {
+	problems := []string{}
+	switch paginationMode(a.PaginationMode) {
+	case "", paginationModeRemainingCount, paginationModeWalk:
+	default:
+		problems = append(
+			problems,
+			"api.paginationMode: must be one of "+
+				`"remaining-count" or "walk"`,
+		)
+	}
+	if a.PageSize != nil && *a.PageSize < 0 {
+		problems = append(problems, "api.pageSize: must not be negative")
+	}
+	switch preflightMode(a.PreflightMode) {
+	case "", preflightModeFail, preflightModeDegrade, preflightModeOff:
+	default:
+		problems = append(
+			problems,
+			`api.preflightMode: must be one of "fail", "degrade", or "off"`,
+		)
+	}
+	if a.PreflightTimeout != nil && *a.PreflightTimeout < 0 {
+		problems = append(problems, "api.preflightTimeout: must not be negative")
+	}
+	if a.RequestTimeout != nil && *a.RequestTimeout < 0 {
+		problems = append(problems, "api.requestTimeout: must not be negative")
+	}
+	return problems
+}
+
+// validate returns a description of every invalid value in the collection
+// section of the configuration file.
+func (c collectionFileConfig) validate() []string {
+	problems := []string{}
+	switch collectionMode(c.Mode) {
+	case "", collectionModePoll, collectionModeOnDemand:
+	default:
+		problems = append(
+			problems,
+			`collection.mode: must be one of "poll" or "on-demand"`,
+		)
+	}
+	if c.ScrapeInterval != nil && *c.ScrapeInterval <= 0 {
+		problems = append(problems, "collection.scrapeInterval: must be positive")
+	}
+	if c.MinRefreshInterval != nil && *c.MinRefreshInterval <= 0 {
+		problems = append(
+			problems,
+			"collection.minRefreshInterval: must be positive",
+		)
+	}
+	if c.ReadinessFailureThreshold != nil && *c.ReadinessFailureThreshold < 0 {
+		problems = append(
+			problems,
+			"collection.readinessFailureThreshold: must not be negative",
+		)
+	}
+	if c.BackoffMaxInterval != nil && *c.BackoffMaxInterval < 0 {
+		problems = append(
+			problems,
+			"collection.backoffMaxInterval: must not be negative",
+		)
+	}
+	return problems
+}
+
+// validateCollectors returns a description of every invalid value in the
+// collectors section of the configuration file.
+func validateCollectors(collectors map[string]collectorFileConfig) []string {
+	problems := []string{}
+	// Sort collector names so that problems are always reported in the same
+	// order
+	names := make([]string, 0, len(collectors))
+	for name := range collectors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		collector := collectors[name]
+		if !isCollectorName(name) {
+			problems = append(
+				problems,
+				"collectors."+name+": no such collector; must be one of "+
+					strings.Join(collectorNames(), ", "),
+			)
+			continue
+		}
+		if collector.Interval != nil && *collector.Interval <= 0 {
+			problems = append(
+				problems,
+				"collectors."+name+".interval: must be positive",
+			)
+		}
+	}
+	return problems
+}
+
+// validate returns a description of every invalid value in the events section
+// of the configuration file.
+func (e eventsFileConfig) validate() []string {
+	problems := []string{}
+	if _, err := compileLabelPattern(e.SourcePattern); err != nil {
+		problems = append(problems, "events.sourcePattern: "+err.Error())
+	}
+	if _, err := compileLabelPattern(e.TypePattern); err != nil {
+		problems = append(problems, "events.typePattern: "+err.Error())
+	}
+	if e.MaxWorkerLifetime != nil && *e.MaxWorkerLifetime < 0 {
+		problems = append(
+			problems,
+			"events.maxWorkerLifetime: must not be negative",
+		)
+	}
+	return problems
+}
+
+// validate returns a description of every invalid value in the eventIndex
+// section of the configuration file.
+func (e eventIndexFileConfig) validate() []string {
+	problems := []string{}
+	if e.SyncInterval != nil && *e.SyncInterval <= 0 {
+		problems = append(problems, "eventIndex.syncInterval: must be positive")
+	}
+	if e.ResyncInterval != nil && *e.ResyncInterval <= 0 {
+		problems = append(problems, "eventIndex.resyncInterval: must be positive")
+	}
+	return problems
+}
+
+// validate returns a description of every invalid value in the labels section
+// of the configuration file.
+func (l labelsFileConfig) validate() []string {
+	problems := []string{}
+	if l.ValueLimit != nil && *l.ValueLimit < 0 {
+		problems = append(problems, "labels.valueLimit: must not be negative")
+	}
+	metricNames := make([]string, 0, len(l.MetricValueLimits))
+	for name := range l.MetricValueLimits {
+		metricNames = append(metricNames, name)
+	}
+	sort.Strings(metricNames)
+	for _, name := range metricNames {
+		if !isLabelLimitedMetricName(name) {
+			problems = append(
+				problems,
+				"labels.metricValueLimits."+name+": no such metric; must be one "+
+					"of "+strings.Join(labelLimitedMetricNames(), ", "),
+			)
+		} else if l.MetricValueLimits[name] < 0 {
+			problems = append(
+				problems,
+				"labels.metricValueLimits."+name+": must not be negative",
+			)
+		}
+	}
+	return problems
+}
+
+// validate returns a description of every invalid value in the log section of
+// the configuration file.
+func (l logFileConfig) validate() []string {
+	problems := []string{}
+	if l.Level != "" {
+		if _, ok := parseLogLevel(l.Level); !ok {
+			problems = append(
+				problems,
+				`log.level: must be one of "debug", "info", "warn", or "error"`,
+			)
+		}
+	}
+	switch logFormat(l.Format) {
+	case "", logFormatLogfmt, logFormatJSON:
+	default:
+		problems = append(
+			problems,
+			`log.format: must be one of "logfmt" or "json"`,
+		)
+	}
+	if l.ErrorRepeatInterval != nil && *l.ErrorRepeatInterval < 0 {
+		problems = append(
+			problems,
+			"log.errorRepeatInterval: must not be negative",
+		)
+	}
+	return problems
+}
+
+// validate returns a description of every invalid value in the otlp section
+// of the configuration file.
+func (o otlpFileConfig) validate() []string {
+	problems := []string{}
+	switch otlpProtocol(o.Protocol) {
+	case "", otlpProtocolGRPC, otlpProtocolHTTP:
+	default:
+		problems = append(
+			problems,
+			`otlp.protocol: must be one of "grpc" or "http/protobuf"`,
+		)
+	}
+	if o.PushInterval != nil && *o.PushInterval <= 0 {
+		problems = append(problems, "otlp.pushInterval: must be positive")
+	}
+	if o.Timeout != nil && *o.Timeout < 0 {
+		problems = append(problems, "otlp.timeout: must not be negative")
+	}
+	return problems
+}
+
+// validate returns a description of every invalid value in the pushgateway
+// section of the configuration file.
+func (p pushgatewayFileConfig) validate() []string {
+	problems := []string{}
+	if p.PushInterval != nil && *p.PushInterval <= 0 {
+		problems = append(problems, "pushgateway.pushInterval: must be positive")
+	}
+	if p.Timeout != nil && *p.Timeout < 0 {
+		problems = append(problems, "pushgateway.timeout: must not be negative")
+	}
+	return problems
+}
+
+// validate returns a description of every invalid value in the remoteWrite
+// section of the configuration file.
+func (r remoteWriteFileConfig) validate() []string {
+	problems := []string{}
+	if r.PushInterval != nil && *r.PushInterval <= 0 {
+		problems = append(problems, "remoteWrite.pushInterval: must be positive")
+	}
+	if r.Timeout != nil && *r.Timeout < 0 {
+		problems = append(problems, "remoteWrite.timeout: must not be negative")
+	}
+	return problems
+}
+
+// validate returns a description of every invalid value in the statsd section
+// of the configuration file.
+func (s statsdFileConfig) validate() []string {
+	problems := []string{}
+	switch statsdFlavor(s.Flavor) {
+	case "", statsdFlavorDogStatsD, statsdFlavorStatsD:
+	default:
+		problems = append(
+			problems,
+			`statsd.flavor: must be one of "dogstatsd" or "statsd"`,
+		)
+	}
+	if s.Port != nil && (*s.Port < 1 || *s.Port > 65535) {
+		problems = append(problems, "statsd.port: must be between 1 and 65535")
+	}
+	if s.PushInterval != nil && *s.PushInterval <= 0 {
+		problems = append(problems, "statsd.pushInterval: must be positive")
+	}
+	return problems
+}
+
+// validate returns a description of every invalid value in the substrate
+// section of the configuration file.
+func (s substrateFileConfig) validate() []string {
+	if s.MaxConcurrentWorkers != nil && *s.MaxConcurrentWorkers < 0 {
+		return []string{"substrate.maxConcurrentWorkers: must not be negative"}
+	}
+	return nil
+}
+
 // apiClientConfig populates the Brigade SDK's APIClientOptions from
-// environment variables.
-func apiClientConfig() (string, string, restmachinery.APIClientOptions, error) {
+// environment variables, falling back to values from the configuration file.
+func apiClientConfig(
+	file fileConfig,
+) (string, string, restmachinery.APIClientOptions, error) {
 	opts := restmachinery.APIClientOptions{}
-	address, err := os.GetRequiredEnvVar("API_ADDRESS")
+	address, err := getRequiredEnvVar("API_ADDRESS", file.API.Address)
 	if err != nil {
 		return address, "", opts, err
 	}
-	token, err := os.GetRequiredEnvVar("API_TOKEN")
+	token, err := getRequiredEnvVar("API_TOKEN", file.API.Token)
 	if err != nil {
 		return address, token, opts, err
 	}
-	opts.AllowInsecureConnections, err =
-		os.GetBoolFromEnvVar("API_IGNORE_CERT_WARNINGS", false)
+	opts.AllowInsecureConnections, err = os.GetBoolFromEnvVar(
+		"API_IGNORE_CERT_WARNINGS",
+		boolOrDefault(file.API.IgnoreCertWarnings, false),
+	)
 	return address, token, opts, err
 }
 
-func scrapeDuration() (time.Duration, error) {
-	return os.GetDurationFromEnvVar("PROM_SCRAPE_INTERVAL", 2*time.Second)
+// exporterConfig populates configuration for the metrics exporter from
+// environment variables, falling back to values from the configuration file.
+func exporterConfig(file fileConfig) (metricsExporterConfig, error) {
+	config := metricsExporterConfig{}
+	for _, sectionConfig := range []func(
+		fileConfig,
+		*metricsExporterConfig,
+	) error{
+		collectionConfig,
+		apiConfig,
+		eventsConfig,
+		eventIndexConfig,
+		labelsConfig,
+	} {
+		if err := sectionConfig(file, &config); err != nil {
+			return config, err
+		}
+	}
+	var err error
+	config.Collectors, err = collectorsConfig(file)
+	return config, err
+}
+
+// collectionConfig populates the metrics exporter's configuration governing
+// when the Brigade API is queried from environment variables, falling back to
+// values from the configuration file.
+func collectionConfig(file fileConfig, config *metricsExporterConfig) error {
+	config.CollectionMode = collectionMode(
+		os.GetEnvVar(
+			"COLLECTION_MODE",
+			stringOrDefault(file.Collection.Mode, string(collectionModePoll)),
+		),
+	)
+	switch config.CollectionMode {
+	case collectionModePoll, collectionModeOnDemand:
+	default:
+		return errors.Errorf(
+			"COLLECTION_MODE %q is invalid; must be one of %q or %q",
+			config.CollectionMode,
+			collectionModePoll,
+			collectionModeOnDemand,
+		)
+	}
+	var err error
+	config.ScrapeInterval, err = os.GetDurationFromEnvVar(
+		"PROM_SCRAPE_INTERVAL",
+		durationOrDefault(file.Collection.ScrapeInterval, 2*time.Second),
+	)
+	if err != nil {
+		return err
+	}
+	if config.ScrapeInterval <= 0 {
+		return errors.Errorf(
+			"PROM_SCRAPE_INTERVAL %s is invalid; must be positive",
+			config.ScrapeInterval,
+		)
+	}
+	config.MinRefreshInterval, err = os.GetDurationFromEnvVar(
+		"MIN_REFRESH_INTERVAL",
+		durationOrDefault(file.Collection.MinRefreshInterval, 2*time.Second),
+	)
+	if err != nil {
+		return err
+	}
+	if config.MinRefreshInterval <= 0 {
+		return errors.Errorf(
+			"MIN_REFRESH_INTERVAL %s is invalid; must be positive",
+			config.MinRefreshInterval,
+		)
+	}
+	config.ReadinessFailureThreshold, err = os.GetDurationFromEnvVar(
+		"READINESS_FAILURE_THRESHOLD",
+		durationOrDefault(
+			file.Collection.ReadinessFailureThreshold,
+			5*time.Minute,
+		),
+	)
+	if err != nil {
+		return err
+	}
+	if config.ReadinessFailureThreshold < 0 {
+		return errors.Errorf(
+			"READINESS_FAILURE_THRESHOLD %s is invalid; must not be negative",
+			config.ReadinessFailureThreshold,
+		)
+	}
+	config.BackoffMaxInterval, err = os.GetDurationFromEnvVar(
+		"COLLECTOR_BACKOFF_MAX_INTERVAL",
+		durationOrDefault(file.Collection.BackoffMaxInterval, 5*time.Minute),
+	)
+	if err != nil {
+		return err
+	}
+	if config.BackoffMaxInterval < 0 {
+		return errors.Errorf(
+			"COLLECTOR_BACKOFF_MAX_INTERVAL %s is invalid; must not be negative",
+			config.BackoffMaxInterval,
+		)
+	}
+	return nil
+}
+
+// apiConfig populates the metrics exporter's configuration governing how the
+// Brigade API is queried from environment variables, falling back to values
+// from the configuration file.
+func apiConfig(file fileConfig, config *metricsExporterConfig) error {
+	config.PaginationMode = paginationMode(
+		os.GetEnvVar(
+			"PAGINATION_MODE",
+			stringOrDefault(
+				file.API.PaginationMode,
+				string(paginationModeRemainingCount),
+			),
+		),
+	)
+	switch config.PaginationMode {
+	case paginationModeRemainingCount, paginationModeWalk:
+	default:
+		return errors.Errorf(
+			"PAGINATION_MODE %q is invalid; must be one of %q or %q",
+			config.PaginationMode,
+			paginationModeRemainingCount,
+			paginationModeWalk,
+		)
+	}
+	config.PreflightMode = preflightMode(
+		os.GetEnvVar(
+			"PREFLIGHT_MODE",
+			stringOrDefault(file.API.PreflightMode, string(preflightModeFail)),
+		),
+	)
+	switch config.PreflightMode {
+	case preflightModeFail, preflightModeDegrade, preflightModeOff:
+	default:
+		return errors.Errorf(
+			"PREFLIGHT_MODE %q is invalid; must be one of %q, %q, or %q",
+			config.PreflightMode,
+			preflightModeFail,
+			preflightModeDegrade,
+			preflightModeOff,
+		)
+	}
+	pageSize, err :=
+		os.GetIntFromEnvVar("API_PAGE_SIZE", intOrDefault(file.API.PageSize, 0))
+	if err != nil {
+		return err
+	}
+	if pageSize < 0 {
+		return errors.Errorf(
+			"API_PAGE_SIZE %d is invalid; must not be negative",
+			pageSize,
+		)
+	}
+	config.PageSize = int64(pageSize)
+	config.PreflightTimeout, err = os.GetDurationFromEnvVar(
+		"PREFLIGHT_TIMEOUT",
+		durationOrDefault(file.API.PreflightTimeout, 2*time.Minute),
+	)
+	if err != nil {
+		return err
+	}
+	if config.PreflightTimeout < 0 {
+		return errors.Errorf(
+			"PREFLIGHT_TIMEOUT %s is invalid; must not be negative",
+			config.PreflightTimeout,
+		)
+	}
+	config.APIRequestTimeout, err = os.GetDurationFromEnvVar(
+		"API_REQUEST_TIMEOUT",
+		durationOrDefault(file.API.RequestTimeout, 10*time.Second),
+	)
+	if err != nil {
+		return err
+	}
+	if config.APIRequestTimeout < 0 {
+		return errors.Errorf(
+			"API_REQUEST_TIMEOUT %s is invalid; must not be negative",
+			config.APIRequestTimeout,
+		)
+	}
+	return nil
+}
+
+// eventsConfig populates the metrics exporter's configuration governing how
+// Events and the substrate are reported on from environment variables, falling
+// back to values from the configuration file.
+func eventsConfig(file fileConfig, config *metricsExporterConfig) error {
+	var err error
+	config.MaxConcurrentWorkers, err = os.GetIntFromEnvVar(
+		"MAX_CONCURRENT_WORKERS",
+		intOrDefault(file.Substrate.MaxConcurrentWorkers, 0),
+	)
+	if err != nil {
+		return err
+	}
+	if config.MaxConcurrentWorkers < 0 {
+		return errors.Errorf(
+			"MAX_CONCURRENT_WORKERS %d is invalid; must not be negative",
+			config.MaxConcurrentWorkers,
+		)
+	}
+	eventSourcePattern :=
+		os.GetEnvVar("EVENT_SOURCE_PATTERN", file.Events.SourcePattern)
+	if config.EventSourcePattern, err =
+		compileLabelPattern(eventSourcePattern); err != nil {
+		return errors.Wrapf(
+			err,
+			"EVENT_SOURCE_PATTERN %q is invalid",
+			eventSourcePattern,
+		)
+	}
+	eventTypePattern :=
+		os.GetEnvVar("EVENT_TYPE_PATTERN", file.Events.TypePattern)
+	if config.EventTypePattern, err =
+		compileLabelPattern(eventTypePattern); err != nil {
+		return errors.Wrapf(
+			err,
+			"EVENT_TYPE_PATTERN %q is invalid",
+			eventTypePattern,
+		)
+	}
+	config.MaxWorkerLifetime, err = os.GetDurationFromEnvVar(
+		"MAX_WORKER_LIFETIME",
+		durationOrDefault(file.Events.MaxWorkerLifetime, 24*time.Hour),
+	)
+	if err != nil {
+		return err
+	}
+	if config.MaxWorkerLifetime < 0 {
+		return errors.Errorf(
+			"MAX_WORKER_LIFETIME %s is invalid; must not be negative",
+			config.MaxWorkerLifetime,
+		)
+	}
+	return nil
+}
+
+// eventIndexConfig populates the metrics exporter's configuration for the
+// in-memory event index from environment variables, falling back to values
+// from the configuration file.
+func eventIndexConfig(file fileConfig, config *metricsExporterConfig) error {
+	var err error
+	config.EventIndexEnabled, err = os.GetBoolFromEnvVar(
+		"EVENT_INDEX_ENABLED",
+		boolOrDefault(file.EventIndex.Enabled, false),
+	)
+	if err != nil {
+		return err
+	}
+	config.EventIndexSyncInterval, err = os.GetDurationFromEnvVar(
+		"EVENT_INDEX_SYNC_INTERVAL",
+		durationOrDefault(file.EventIndex.SyncInterval, 2*time.Second),
+	)
+	if err != nil {
+		return err
+	}
+	if config.EventIndexSyncInterval <= 0 {
+		return errors.Errorf(
+			"EVENT_INDEX_SYNC_INTERVAL %s is invalid; must be positive",
+			config.EventIndexSyncInterval,
+		)
+	}
+	config.EventIndexResyncInterval, err = os.GetDurationFromEnvVar(
+		"EVENT_INDEX_RESYNC_INTERVAL",
+		durationOrDefault(file.EventIndex.ResyncInterval, 10*time.Minute),
+	)
+	if err != nil {
+		return err
+	}
+	if config.EventIndexResyncInterval <= 0 {
+		return errors.Errorf(
+			"EVENT_INDEX_RESYNC_INTERVAL %s is invalid; must be positive",
+			config.EventIndexResyncInterval,
+		)
+	}
+	return nil
+}
+
+// labelsConfig populates the metrics exporter's configuration governing the
+// cardinality of labels from environment variables, falling back to values
+// from the configuration file.
+func labelsConfig(file fileConfig, config *metricsExporterConfig) error {
+	var err error
+	config.LabelValueLimit, err = os.GetIntFromEnvVar(
+		"LABEL_VALUE_LIMIT",
+		intOrDefault(file.Labels.ValueLimit, 500),
+	)
+	if err != nil {
+		return err
+	}
+	if config.LabelValueLimit < 0 {
+		return errors.Errorf(
+			"LABEL_VALUE_LIMIT %d is invalid; must not be negative",
+			config.LabelValueLimit,
+		)
+	}
+	config.LabelValueLimits, err = labelValueLimitsConfig(file)
+	return err
+}
+
+// loggingConfig populates configuration for logging from environment
+// variables, falling back to values from the configuration file.
+func loggingConfig(file fileConfig) (logConfig, error) {
+	config := logConfig{}
+	level := os.GetEnvVar("LOG_LEVEL", stringOrDefault(file.Log.Level, "info"))
+	var ok bool
+	if config.Level, ok = parseLogLevel(level); !ok {
+		return config, errors.Errorf(
+			"LOG_LEVEL %q is invalid; must be one of %q, %q, %q, or %q",
+			level,
+			logLevelDebug,
+			logLevelInfo,
+			logLevelWarn,
+			logLevelError,
+		)
+	}
+	config.Format = logFormat(
+		os.GetEnvVar(
+			"LOG_FORMAT",
+			stringOrDefault(file.Log.Format, string(logFormatLogfmt)),
+		),
+	)
+	switch config.Format {
+	case logFormatLogfmt, logFormatJSON:
+	default:
+		return config, errors.Errorf(
+			"LOG_FORMAT %q is invalid; must be one of %q or %q",
+			config.Format,
+			logFormatLogfmt,
+			logFormatJSON,
+		)
+	}
+	var err error
+	config.ErrorRepeatInterval, err = os.GetDurationFromEnvVar(
+		"LOG_ERROR_REPEAT_INTERVAL",
+		durationOrDefault(file.Log.ErrorRepeatInterval, 5*time.Minute),
+	)
+	if err != nil {
+		return config, err
+	}
+	if config.ErrorRepeatInterval < 0 {
+		return config, errors.Errorf(
+			"LOG_ERROR_REPEAT_INTERVAL %s is invalid; must not be negative",
+			config.ErrorRepeatInterval,
+		)
+	}
+	return config, nil
+}
+
+// labelValueLimitsConfig populates per-metric label value limits from the
+// LABEL_VALUE_LIMITS environment variable, which is a comma-delimited list of
+// <metric name>=<limit> pairs. Limits for metrics not named there fall back to
+// values from the configuration file.
+func labelValueLimitsConfig(file fileConfig) (map[string]int, error) {
+	var limits map[string]int
+	for name, limit := range file.Labels.MetricValueLimits {
+		if limits == nil {
+			limits = map[string]int{}
+		}
+		limits[name] = limit
+	}
+	limitsStr := os.GetEnvVar("LABEL_VALUE_LIMITS", "")
+	if limitsStr == "" {
+		return limits, nil
+	}
+	for _, pair := range strings.Split(limitsStr, ",") {
+		tokens := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(tokens) != 2 {
+			return limits, errors.Errorf(
+				"LABEL_VALUE_LIMITS entry %q is invalid; must be of the form "+
+					"<metric name>=<limit>",
+				pair,
+			)
+		}
+		name := tokens[0]
+		if !isLabelLimitedMetricName(name) {
+			return limits, errors.Errorf(
+				"LABEL_VALUE_LIMITS entry %q is invalid; no such metric",
+				pair,
+			)
+		}
+		limit, err := strconv.Atoi(tokens[1])
+		if err != nil || limit < 0 {
+			return limits, errors.Errorf(
+				"LABEL_VALUE_LIMITS entry %q is invalid; limit must be a "+
+					"non-negative int",
+				pair,
+			)
+		}
+		if limits == nil {
+			limits = map[string]int{}
+		}
+		limits[name] = limit
+	}
+	return limits, nil
+}
+
+// otlpSinkConfig populates configuration for pushing metrics via OTLP from
+// environment variables, falling back to values from the configuration file.
+func otlpSinkConfig(file fileConfig) (otlpConfig, error) {
+	config := otlpConfig{}
+	var err error
+	if config.Enabled, err = os.GetBoolFromEnvVar(
+		"OTLP_ENABLED",
+		boolOrDefault(file.OTLP.Enabled, false),
+	); err != nil || !config.Enabled {
+		return config, err
+	}
+	config.Protocol = otlpProtocol(
+		os.GetEnvVar(
+			"OTLP_PROTOCOL",
+			stringOrDefault(file.OTLP.Protocol, string(otlpProtocolGRPC)),
+		),
+	)
+	switch config.Protocol {
+	case otlpProtocolGRPC, otlpProtocolHTTP:
+	default:
+		return config, errors.Errorf(
+			"OTLP_PROTOCOL %q is invalid; must be one of %q or %q",
+			config.Protocol,
+			otlpProtocolGRPC,
+			otlpProtocolHTTP,
+		)
+	}
+	if config.Endpoint, err =
+		getRequiredEnvVar("OTLP_ENDPOINT", file.OTLP.Endpoint); err != nil {
+		return config, err
+	}
+	if config.Insecure, err = os.GetBoolFromEnvVar(
+		"OTLP_INSECURE",
+		boolOrDefault(file.OTLP.Insecure, false),
+	); err != nil {
+		return config, err
+	}
+	if config.Headers, err =
+		keyValuePairsConfig("OTLP_HEADERS", file.OTLP.Headers); err != nil {
+		return config, err
+	}
+	if config.Interval, err = os.GetDurationFromEnvVar(
+		"OTLP_PUSH_INTERVAL",
+		durationOrDefault(file.OTLP.PushInterval, 30*time.Second),
+	); err != nil {
+		return config, err
+	}
+	if config.Interval <= 0 {
+		return config, errors.Errorf(
+			"OTLP_PUSH_INTERVAL %s is invalid; must be positive",
+			config.Interval,
+		)
+	}
+	if config.Timeout, err = os.GetDurationFromEnvVar(
+		"OTLP_TIMEOUT",
+		durationOrDefault(file.OTLP.Timeout, 10*time.Second),
+	); err != nil {
+		return config, err
+	}
+	if config.Timeout < 0 {
+		return config, errors.Errorf(
+			"OTLP_TIMEOUT %s is invalid; must not be negative",
+			config.Timeout,
+		)
+	}
+	config.ResourceAttributes, err = keyValuePairsConfig(
+		"OTLP_RESOURCE_ATTRIBUTES",
+		file.OTLP.ResourceAttributes,
+	)
+	return config, err
+}
+
+// pushgatewaySinkConfig populates configuration for pushing metrics to a
+// Prometheus Pushgateway from environment variables, falling back to values
+// from the configuration file.
+func pushgatewaySinkConfig(file fileConfig) (pushgatewayConfig, error) {
+	config := pushgatewayConfig{}
+	var err error
+	if config.Enabled, err = os.GetBoolFromEnvVar(
+		"PUSHGATEWAY_ENABLED",
+		boolOrDefault(file.Pushgateway.Enabled, false),
+	); err != nil || !config.Enabled {
+		return config, err
+	}
+	if config.URL, err =
+		getRequiredEnvVar("PUSHGATEWAY_URL", file.Pushgateway.URL); err != nil {
+		return config, err
+	}
+	config.Job = os.GetEnvVar(
+		"PUSHGATEWAY_JOB",
+		stringOrDefault(file.Pushgateway.Job, "brigade-metrics-exporter"),
+	)
+	if config.Grouping, err = keyValuePairsConfig(
+		"PUSHGATEWAY_GROUPING",
+		file.Pushgateway.Grouping,
+	); err != nil {
+		return config, err
+	}
+	if config.Headers, err = keyValuePairsConfig(
+		"PUSHGATEWAY_HEADERS",
+		file.Pushgateway.Headers,
+	); err != nil {
+		return config, err
+	}
+	if config.Interval, err = os.GetDurationFromEnvVar(
+		"PUSHGATEWAY_PUSH_INTERVAL",
+		durationOrDefault(file.Pushgateway.PushInterval, 30*time.Second),
+	); err != nil {
+		return config, err
+	}
+	if config.Interval <= 0 {
+		return config, errors.Errorf(
+			"PUSHGATEWAY_PUSH_INTERVAL %s is invalid; must be positive",
+			config.Interval,
+		)
+	}
+	if config.Timeout, err = os.GetDurationFromEnvVar(
+		"PUSHGATEWAY_TIMEOUT",
+		durationOrDefault(file.Pushgateway.Timeout, 10*time.Second),
+	); err != nil {
+		return config, err
+	}
+	if config.Timeout < 0 {
+		return config, errors.Errorf(
+			"PUSHGATEWAY_TIMEOUT %s is invalid; must not be negative",
+			config.Timeout,
+		)
+	}
+	return config, nil
+}
+
+// remoteWriteSinkConfig populates configuration for sending metrics to a
+// Prometheus remote-write endpoint from environment variables, falling back
+// to values from the configuration file.
+func remoteWriteSinkConfig(file fileConfig) (remoteWriteConfig, error) {
+	config := remoteWriteConfig{}
+	var err error
+	if config.Enabled, err = os.GetBoolFromEnvVar(
+		"REMOTE_WRITE_ENABLED",
+		boolOrDefault(file.RemoteWrite.Enabled, false),
+	); err != nil || !config.Enabled {
+		return config, err
+	}
+	if config.URL, err =
+		getRequiredEnvVar("REMOTE_WRITE_URL", file.RemoteWrite.URL); err != nil {
+		return config, err
+	}
+	if config.Headers, err = keyValuePairsConfig(
+		"REMOTE_WRITE_HEADERS",
+		file.RemoteWrite.Headers,
+	); err != nil {
+		return config, err
+	}
+	if config.Labels, err = keyValuePairsConfig(
+		"REMOTE_WRITE_LABELS",
+		file.RemoteWrite.Labels,
+	); err != nil {
+		return config, err
+	}
+	if config.Interval, err = os.GetDurationFromEnvVar(
+		"REMOTE_WRITE_PUSH_INTERVAL",
+		durationOrDefault(file.RemoteWrite.PushInterval, 30*time.Second),
+	); err != nil {
+		return config, err
+	}
+	if config.Interval <= 0 {
+		return config, errors.Errorf(
+			"REMOTE_WRITE_PUSH_INTERVAL %s is invalid; must be positive",
+			config.Interval,
+		)
+	}
+	if config.Timeout, err = os.GetDurationFromEnvVar(
+		"REMOTE_WRITE_TIMEOUT",
+		durationOrDefault(file.RemoteWrite.Timeout, 10*time.Second),
+	); err != nil {
+		return config, err
+	}
+	if config.Timeout < 0 {
+		return config, errors.Errorf(
+			"REMOTE_WRITE_TIMEOUT %s is invalid; must not be negative",
+			config.Timeout,
+		)
+	}
+	return config, nil
+}
+
+// statsdSinkConfig populates configuration for sending metrics to a StatsD or
+// DogStatsD server from environment variables, falling back to values from
+// the configuration file.
+func statsdSinkConfig(file fileConfig) (statsdConfig, error) {
+	config := statsdConfig{}
+	var err error
+	if config.Enabled, err = os.GetBoolFromEnvVar(
+		"STATSD_ENABLED",
+		boolOrDefault(file.StatsD.Enabled, false),
+	); err != nil || !config.Enabled {
+		return config, err
+	}
+	config.Flavor = statsdFlavor(
+		os.GetEnvVar(
+			"STATSD_FLAVOR",
+			stringOrDefault(file.StatsD.Flavor, string(statsdFlavorDogStatsD)),
+		),
+	)
+	switch config.Flavor {
+	case statsdFlavorDogStatsD, statsdFlavorStatsD:
+	default:
+		return config, errors.Errorf(
+			"STATSD_FLAVOR %q is invalid; must be one of %q or %q",
+			config.Flavor,
+			statsdFlavorDogStatsD,
+			statsdFlavorStatsD,
+		)
+	}
+	config.Host = os.GetEnvVar(
+		"STATSD_HOST",
+		stringOrDefault(file.StatsD.Host, "localhost"),
+	)
+	if config.Port, err = os.GetIntFromEnvVar(
+		"STATSD_PORT",
+		intOrDefault(file.StatsD.Port, 8125),
+	); err != nil {
+		return config, err
+	}
+	if config.Port < 1 || config.Port > 65535 {
+		return config, errors.Errorf(
+			"STATSD_PORT %d is invalid; must be between 1 and 65535",
+			config.Port,
+		)
+	}
+	config.Prefix = os.GetEnvVar("STATSD_PREFIX", file.StatsD.Prefix)
+	if config.Tags, err =
+		keyValuePairsConfig("STATSD_TAGS", file.StatsD.Tags); err != nil {
+		return config, err
+	}
+	if config.Interval, err = os.GetDurationFromEnvVar(
+		"STATSD_PUSH_INTERVAL",
+		durationOrDefault(file.StatsD.PushInterval, 10*time.Second),
+	); err != nil {
+		return config, err
+	}
+	if config.Interval <= 0 {
+		return config, errors.Errorf(
+			"STATSD_PUSH_INTERVAL %s is invalid; must be positive",
+			config.Interval,
+		)
+	}
+	return config, nil
+}
+
+// keyValuePairsConfig populates a map from an environment variable having the
+// specified name, whose value is a comma-delimited list of <key>=<value>
+// pairs. Keys not named there fall back to the provided values from the
+// configuration file.
+func keyValuePairsConfig(
+	name string,
+	fileValues map[string]string,
+) (map[string]string, error) {
+	var pairs map[string]string
+	for k, v := range fileValues {
+		if pairs == nil {
+			pairs = map[string]string{}
+		}
+		pairs[k] = v
+	}
+	pairsStr := os.GetEnvVar(name, "")
+	if pairsStr == "" {
+		return pairs, nil
+	}
+	for _, pair := range strings.Split(pairsStr, ",") {
+		tokens := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(tokens) != 2 || tokens[0] == "" {
+			return pairs, errors.Errorf(
+				"%s entry %q is invalid; must be of the form <key>=<value>",
+				name,
+				pair,
+			)
+		}
+		if pairs == nil {
+			pairs = map[string]string{}
+		}
+		pairs[tokens[0]] = tokens[1]
+	}
+	return pairs, nil
+}
+
+// isLabelLimitedMetricName returns true if the specified name is the name of a
+// metric having labels of unbounded cardinality and false otherwise.
+func isLabelLimitedMetricName(name string) bool {
+	for _, n := range labelLimitedMetricNames() {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// compileLabelPattern compiles a regular expression that must match label
+// values in their entirety. An empty pattern compiles to nil, which matches
+// everything.
+func compileLabelPattern(pattern string) (*regexp.Regexp, error) {
+	if pattern == "" {
+		return nil, nil
+	}
+	return regexp.Compile("^(?:" + pattern + ")$")
+}
+
+// collectorsConfig populates configuration for individual collectors from
+// environment variables of the form COLLECTOR_<NAME>_ENABLED and
+// COLLECTOR_<NAME>_INTERVAL, falling back to values from the configuration
+// file. Only collectors with some non-default setting are included in the
+// returned map.
+func collectorsConfig(file fileConfig) (map[string]collectorConfig, error) {
+	var configs map[string]collectorConfig
+	for _, name := range collectorNames() {
+		prefix := "COLLECTOR_" + strings.ToUpper(name)
+		enabled, err := os.GetBoolFromEnvVar(
+			prefix+"_ENABLED",
+			boolOrDefault(file.Collectors[name].Enabled, true),
+		)
+		if err != nil {
+			return configs, err
+		}
+		interval, err := os.GetDurationFromEnvVar(
+			prefix+"_INTERVAL",
+			durationOrDefault(file.Collectors[name].Interval, 0),
+		)
+		if err != nil {
+			return configs, err
+		}
+		if interval < 0 {
+			return configs, errors.Errorf(
+				"%s_INTERVAL %s is invalid; must not be negative",
+				prefix,
+				interval,
+			)
+		}
+		if enabled && interval == 0 {
+			continue
+		}
+		if configs == nil {
+			configs = map[string]collectorConfig{}
+		}
+		configs[name] = collectorConfig{
+			Disabled: !enabled,
+			Interval: interval,
+		}
+	}
+	return configs, nil
+}
+
+// collectorFlags holds command line flags for enabling, disabling, and setting
+// the interval of individual collectors, in the style of node_exporter. For a
+// collector named foo, these are --collector.foo, --no-collector.foo, and
+// --collector.foo.interval.
+type collectorFlags struct {
+	flagSet   *flag.FlagSet
+	enabled   map[string]*bool
+	disabled  map[string]*bool
+	intervals map[string]*time.Duration
+}
+
+// newCollectorFlags defines flags for every collector on the provided
+// FlagSet.
+func newCollectorFlags(flagSet *flag.FlagSet) *collectorFlags {
+	c := &collectorFlags{
+		flagSet:   flagSet,
+		enabled:   map[string]*bool{},
+		disabled:  map[string]*bool{},
+		intervals: map[string]*time.Duration{},
+	}
+	for _, name := range collectorNames() {
+		c.enabled[name] = flagSet.Bool(
+			"collector."+name,
+			true,
+			"enable the "+name+" collector",
+		)
+		c.disabled[name] = flagSet.Bool(
+			"no-collector."+name,
+			false,
+			"disable the "+name+" collector",
+		)
+		usage := "how often the " + name + " collector queries the Brigade " +
+			"API; overrides the exporter-wide interval"
+		if name == collectorEventsBySource {
+			usage += fmt.Sprintf(
+				"; defaults to no more often than every %s unless the event index "+
+					"is enabled, since every run lists every event",
+				eventsBySourceMinInterval,
+			)
+		}
+		c.intervals[name] = flagSet.Duration(
+			"collector."+name+".interval",
+			0,
+			usage,
+		)
+	}
+	return c
+}
+
+// apply returns a copy of the provided collector configuration with any
+// settings from flags that were explicitly set on the command line applied on
+// top. Flags take precedence over both environment variables and the
+// configuration file.
+func (c *collectorFlags) apply(
+	configs map[string]collectorConfig,
+) (map[string]collectorConfig, error) {
+	applied := map[string]collectorConfig{}
+	for name, config := range configs {
+		applied[name] = config
+	}
+	var err error
+	visited := map[string]bool{}
+	c.flagSet.Visit(func(f *flag.Flag) {
+		visited[f.Name] = true
+		for _, name := range collectorNames() {
+			config := applied[name]
+			switch f.Name {
+			case "collector." + name:
+				config.Disabled = !*c.enabled[name]
+			case "no-collector." + name:
+				config.Disabled = *c.disabled[name]
+			case "collector." + name + ".interval":
+				if *c.intervals[name] < 0 {
+					err = errors.Errorf(
+						"--%s %s is invalid; must not be negative",
+						f.Name,
+						*c.intervals[name],
+					)
+				}
+				config.Interval = *c.intervals[name]
+			default:
+				continue
+			}
+			applied[name] = config
+		}
+	})
+	if err != nil {
+		return applied, err
+	}
+	// Flags are visited in lexicographical order, not in the order in which
+	// they were given, so rather than let one of a contradictory pair silently
+	// win, refuse the pair altogether
+	for _, name := range collectorNames() {
+		if visited["collector."+name] && visited["no-collector."+name] &&
+			*c.enabled[name] == *c.disabled[name] {
+			return applied, errors.Errorf(
+				"--collector.%s and --no-collector.%s contradict one another",
+				name,
+				name,
+			)
+		}
+	}
+	return applied, nil
 }
 
 // serverConfig populates configuration for the HTTP/S server from environment
-// variables.
-func serverConfig() (http.ServerConfig, error) {
+// variables, falling back to values from the configuration file.
+func serverConfig(file fileConfig) (http.ServerConfig, error) {
 	config := http.ServerConfig{}
 	var err error
-	config.Port, err = os.GetIntFromEnvVar("RECEIVER_PORT", 8080)
+	config.Port, err =
+		os.GetIntFromEnvVar("RECEIVER_PORT", intOrDefault(file.Server.Port, 8080))
 	if err != nil {
 		return config, err
 	}
-	config.TLSEnabled, err = os.GetBoolFromEnvVar("TLS_ENABLED", false)
+	config.TLSEnabled, err = os.GetBoolFromEnvVar(
+		"TLS_ENABLED",
+		boolOrDefault(file.Server.TLS.Enabled, false),
+	)
 	if err != nil {
 		return config, err
 	}
 	if config.TLSEnabled {
-		config.TLSCertPath, err = os.GetRequiredEnvVar("TLS_CERT_PATH")
+		config.TLSCertPath, err =
+			getRequiredEnvVar("TLS_CERT_PATH", file.Server.TLS.CertPath)
 		if err != nil {
 			return config, err
 		}
-		config.TLSKeyPath, err = os.GetRequiredEnvVar("TLS_KEY_PATH")
+		config.TLSKeyPath, err =
+			getRequiredEnvVar("TLS_KEY_PATH", file.Server.TLS.KeyPath)
 		if err != nil {
 			return config, err
 		}
 	}
 	return config, nil
 }
+
+// metricsEndpointEnabled determines from environment variables, falling back
+// to values from the configuration file, whether metrics should be served for
+// scraping at /metrics.
+func metricsEndpointEnabled(file fileConfig) (bool, error) {
+	return os.GetBoolFromEnvVar(
+		"METRICS_ENDPOINT_ENABLED",
+		boolOrDefault(file.Server.MetricsEndpoint, true),
+	)
+}
+
+// tlsClientAuthConfig populates configuration for requiring clients of the
+// HTTPS server to present certificates from environment variables, falling
+// back to values from the configuration file.
+func tlsClientAuthConfig(file fileConfig) (clientAuthConfig, error) {
+	config := clientAuthConfig{}
+	var err error
+	if config.Enabled, err = os.GetBoolFromEnvVar(
+		"TLS_CLIENT_AUTH_ENABLED",
+		boolOrDefault(file.Server.TLS.ClientAuth.Enabled, false),
+	); err != nil || !config.Enabled {
+		return config, err
+	}
+	if config.CAPath, err = getRequiredEnvVar(
+		"TLS_CLIENT_CA_PATH",
+		file.Server.TLS.ClientAuth.CAPath,
+	); err != nil {
+		return config, err
+	}
+	config.AllowedClients = file.Server.TLS.ClientAuth.AllowedClients
+	allowedClients := os.GetEnvVar("TLS_ALLOWED_CLIENTS", "")
+	if allowedClients != "" {
+		config.AllowedClients = nil
+		for _, client := range strings.Split(allowedClients, ",") {
+			if client = strings.TrimSpace(client); client != "" {
+				config.AllowedClients = append(config.AllowedClients, client)
+			}
+		}
+	}
+	return config, nil
+}
+
+// metricsAuthConfig populates configuration for authenticating requests to
+// /metrics from environment variables, falling back to values from the
+// configuration file.
+func metricsAuthConfig(file fileConfig) (authConfig, error) {
+	config := authConfig{
+		TokenFile: os.GetEnvVar(
+			"METRICS_AUTH_TOKEN_FILE",
+			file.Server.MetricsAuth.TokenFile,
+		),
+		Username: os.GetEnvVar(
+			"METRICS_AUTH_USERNAME",
+			file.Server.MetricsAuth.Username,
+		),
+		PasswordFile: os.GetEnvVar(
+			"METRICS_AUTH_PASSWORD_FILE",
+			file.Server.MetricsAuth.PasswordFile,
+		),
+	}
+	if config.Username != "" && config.PasswordFile == "" {
+		return config, errors.New(
+			"METRICS_AUTH_PASSWORD_FILE must be set when METRICS_AUTH_USERNAME is",
+		)
+	}
+	if config.PasswordFile != "" && config.Username == "" {
+		return config, errors.New(
+			"METRICS_AUTH_USERNAME must be set when METRICS_AUTH_PASSWORD_FILE is",
+		)
+	}
+	return config, nil
+}
+
+// getRequiredEnvVar retrieves the value of an environment variable having the
+// specified name. If that value is the empty string, the provided value from
+// the configuration file is returned instead. If that is also the empty
+// string, an error is returned.
+func getRequiredEnvVar(name string, fileValue string) (string, error) {
+	if fileValue != "" {
+		return os.GetEnvVar(name, fileValue), nil
+	}
+	return os.GetRequiredEnvVar(name)
+}
+
+func stringOrDefault(val string, defaultValue string) string {
+	if val == "" {
+		return defaultValue
+	}
+	return val
+}
+
+func boolOrDefault(val *bool, defaultValue bool) bool {
+	if val == nil {
+		return defaultValue
+	}
+	return *val
+}
+
+func intOrDefault(val *int, defaultValue int) int {
+	if val == nil {
+		return defaultValue
+	}
+	return *val
+}
+
+func durationOrDefault(
+	val *time.Duration,
+	defaultValue time.Duration,
+) time.Duration {
+	if val == nil {
+		return defaultValue
+	}
+	return *val
+}
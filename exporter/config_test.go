@@ -1,13 +1,250 @@
 package main
 
 import (
+	"flag"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/brigadecore/brigade-foundations/http"
 	"github.com/brigadecore/brigade/sdk/v3/restmachinery"
 	"github.com/stretchr/testify/require"
 )
 
+// writeConfigFile writes the provided contents to a configuration file in a
+// temporary directory and returns its path.
+func writeConfigFile(t *testing.T, contents string) string {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(contents), 0600))
+	return path
+}
+
+func intPtr(i int) *int {
+	return &i
+}
+
+func boolPtr(b bool) *bool {
+	return &b
+}
+
+func durationPtr(d time.Duration) *time.Duration {
+	return &d
+}
+
+func TestLoadFileConfig(t *testing.T) {
+	testCases := []struct {
+		name       string
+		path       func() string
+		assertions func(fileConfig, error)
+	}{
+		{
+			name: "no path",
+			path: func() string { return "" },
+			assertions: func(config fileConfig, err error) {
+				require.NoError(t, err)
+				require.Equal(t, fileConfig{}, config)
+			},
+		},
+		{
+			name: "file does not exist",
+			path: func() string {
+				return filepath.Join(t.TempDir(), "nonexistent.yaml")
+			},
+			assertions: func(_ fileConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "error reading config file")
+			},
+		},
+		{
+			name: "file is not YAML",
+			path: func() string {
+				return writeConfigFile(t, "api: [")
+			},
+			assertions: func(_ fileConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "error parsing config file")
+			},
+		},
+		{
+			name: "empty file",
+			path: func() string {
+				return writeConfigFile(t, "")
+			},
+			assertions: func(config fileConfig, err error) {
+				require.NoError(t, err)
+				require.Equal(t, fileConfig{}, config)
+			},
+		},
+		{
+			name: "every problem is reported",
+			path: func() string {
+				return writeConfigFile(
+					t,
+					`api:
+  adress: foo
+  pageSize: -1
+  paginationMode: foo
+  preflightMode: foo
+  preflightTimeout: -1m
+  requestTimeout: -1s
+collection:
+  mode: foo
+  scrapeInterval: bar
+  minRefreshInterval: 0s
+  readinessFailureThreshold: -1m
+  backoffMaxInterval: -1m
+collectors:
+  foo: {}
+  users:
+    interval: -1s
+eventIndex:
+  syncInterval: 0s
+  resyncInterval: -1m
+events:
+  sourcePattern: (
+  maxWorkerLifetime: -1h
+labels:
+  valueLimit: -1
+  metricValueLimits:
+    brigade_jobs_by_phase: -1
+    foo: 1
+log:
+  level: foo
+  format: foo
+  errorRepeatInterval: -1s
+otlp:
+  protocol: foo
+  pushInterval: 0s
+pushgateway:
+  pushInterval: 0s
+remoteWrite:
+  timeout: -1s
+statsd:
+  flavor: foo
+  port: 0
+substrate:
+  maxConcurrentWorkers: -1
+server:
+  port: foo
+`,
+				)
+			},
+			assertions: func(_ fileConfig, err error) {
+				require.Error(t, err)
+				for _, problem := range []string{
+					"field adress not found",
+					"api.pageSize",
+					"api.paginationMode",
+					"api.preflightMode",
+					"api.preflightTimeout",
+					"api.requestTimeout",
+					"collection.mode",
+					"collection.minRefreshInterval",
+					"collection.readinessFailureThreshold",
+					"collection.backoffMaxInterval",
+					"`bar` into time.Duration",
+					"collectors.foo",
+					"collectors.users.interval",
+					"eventIndex.syncInterval",
+					"eventIndex.resyncInterval",
+					"events.sourcePattern",
+					"events.maxWorkerLifetime",
+					"labels.valueLimit",
+					"labels.metricValueLimits.brigade_jobs_by_phase",
+					"labels.metricValueLimits.foo",
+					"log.level",
+					"log.format",
+					"log.errorRepeatInterval",
+					"otlp.protocol",
+					"otlp.pushInterval",
+					"pushgateway.pushInterval",
+					"remoteWrite.timeout",
+					"statsd.flavor",
+					"statsd.port",
+					"substrate.maxConcurrentWorkers",
+					"!!str `foo` into int",
+				} {
+					require.Contains(t, err.Error(), problem)
+				}
+			},
+		},
+		{
+			name: "valid YAML",
+			path: func() string {
+				return writeConfigFile(
+					t,
+					`api:
+  address: foo
+  token: bar
+  requestTimeout: 30s
+  pageSize: 50
+collection:
+  mode: on-demand
+collectors:
+  users:
+    enabled: false
+server:
+  port: 9090
+`,
+				)
+			},
+			assertions: func(config fileConfig, err error) {
+				require.NoError(t, err)
+				require.Equal(
+					t,
+					fileConfig{
+						API: apiFileConfig{
+							Address:        "foo",
+							Token:          "bar",
+							RequestTimeout: durationPtr(30 * time.Second),
+							PageSize:       intPtr(50),
+						},
+						Collection: collectionFileConfig{
+							Mode: "on-demand",
+						},
+						Collectors: map[string]collectorFileConfig{
+							"users": {Enabled: boolPtr(false)},
+						},
+						Server: serverFileConfig{
+							Port: intPtr(9090),
+						},
+					},
+					config,
+				)
+			},
+		},
+		{
+			name: "valid JSON",
+			path: func() string {
+				return writeConfigFile(
+					t,
+					`{"api": {"address": "foo", "ignoreCertWarnings": true}}`,
+				)
+			},
+			assertions: func(config fileConfig, err error) {
+				require.NoError(t, err)
+				require.Equal(
+					t,
+					fileConfig{
+						API: apiFileConfig{
+							Address:            "foo",
+							IgnoreCertWarnings: boolPtr(true),
+						},
+					},
+					config,
+				)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			config, err := loadFileConfig(testCase.path())
+			testCase.assertions(config, err)
+		})
+	}
+}
+
 // Note that unit testing in Go does NOT clear environment variables between
 // tests, which can sometimes be a pain, but it's fine here-- so each of these
 // test functions uses a series of test cases that cumulatively build upon one
@@ -76,7 +313,7 @@ func TestAPIClientConfig(t *testing.T) {
 	for _, testCase := range testCases {
 		t.Run(testCase.name, func(t *testing.T) {
 			testCase.setup()
-			address, token, opts, err := apiClientConfig()
+			address, token, opts, err := apiClientConfig(fileConfig{})
 			testCase.assertions(address, token, opts, err)
 		})
 	}
@@ -156,8 +393,1453 @@ func TestServerConfig(t *testing.T) {
 	for _, testCase := range testCases {
 		t.Run(testCase.name, func(t *testing.T) {
 			testCase.setup()
-			config, err := serverConfig()
+			config, err := serverConfig(fileConfig{})
 			testCase.assertions(config, err)
 		})
 	}
 }
+
+func TestExporterConfig(t *testing.T) {
+	testCases := []struct {
+		name       string
+		setup      func()
+		assertions func(metricsExporterConfig, error)
+	}{
+		{
+			name: "COLLECTION_MODE invalid",
+			setup: func() {
+				t.Setenv("COLLECTION_MODE", "foo")
+			},
+			assertions: func(_ metricsExporterConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "is invalid")
+				require.Contains(t, err.Error(), "COLLECTION_MODE")
+			},
+		},
+		{
+			name: "PAGINATION_MODE invalid",
+			setup: func() {
+				t.Setenv("COLLECTION_MODE", "on-demand")
+				t.Setenv("PAGINATION_MODE", "foo")
+			},
+			assertions: func(_ metricsExporterConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "is invalid")
+				require.Contains(t, err.Error(), "PAGINATION_MODE")
+			},
+		},
+		{
+			name: "PREFLIGHT_MODE invalid",
+			setup: func() {
+				t.Setenv("PAGINATION_MODE", "walk")
+				t.Setenv("PREFLIGHT_MODE", "foo")
+			},
+			assertions: func(_ metricsExporterConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "is invalid")
+				require.Contains(t, err.Error(), "PREFLIGHT_MODE")
+			},
+		},
+		{
+			name: "API_PAGE_SIZE not an int",
+			setup: func() {
+				t.Setenv("PREFLIGHT_MODE", "degrade")
+				t.Setenv("API_PAGE_SIZE", "foo")
+			},
+			assertions: func(_ metricsExporterConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "was not parsable as an int")
+				require.Contains(t, err.Error(), "API_PAGE_SIZE")
+			},
+		},
+		{
+			name: "API_PAGE_SIZE negative",
+			setup: func() {
+				t.Setenv("API_PAGE_SIZE", "-1")
+			},
+			assertions: func(_ metricsExporterConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "is invalid")
+				require.Contains(t, err.Error(), "API_PAGE_SIZE")
+			},
+		},
+		{
+			name: "PREFLIGHT_TIMEOUT not a duration",
+			setup: func() {
+				t.Setenv("API_PAGE_SIZE", "50")
+				t.Setenv("PREFLIGHT_TIMEOUT", "foo")
+			},
+			assertions: func(_ metricsExporterConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "was not parsable as a duration")
+				require.Contains(t, err.Error(), "PREFLIGHT_TIMEOUT")
+			},
+		},
+		{
+			name: "PREFLIGHT_TIMEOUT negative",
+			setup: func() {
+				t.Setenv("PREFLIGHT_TIMEOUT", "-1m")
+			},
+			assertions: func(_ metricsExporterConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "is invalid")
+				require.Contains(t, err.Error(), "PREFLIGHT_TIMEOUT")
+			},
+		},
+		{
+			name: "PROM_SCRAPE_INTERVAL not a duration",
+			setup: func() {
+				t.Setenv("PREFLIGHT_TIMEOUT", "30s")
+				t.Setenv("PROM_SCRAPE_INTERVAL", "foo")
+			},
+			assertions: func(_ metricsExporterConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "was not parsable as a duration")
+				require.Contains(t, err.Error(), "PROM_SCRAPE_INTERVAL")
+			},
+		},
+		{
+			name: "PROM_SCRAPE_INTERVAL not positive",
+			setup: func() {
+				t.Setenv("PROM_SCRAPE_INTERVAL", "0s")
+			},
+			assertions: func(_ metricsExporterConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "must be positive")
+				require.Contains(t, err.Error(), "PROM_SCRAPE_INTERVAL")
+			},
+		},
+		{
+			name: "API_REQUEST_TIMEOUT not a duration",
+			setup: func() {
+				t.Setenv("PROM_SCRAPE_INTERVAL", "5s")
+				t.Setenv("API_REQUEST_TIMEOUT", "foo")
+			},
+			assertions: func(_ metricsExporterConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "was not parsable as a duration")
+				require.Contains(t, err.Error(), "API_REQUEST_TIMEOUT")
+			},
+		},
+		{
+			name: "API_REQUEST_TIMEOUT negative",
+			setup: func() {
+				t.Setenv("API_REQUEST_TIMEOUT", "-1s")
+			},
+			assertions: func(_ metricsExporterConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "must not be negative")
+				require.Contains(t, err.Error(), "API_REQUEST_TIMEOUT")
+			},
+		},
+		{
+			name: "MIN_REFRESH_INTERVAL not a duration",
+			setup: func() {
+				t.Setenv("API_REQUEST_TIMEOUT", "30s")
+				t.Setenv("MIN_REFRESH_INTERVAL", "foo")
+			},
+			assertions: func(_ metricsExporterConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "was not parsable as a duration")
+				require.Contains(t, err.Error(), "MIN_REFRESH_INTERVAL")
+			},
+		},
+		{
+			name: "MIN_REFRESH_INTERVAL not positive",
+			setup: func() {
+				t.Setenv("MIN_REFRESH_INTERVAL", "0s")
+			},
+			assertions: func(_ metricsExporterConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "must be positive")
+				require.Contains(t, err.Error(), "MIN_REFRESH_INTERVAL")
+			},
+		},
+		{
+			name: "MAX_CONCURRENT_WORKERS not an int",
+			setup: func() {
+				t.Setenv("MIN_REFRESH_INTERVAL", "10s")
+				t.Setenv("MAX_CONCURRENT_WORKERS", "foo")
+			},
+			assertions: func(_ metricsExporterConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "was not parsable as an int")
+				require.Contains(t, err.Error(), "MAX_CONCURRENT_WORKERS")
+			},
+		},
+		{
+			name: "MAX_CONCURRENT_WORKERS negative",
+			setup: func() {
+				t.Setenv("MAX_CONCURRENT_WORKERS", "-1")
+			},
+			assertions: func(_ metricsExporterConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "is invalid")
+				require.Contains(t, err.Error(), "MAX_CONCURRENT_WORKERS")
+			},
+		},
+		{
+			name: "EVENT_SOURCE_PATTERN invalid",
+			setup: func() {
+				t.Setenv("MAX_CONCURRENT_WORKERS", "20")
+				t.Setenv("EVENT_SOURCE_PATTERN", "(")
+			},
+			assertions: func(_ metricsExporterConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "is invalid")
+				require.Contains(t, err.Error(), "EVENT_SOURCE_PATTERN")
+			},
+		},
+		{
+			name: "EVENT_TYPE_PATTERN invalid",
+			setup: func() {
+				t.Setenv("EVENT_SOURCE_PATTERN", "brigade.sh/.*")
+				t.Setenv("EVENT_TYPE_PATTERN", "(")
+			},
+			assertions: func(_ metricsExporterConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "is invalid")
+				require.Contains(t, err.Error(), "EVENT_TYPE_PATTERN")
+			},
+		},
+		{
+			name: "MAX_WORKER_LIFETIME not a duration",
+			setup: func() {
+				t.Setenv("EVENT_TYPE_PATTERN", "push|pull_request")
+				t.Setenv("MAX_WORKER_LIFETIME", "foo")
+			},
+			assertions: func(_ metricsExporterConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "was not parsable as a duration")
+				require.Contains(t, err.Error(), "MAX_WORKER_LIFETIME")
+			},
+		},
+		{
+			name: "MAX_WORKER_LIFETIME negative",
+			setup: func() {
+				t.Setenv("MAX_WORKER_LIFETIME", "-1h")
+			},
+			assertions: func(_ metricsExporterConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "is invalid")
+				require.Contains(t, err.Error(), "MAX_WORKER_LIFETIME")
+			},
+		},
+		{
+			name: "EVENT_INDEX_ENABLED not a bool",
+			setup: func() {
+				t.Setenv("MAX_WORKER_LIFETIME", "12h")
+				t.Setenv("EVENT_INDEX_ENABLED", "foo")
+			},
+			assertions: func(_ metricsExporterConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "was not parsable as a bool")
+				require.Contains(t, err.Error(), "EVENT_INDEX_ENABLED")
+			},
+		},
+		{
+			name: "EVENT_INDEX_SYNC_INTERVAL not a duration",
+			setup: func() {
+				t.Setenv("EVENT_INDEX_ENABLED", "true")
+				t.Setenv("EVENT_INDEX_SYNC_INTERVAL", "foo")
+			},
+			assertions: func(_ metricsExporterConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "was not parsable as a duration")
+				require.Contains(t, err.Error(), "EVENT_INDEX_SYNC_INTERVAL")
+			},
+		},
+		{
+			name: "EVENT_INDEX_SYNC_INTERVAL not positive",
+			setup: func() {
+				t.Setenv("EVENT_INDEX_SYNC_INTERVAL", "0s")
+			},
+			assertions: func(_ metricsExporterConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "must be positive")
+				require.Contains(t, err.Error(), "EVENT_INDEX_SYNC_INTERVAL")
+			},
+		},
+		{
+			name: "EVENT_INDEX_RESYNC_INTERVAL not a duration",
+			setup: func() {
+				t.Setenv("EVENT_INDEX_SYNC_INTERVAL", "5s")
+				t.Setenv("EVENT_INDEX_RESYNC_INTERVAL", "foo")
+			},
+			assertions: func(_ metricsExporterConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "was not parsable as a duration")
+				require.Contains(t, err.Error(), "EVENT_INDEX_RESYNC_INTERVAL")
+			},
+		},
+		{
+			name: "EVENT_INDEX_RESYNC_INTERVAL not positive",
+			setup: func() {
+				t.Setenv("EVENT_INDEX_RESYNC_INTERVAL", "0s")
+			},
+			assertions: func(_ metricsExporterConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "must be positive")
+				require.Contains(t, err.Error(), "EVENT_INDEX_RESYNC_INTERVAL")
+			},
+		},
+		{
+			name: "LABEL_VALUE_LIMIT not an int",
+			setup: func() {
+				t.Setenv("EVENT_INDEX_RESYNC_INTERVAL", "1h")
+				t.Setenv("LABEL_VALUE_LIMIT", "foo")
+			},
+			assertions: func(_ metricsExporterConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "was not parsable as an int")
+				require.Contains(t, err.Error(), "LABEL_VALUE_LIMIT")
+			},
+		},
+		{
+			name: "LABEL_VALUE_LIMIT negative",
+			setup: func() {
+				t.Setenv("LABEL_VALUE_LIMIT", "-1")
+			},
+			assertions: func(_ metricsExporterConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "is invalid")
+				require.Contains(t, err.Error(), "LABEL_VALUE_LIMIT")
+			},
+		},
+		{
+			name: "LABEL_VALUE_LIMITS entry malformed",
+			setup: func() {
+				t.Setenv("LABEL_VALUE_LIMIT", "100")
+				t.Setenv("LABEL_VALUE_LIMITS", "brigade_events_total")
+			},
+			assertions: func(_ metricsExporterConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "must be of the form")
+				require.Contains(t, err.Error(), "LABEL_VALUE_LIMITS")
+			},
+		},
+		{
+			name: "LABEL_VALUE_LIMITS entry names unknown metric",
+			setup: func() {
+				t.Setenv("LABEL_VALUE_LIMITS", "foo=10")
+			},
+			assertions: func(_ metricsExporterConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "no such metric")
+				require.Contains(t, err.Error(), "LABEL_VALUE_LIMITS")
+			},
+		},
+		{
+			name: "LABEL_VALUE_LIMITS entry has invalid limit",
+			setup: func() {
+				t.Setenv("LABEL_VALUE_LIMITS", "brigade_events_total=-1")
+			},
+			assertions: func(_ metricsExporterConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "non-negative int")
+				require.Contains(t, err.Error(), "LABEL_VALUE_LIMITS")
+			},
+		},
+		{
+			name: "READINESS_FAILURE_THRESHOLD not a duration",
+			setup: func() {
+				t.Setenv(
+					"LABEL_VALUE_LIMITS",
+					"brigade_events_total=10, brigade_jobs_by_phase=0",
+				)
+				t.Setenv("READINESS_FAILURE_THRESHOLD", "foo")
+			},
+			assertions: func(_ metricsExporterConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "was not parsable as a duration")
+				require.Contains(t, err.Error(), "READINESS_FAILURE_THRESHOLD")
+			},
+		},
+		{
+			name: "READINESS_FAILURE_THRESHOLD negative",
+			setup: func() {
+				t.Setenv("READINESS_FAILURE_THRESHOLD", "-1m")
+			},
+			assertions: func(_ metricsExporterConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "is invalid")
+				require.Contains(t, err.Error(), "READINESS_FAILURE_THRESHOLD")
+			},
+		},
+		{
+			name: "COLLECTOR_BACKOFF_MAX_INTERVAL not a duration",
+			setup: func() {
+				t.Setenv("READINESS_FAILURE_THRESHOLD", "1m")
+				t.Setenv("COLLECTOR_BACKOFF_MAX_INTERVAL", "foo")
+			},
+			assertions: func(_ metricsExporterConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "was not parsable as a duration")
+				require.Contains(t, err.Error(), "COLLECTOR_BACKOFF_MAX_INTERVAL")
+			},
+		},
+		{
+			name: "COLLECTOR_BACKOFF_MAX_INTERVAL negative",
+			setup: func() {
+				t.Setenv("COLLECTOR_BACKOFF_MAX_INTERVAL", "-1m")
+			},
+			assertions: func(_ metricsExporterConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "is invalid")
+				require.Contains(t, err.Error(), "COLLECTOR_BACKOFF_MAX_INTERVAL")
+			},
+		},
+		{
+			name: "COLLECTOR_<NAME>_ENABLED not a bool",
+			setup: func() {
+				t.Setenv("COLLECTOR_BACKOFF_MAX_INTERVAL", "30s")
+				t.Setenv("COLLECTOR_USERS_ENABLED", "foo")
+			},
+			assertions: func(_ metricsExporterConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "was not parsable as a bool")
+				require.Contains(t, err.Error(), "COLLECTOR_USERS_ENABLED")
+			},
+		},
+		{
+			name: "COLLECTOR_<NAME>_INTERVAL not a duration",
+			setup: func() {
+				t.Setenv("COLLECTOR_USERS_ENABLED", "true")
+				t.Setenv("COLLECTOR_DURATIONS_INTERVAL", "foo")
+			},
+			assertions: func(_ metricsExporterConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "was not parsable as a duration")
+				require.Contains(t, err.Error(), "COLLECTOR_DURATIONS_INTERVAL")
+			},
+		},
+		{
+			name: "COLLECTOR_<NAME>_INTERVAL negative",
+			setup: func() {
+				t.Setenv("COLLECTOR_DURATIONS_INTERVAL", "-1m")
+			},
+			assertions: func(_ metricsExporterConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "is invalid")
+				require.Contains(t, err.Error(), "COLLECTOR_DURATIONS_INTERVAL")
+			},
+		},
+		{
+			name: "success",
+			setup: func() {
+				t.Setenv("COLLECTOR_USERS_ENABLED", "false")
+				t.Setenv("COLLECTOR_DURATIONS_INTERVAL", "1m")
+			},
+			assertions: func(config metricsExporterConfig, err error) {
+				require.NoError(t, err)
+				require.Equal(
+					t,
+					"^(?:brigade.sh/.*)$",
+					config.EventSourcePattern.String(),
+				)
+				require.Equal(
+					t,
+					"^(?:push|pull_request)$",
+					config.EventTypePattern.String(),
+				)
+				config.EventSourcePattern = nil
+				config.EventTypePattern = nil
+				require.Equal(
+					t,
+					metricsExporterConfig{
+						CollectionMode:           collectionModeOnDemand,
+						PaginationMode:           paginationModeWalk,
+						PageSize:                 50,
+						ScrapeInterval:           5 * time.Second,
+						APIRequestTimeout:        30 * time.Second,
+						MinRefreshInterval:       10 * time.Second,
+						MaxConcurrentWorkers:     20,
+						MaxWorkerLifetime:        12 * time.Hour,
+						EventIndexEnabled:        true,
+						EventIndexSyncInterval:   5 * time.Second,
+						EventIndexResyncInterval: time.Hour,
+						LabelValueLimit:          100,
+						LabelValueLimits: map[string]int{
+							"brigade_events_total":  10,
+							"brigade_jobs_by_phase": 0,
+						},
+						ReadinessFailureThreshold: time.Minute,
+						BackoffMaxInterval:        30 * time.Second,
+						PreflightMode:             preflightModeDegrade,
+						PreflightTimeout:          30 * time.Second,
+						Collectors: map[string]collectorConfig{
+							collectorUsers: {
+								Disabled: true,
+							},
+							collectorDurations: {
+								Interval: time.Minute,
+							},
+						},
+					},
+					config,
+				)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			testCase.setup()
+			config, err := exporterConfig(fileConfig{})
+			testCase.assertions(config, err)
+		})
+	}
+}
+
+func TestOTLPSinkConfig(t *testing.T) {
+	testCases := []struct {
+		name       string
+		setup      func(*testing.T)
+		file       fileConfig
+		assertions func(*testing.T, otlpConfig, error)
+	}{
+		{
+			name:  "disabled by default",
+			setup: func(*testing.T) {},
+			assertions: func(t *testing.T, config otlpConfig, err error) {
+				require.NoError(t, err)
+				require.Equal(t, otlpConfig{}, config)
+			},
+		},
+		{
+			name: "OTLP_ENABLED not a bool",
+			setup: func(t *testing.T) {
+				t.Setenv("OTLP_ENABLED", "foo")
+			},
+			assertions: func(t *testing.T, _ otlpConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "was not parsable as a bool")
+				require.Contains(t, err.Error(), "OTLP_ENABLED")
+			},
+		},
+		{
+			name: "OTLP_PROTOCOL invalid",
+			setup: func(t *testing.T) {
+				t.Setenv("OTLP_ENABLED", "true")
+				t.Setenv("OTLP_PROTOCOL", "foo")
+			},
+			assertions: func(t *testing.T, _ otlpConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "is invalid")
+				require.Contains(t, err.Error(), "OTLP_PROTOCOL")
+			},
+		},
+		{
+			name: "OTLP_ENDPOINT required but not set",
+			setup: func(t *testing.T) {
+				t.Setenv("OTLP_ENABLED", "true")
+			},
+			assertions: func(t *testing.T, _ otlpConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "value not found for")
+				require.Contains(t, err.Error(), "OTLP_ENDPOINT")
+			},
+		},
+		{
+			name: "OTLP_HEADERS entry malformed",
+			setup: func(t *testing.T) {
+				t.Setenv("OTLP_ENABLED", "true")
+				t.Setenv("OTLP_ENDPOINT", "localhost:4317")
+				t.Setenv("OTLP_HEADERS", "foo")
+			},
+			assertions: func(t *testing.T, _ otlpConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "is invalid")
+				require.Contains(t, err.Error(), "OTLP_HEADERS")
+			},
+		},
+		{
+			name: "OTLP_PUSH_INTERVAL not positive",
+			setup: func(t *testing.T) {
+				t.Setenv("OTLP_ENABLED", "true")
+				t.Setenv("OTLP_ENDPOINT", "localhost:4317")
+				t.Setenv("OTLP_PUSH_INTERVAL", "0s")
+			},
+			assertions: func(t *testing.T, _ otlpConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "must be positive")
+				require.Contains(t, err.Error(), "OTLP_PUSH_INTERVAL")
+			},
+		},
+		{
+			name: "OTLP_TIMEOUT not a duration",
+			setup: func(t *testing.T) {
+				t.Setenv("OTLP_ENABLED", "true")
+				t.Setenv("OTLP_ENDPOINT", "localhost:4317")
+				t.Setenv("OTLP_TIMEOUT", "foo")
+			},
+			assertions: func(t *testing.T, _ otlpConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "was not parsable as a duration")
+				require.Contains(t, err.Error(), "OTLP_TIMEOUT")
+			},
+		},
+		{
+			name: "OTLP_TIMEOUT negative",
+			setup: func(t *testing.T) {
+				t.Setenv("OTLP_ENABLED", "true")
+				t.Setenv("OTLP_ENDPOINT", "localhost:4317")
+				t.Setenv("OTLP_TIMEOUT", "-1s")
+			},
+			assertions: func(t *testing.T, _ otlpConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "must not be negative")
+				require.Contains(t, err.Error(), "OTLP_TIMEOUT")
+			},
+		},
+		{
+			name: "environment variables override file values",
+			setup: func(t *testing.T) {
+				t.Setenv("OTLP_PROTOCOL", "http/protobuf")
+				t.Setenv("OTLP_HEADERS", "authorization=Bearer foo, x-tenant=bar")
+				t.Setenv("OTLP_RESOURCE_ATTRIBUTES", "deployment.environment=prod")
+			},
+			file: fileConfig{
+				OTLP: otlpFileConfig{
+					Enabled:      boolPtr(true),
+					Protocol:     string(otlpProtocolGRPC),
+					Endpoint:     "https://otel.example.com",
+					PushInterval: durationPtr(time.Minute),
+					Headers: map[string]string{
+						"x-tenant": "foo",
+					},
+					ResourceAttributes: map[string]string{
+						"deployment.environment": "dev",
+						"k8s.cluster.name":       "brigade",
+					},
+				},
+			},
+			assertions: func(t *testing.T, config otlpConfig, err error) {
+				require.NoError(t, err)
+				require.Equal(
+					t,
+					otlpConfig{
+						Enabled:  true,
+						Protocol: otlpProtocolHTTP,
+						Endpoint: "https://otel.example.com",
+						Headers: map[string]string{
+							"authorization": "Bearer foo",
+							"x-tenant":      "bar",
+						},
+						Interval: time.Minute,
+						Timeout:  10 * time.Second,
+						ResourceAttributes: map[string]string{
+							"deployment.environment": "prod",
+							"k8s.cluster.name":       "brigade",
+						},
+					},
+					config,
+				)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			testCase.setup(t)
+			config, err := otlpSinkConfig(testCase.file)
+			testCase.assertions(t, config, err)
+		})
+	}
+}
+
+func TestPushgatewaySinkConfig(t *testing.T) {
+	testCases := []struct {
+		name       string
+		setup      func(*testing.T)
+		assertions func(*testing.T, pushgatewayConfig, error)
+	}{
+		{
+			name:  "disabled by default",
+			setup: func(*testing.T) {},
+			assertions: func(t *testing.T, config pushgatewayConfig, err error) {
+				require.NoError(t, err)
+				require.Equal(t, pushgatewayConfig{}, config)
+			},
+		},
+		{
+			name: "PUSHGATEWAY_URL required but not set",
+			setup: func(t *testing.T) {
+				t.Setenv("PUSHGATEWAY_ENABLED", "true")
+			},
+			assertions: func(t *testing.T, _ pushgatewayConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "value not found for")
+				require.Contains(t, err.Error(), "PUSHGATEWAY_URL")
+			},
+		},
+		{
+			name: "PUSHGATEWAY_GROUPING entry malformed",
+			setup: func(t *testing.T) {
+				t.Setenv("PUSHGATEWAY_ENABLED", "true")
+				t.Setenv("PUSHGATEWAY_URL", "http://pushgateway:9091")
+				t.Setenv("PUSHGATEWAY_GROUPING", "=foo")
+			},
+			assertions: func(t *testing.T, _ pushgatewayConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "is invalid")
+				require.Contains(t, err.Error(), "PUSHGATEWAY_GROUPING")
+			},
+		},
+		{
+			name: "PUSHGATEWAY_PUSH_INTERVAL not positive",
+			setup: func(t *testing.T) {
+				t.Setenv("PUSHGATEWAY_ENABLED", "true")
+				t.Setenv("PUSHGATEWAY_URL", "http://pushgateway:9091")
+				t.Setenv("PUSHGATEWAY_PUSH_INTERVAL", "-1s")
+			},
+			assertions: func(t *testing.T, _ pushgatewayConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "must be positive")
+				require.Contains(t, err.Error(), "PUSHGATEWAY_PUSH_INTERVAL")
+			},
+		},
+		{
+			name: "PUSHGATEWAY_TIMEOUT negative",
+			setup: func(t *testing.T) {
+				t.Setenv("PUSHGATEWAY_ENABLED", "true")
+				t.Setenv("PUSHGATEWAY_URL", "http://pushgateway:9091")
+				t.Setenv("PUSHGATEWAY_TIMEOUT", "-1s")
+			},
+			assertions: func(t *testing.T, _ pushgatewayConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "must not be negative")
+				require.Contains(t, err.Error(), "PUSHGATEWAY_TIMEOUT")
+			},
+		},
+		{
+			name: "success",
+			setup: func(t *testing.T) {
+				t.Setenv("PUSHGATEWAY_ENABLED", "true")
+				t.Setenv("PUSHGATEWAY_URL", "http://pushgateway:9091")
+				t.Setenv("PUSHGATEWAY_GROUPING", "cluster=prod")
+			},
+			assertions: func(t *testing.T, config pushgatewayConfig, err error) {
+				require.NoError(t, err)
+				require.Equal(
+					t,
+					pushgatewayConfig{
+						Enabled:  true,
+						URL:      "http://pushgateway:9091",
+						Job:      "brigade-metrics-exporter",
+						Grouping: map[string]string{"cluster": "prod"},
+						Interval: 30 * time.Second,
+						Timeout:  10 * time.Second,
+					},
+					config,
+				)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			testCase.setup(t)
+			config, err := pushgatewaySinkConfig(fileConfig{})
+			testCase.assertions(t, config, err)
+		})
+	}
+}
+
+func TestRemoteWriteSinkConfig(t *testing.T) {
+	testCases := []struct {
+		name       string
+		setup      func(*testing.T)
+		assertions func(*testing.T, remoteWriteConfig, error)
+	}{
+		{
+			name:  "disabled by default",
+			setup: func(*testing.T) {},
+			assertions: func(t *testing.T, config remoteWriteConfig, err error) {
+				require.NoError(t, err)
+				require.Equal(t, remoteWriteConfig{}, config)
+			},
+		},
+		{
+			name: "REMOTE_WRITE_URL required but not set",
+			setup: func(t *testing.T) {
+				t.Setenv("REMOTE_WRITE_ENABLED", "true")
+			},
+			assertions: func(t *testing.T, _ remoteWriteConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "value not found for")
+				require.Contains(t, err.Error(), "REMOTE_WRITE_URL")
+			},
+		},
+		{
+			name: "REMOTE_WRITE_LABELS entry malformed",
+			setup: func(t *testing.T) {
+				t.Setenv("REMOTE_WRITE_ENABLED", "true")
+				t.Setenv("REMOTE_WRITE_URL", "http://prometheus/api/v1/write")
+				t.Setenv("REMOTE_WRITE_LABELS", "foo")
+			},
+			assertions: func(t *testing.T, _ remoteWriteConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "is invalid")
+				require.Contains(t, err.Error(), "REMOTE_WRITE_LABELS")
+			},
+		},
+		{
+			name: "REMOTE_WRITE_TIMEOUT not a duration",
+			setup: func(t *testing.T) {
+				t.Setenv("REMOTE_WRITE_ENABLED", "true")
+				t.Setenv("REMOTE_WRITE_URL", "http://prometheus/api/v1/write")
+				t.Setenv("REMOTE_WRITE_TIMEOUT", "foo")
+			},
+			assertions: func(t *testing.T, _ remoteWriteConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "was not parsable as a duration")
+				require.Contains(t, err.Error(), "REMOTE_WRITE_TIMEOUT")
+			},
+		},
+		{
+			name: "REMOTE_WRITE_TIMEOUT negative",
+			setup: func(t *testing.T) {
+				t.Setenv("REMOTE_WRITE_ENABLED", "true")
+				t.Setenv("REMOTE_WRITE_URL", "http://prometheus/api/v1/write")
+				t.Setenv("REMOTE_WRITE_TIMEOUT", "-1s")
+			},
+			assertions: func(t *testing.T, _ remoteWriteConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "must not be negative")
+				require.Contains(t, err.Error(), "REMOTE_WRITE_TIMEOUT")
+			},
+		},
+		{
+			name: "success",
+			setup: func(t *testing.T) {
+				t.Setenv("REMOTE_WRITE_ENABLED", "true")
+				t.Setenv("REMOTE_WRITE_URL", "http://prometheus/api/v1/write")
+				t.Setenv("REMOTE_WRITE_HEADERS", "Authorization=Bearer foo")
+				t.Setenv("REMOTE_WRITE_LABELS", "job=brigade")
+				t.Setenv("REMOTE_WRITE_PUSH_INTERVAL", "15s")
+			},
+			assertions: func(t *testing.T, config remoteWriteConfig, err error) {
+				require.NoError(t, err)
+				require.Equal(
+					t,
+					remoteWriteConfig{
+						Enabled:  true,
+						URL:      "http://prometheus/api/v1/write",
+						Headers:  map[string]string{"Authorization": "Bearer foo"},
+						Labels:   map[string]string{"job": "brigade"},
+						Interval: 15 * time.Second,
+						Timeout:  10 * time.Second,
+					},
+					config,
+				)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			testCase.setup(t)
+			config, err := remoteWriteSinkConfig(fileConfig{})
+			testCase.assertions(t, config, err)
+		})
+	}
+}
+
+func TestStatsdSinkConfig(t *testing.T) {
+	testCases := []struct {
+		name       string
+		setup      func(*testing.T)
+		assertions func(*testing.T, statsdConfig, error)
+	}{
+		{
+			name:  "disabled by default",
+			setup: func(*testing.T) {},
+			assertions: func(t *testing.T, config statsdConfig, err error) {
+				require.NoError(t, err)
+				require.Equal(t, statsdConfig{}, config)
+			},
+		},
+		{
+			name: "STATSD_FLAVOR invalid",
+			setup: func(t *testing.T) {
+				t.Setenv("STATSD_ENABLED", "true")
+				t.Setenv("STATSD_FLAVOR", "foo")
+			},
+			assertions: func(t *testing.T, _ statsdConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "is invalid")
+				require.Contains(t, err.Error(), "STATSD_FLAVOR")
+			},
+		},
+		{
+			name: "STATSD_PORT out of range",
+			setup: func(t *testing.T) {
+				t.Setenv("STATSD_ENABLED", "true")
+				t.Setenv("STATSD_PORT", "70000")
+			},
+			assertions: func(t *testing.T, _ statsdConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "is invalid")
+				require.Contains(t, err.Error(), "STATSD_PORT")
+			},
+		},
+		{
+			name: "STATSD_TAGS entry malformed",
+			setup: func(t *testing.T) {
+				t.Setenv("STATSD_ENABLED", "true")
+				t.Setenv("STATSD_TAGS", "foo")
+			},
+			assertions: func(t *testing.T, _ statsdConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "is invalid")
+				require.Contains(t, err.Error(), "STATSD_TAGS")
+			},
+		},
+		{
+			name: "success",
+			setup: func(t *testing.T) {
+				t.Setenv("STATSD_ENABLED", "true")
+				t.Setenv("STATSD_HOST", "datadog-agent")
+				t.Setenv("STATSD_PREFIX", "brigade.")
+				t.Setenv("STATSD_TAGS", "env=prod")
+			},
+			assertions: func(t *testing.T, config statsdConfig, err error) {
+				require.NoError(t, err)
+				require.Equal(
+					t,
+					statsdConfig{
+						Enabled:  true,
+						Flavor:   statsdFlavorDogStatsD,
+						Host:     "datadog-agent",
+						Port:     8125,
+						Prefix:   "brigade.",
+						Tags:     map[string]string{"env": "prod"},
+						Interval: 10 * time.Second,
+					},
+					config,
+				)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			testCase.setup(t)
+			config, err := statsdSinkConfig(fileConfig{})
+			testCase.assertions(t, config, err)
+		})
+	}
+}
+
+func TestTLSClientAuthConfig(t *testing.T) {
+	testCases := []struct {
+		name       string
+		setup      func(*testing.T)
+		file       fileConfig
+		assertions func(*testing.T, clientAuthConfig, error)
+	}{
+		{
+			name:  "disabled by default",
+			setup: func(*testing.T) {},
+			assertions: func(t *testing.T, config clientAuthConfig, err error) {
+				require.NoError(t, err)
+				require.Equal(t, clientAuthConfig{}, config)
+			},
+		},
+		{
+			name: "TLS_CLIENT_AUTH_ENABLED not a bool",
+			setup: func(t *testing.T) {
+				t.Setenv("TLS_CLIENT_AUTH_ENABLED", "foo")
+			},
+			assertions: func(t *testing.T, _ clientAuthConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "was not parsable as a bool")
+				require.Contains(t, err.Error(), "TLS_CLIENT_AUTH_ENABLED")
+			},
+		},
+		{
+			name: "TLS_CLIENT_CA_PATH required but not set",
+			setup: func(t *testing.T) {
+				t.Setenv("TLS_CLIENT_AUTH_ENABLED", "true")
+			},
+			assertions: func(t *testing.T, _ clientAuthConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "value not found for")
+				require.Contains(t, err.Error(), "TLS_CLIENT_CA_PATH")
+			},
+		},
+		{
+			name: "environment variables override file values",
+			setup: func(t *testing.T) {
+				t.Setenv("TLS_CLIENT_CA_PATH", "/var/tls/env-ca.crt")
+				t.Setenv("TLS_ALLOWED_CLIENTS", "prometheus, spiffe://brigade/scraper")
+			},
+			file: fileConfig{
+				Server: serverFileConfig{
+					TLS: serverTLSFileConfig{
+						ClientAuth: serverClientAuthFileConfig{
+							Enabled:        boolPtr(true),
+							CAPath:         "/var/tls/file-ca.crt",
+							AllowedClients: []string{"grafana"},
+						},
+					},
+				},
+			},
+			assertions: func(t *testing.T, config clientAuthConfig, err error) {
+				require.NoError(t, err)
+				require.Equal(
+					t,
+					clientAuthConfig{
+						Enabled: true,
+						CAPath:  "/var/tls/env-ca.crt",
+						AllowedClients: []string{
+							"prometheus",
+							"spiffe://brigade/scraper",
+						},
+					},
+					config,
+				)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			testCase.setup(t)
+			config, err := tlsClientAuthConfig(testCase.file)
+			testCase.assertions(t, config, err)
+		})
+	}
+}
+
+func TestMetricsAuthConfig(t *testing.T) {
+	testCases := []struct {
+		name       string
+		setup      func(*testing.T)
+		assertions func(*testing.T, authConfig, error)
+	}{
+		{
+			name:  "disabled by default",
+			setup: func(*testing.T) {},
+			assertions: func(t *testing.T, config authConfig, err error) {
+				require.NoError(t, err)
+				require.False(t, config.enabled())
+			},
+		},
+		{
+			name: "METRICS_AUTH_USERNAME set without a password file",
+			setup: func(t *testing.T) {
+				t.Setenv("METRICS_AUTH_USERNAME", "prometheus")
+			},
+			assertions: func(t *testing.T, _ authConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "METRICS_AUTH_PASSWORD_FILE")
+			},
+		},
+		{
+			name: "METRICS_AUTH_PASSWORD_FILE set without a username",
+			setup: func(t *testing.T) {
+				t.Setenv("METRICS_AUTH_PASSWORD_FILE", "/var/secrets/password")
+			},
+			assertions: func(t *testing.T, _ authConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "METRICS_AUTH_USERNAME")
+			},
+		},
+		{
+			name: "success",
+			setup: func(t *testing.T) {
+				t.Setenv("METRICS_AUTH_TOKEN_FILE", "/var/secrets/tokens")
+			},
+			assertions: func(t *testing.T, config authConfig, err error) {
+				require.NoError(t, err)
+				require.True(t, config.enabled())
+				require.Equal(
+					t,
+					authConfig{TokenFile: "/var/secrets/tokens"},
+					config,
+				)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			testCase.setup(t)
+			config, err := metricsAuthConfig(fileConfig{})
+			testCase.assertions(t, config, err)
+		})
+	}
+}
+
+func TestLoggingConfig(t *testing.T) {
+	testCases := []struct {
+		name       string
+		setup      func(*testing.T)
+		assertions func(*testing.T, logConfig, error)
+	}{
+		{
+			name:  "defaults",
+			setup: func(*testing.T) {},
+			assertions: func(t *testing.T, config logConfig, err error) {
+				require.NoError(t, err)
+				require.Equal(
+					t,
+					logConfig{
+						Level:               logLevelInfo,
+						Format:              logFormatLogfmt,
+						ErrorRepeatInterval: 5 * time.Minute,
+					},
+					config,
+				)
+			},
+		},
+		{
+			name: "invalid LOG_LEVEL",
+			setup: func(t *testing.T) {
+				t.Setenv("LOG_LEVEL", "verbose")
+			},
+			assertions: func(t *testing.T, _ logConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "LOG_LEVEL")
+			},
+		},
+		{
+			name: "invalid LOG_FORMAT",
+			setup: func(t *testing.T) {
+				t.Setenv("LOG_FORMAT", "text")
+			},
+			assertions: func(t *testing.T, _ logConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "LOG_FORMAT")
+			},
+		},
+		{
+			name: "negative LOG_ERROR_REPEAT_INTERVAL",
+			setup: func(t *testing.T) {
+				t.Setenv("LOG_ERROR_REPEAT_INTERVAL", "-1m")
+			},
+			assertions: func(t *testing.T, _ logConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "must not be negative")
+			},
+		},
+		{
+			name: "success",
+			setup: func(t *testing.T) {
+				t.Setenv("LOG_LEVEL", "debug")
+				t.Setenv("LOG_FORMAT", "json")
+				t.Setenv("LOG_ERROR_REPEAT_INTERVAL", "0s")
+			},
+			assertions: func(t *testing.T, config logConfig, err error) {
+				require.NoError(t, err)
+				require.Equal(
+					t,
+					logConfig{
+						Level:  logLevelDebug,
+						Format: logFormatJSON,
+					},
+					config,
+				)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			testCase.setup(t)
+			config, err := loggingConfig(fileConfig{})
+			testCase.assertions(t, config, err)
+		})
+	}
+}
+
+func TestConfigPrecedence(t *testing.T) {
+	file := fileConfig{
+		Labels: labelsFileConfig{
+			ValueLimit: intPtr(100),
+			MetricValueLimits: map[string]int{
+				"brigade_events_total":  10,
+				"brigade_jobs_by_phase": 20,
+			},
+		},
+		API: apiFileConfig{
+			Address:        "foo",
+			Token:          "bar",
+			RequestTimeout: durationPtr(30 * time.Second),
+			PaginationMode: string(paginationModeWalk),
+		},
+		Collection: collectionFileConfig{
+			Mode:           string(collectionModeOnDemand),
+			ScrapeInterval: durationPtr(5 * time.Second),
+		},
+		EventIndex: eventIndexFileConfig{
+			Enabled:      boolPtr(true),
+			SyncInterval: durationPtr(5 * time.Second),
+		},
+		Collectors: map[string]collectorFileConfig{
+			collectorUsers: {
+				Enabled: boolPtr(false),
+			},
+			collectorDurations: {
+				Interval: durationPtr(time.Minute),
+			},
+		},
+		Server: serverFileConfig{
+			Port: intPtr(9090),
+			TLS: serverTLSFileConfig{
+				Enabled:  boolPtr(true),
+				CertPath: "/var/ssl/cert",
+				KeyPath:  "/var/ssl/key",
+			},
+		},
+	}
+	testCases := []struct {
+		name       string
+		setup      func()
+		assertions func(
+			address string,
+			token string,
+			exporterConfig metricsExporterConfig,
+			serverConfig http.ServerConfig,
+		)
+	}{
+		{
+			name:  "file values used when environment variables are not set",
+			setup: func() {},
+			assertions: func(
+				address string,
+				token string,
+				exporterConfig metricsExporterConfig,
+				serverConfig http.ServerConfig,
+			) {
+				require.Equal(t, "foo", address)
+				require.Equal(t, "bar", token)
+				require.Equal(
+					t,
+					metricsExporterConfig{
+						CollectionMode:           collectionModeOnDemand,
+						PaginationMode:           paginationModeWalk,
+						ScrapeInterval:           5 * time.Second,
+						APIRequestTimeout:        30 * time.Second,
+						MinRefreshInterval:       2 * time.Second,
+						MaxWorkerLifetime:        24 * time.Hour,
+						EventIndexEnabled:        true,
+						EventIndexSyncInterval:   5 * time.Second,
+						EventIndexResyncInterval: 10 * time.Minute,
+						LabelValueLimit:          100,
+						LabelValueLimits: map[string]int{
+							"brigade_events_total":  10,
+							"brigade_jobs_by_phase": 20,
+						},
+						ReadinessFailureThreshold: 5 * time.Minute,
+						BackoffMaxInterval:        5 * time.Minute,
+						PreflightMode:             preflightModeFail,
+						PreflightTimeout:          2 * time.Minute,
+						Collectors: map[string]collectorConfig{
+							collectorUsers: {
+								Disabled: true,
+							},
+							collectorDurations: {
+								Interval: time.Minute,
+							},
+						},
+					},
+					exporterConfig,
+				)
+				require.Equal(
+					t,
+					http.ServerConfig{
+						Port:        9090,
+						TLSEnabled:  true,
+						TLSCertPath: "/var/ssl/cert",
+						TLSKeyPath:  "/var/ssl/key",
+					},
+					serverConfig,
+				)
+			},
+		},
+		{
+			name: "environment variables override file values",
+			setup: func() {
+				t.Setenv("API_ADDRESS", "bat")
+				t.Setenv("API_TOKEN", "baz")
+				t.Setenv("COLLECTION_MODE", "poll")
+				t.Setenv("PROM_SCRAPE_INTERVAL", "10s")
+				t.Setenv("RECEIVER_PORT", "8080")
+				t.Setenv("TLS_ENABLED", "false")
+				t.Setenv("LABEL_VALUE_LIMITS", "brigade_events_total=30")
+				t.Setenv("COLLECTOR_USERS_ENABLED", "true")
+			},
+			assertions: func(
+				address string,
+				token string,
+				exporterConfig metricsExporterConfig,
+				serverConfig http.ServerConfig,
+			) {
+				require.Equal(t, "bat", address)
+				require.Equal(t, "baz", token)
+				require.Equal(t, collectionModePoll, exporterConfig.CollectionMode)
+				require.Equal(t, 10*time.Second, exporterConfig.ScrapeInterval)
+				require.NotContains(t, exporterConfig.Collectors, collectorUsers)
+				require.Equal(
+					t,
+					map[string]int{
+						"brigade_events_total":  30,
+						"brigade_jobs_by_phase": 20,
+					},
+					exporterConfig.LabelValueLimits,
+				)
+				// Values not overridden still come from the file
+				require.Equal(t, paginationModeWalk, exporterConfig.PaginationMode)
+				require.Equal(
+					t,
+					collectorConfig{Interval: time.Minute},
+					exporterConfig.Collectors[collectorDurations],
+				)
+				require.Equal(
+					t,
+					30*time.Second,
+					exporterConfig.APIRequestTimeout,
+				)
+				require.Equal(
+					t,
+					http.ServerConfig{
+						Port: 8080,
+					},
+					serverConfig,
+				)
+			},
+		},
+		{
+			name: "invalid environment variable overrides valid file value",
+			setup: func() {
+				t.Setenv("COLLECTION_MODE", "foo")
+			},
+			assertions: func(
+				_ string,
+				_ string,
+				exporterConfig metricsExporterConfig,
+				_ http.ServerConfig,
+			) {
+				require.Equal(
+					t,
+					collectionMode("foo"),
+					exporterConfig.CollectionMode,
+				)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			testCase.setup()
+			address, token, _, err := apiClientConfig(file)
+			require.NoError(t, err)
+			exporterConfig, exporterErr := exporterConfig(file)
+			serverConfig, err := serverConfig(file)
+			require.NoError(t, err)
+			if exporterErr != nil {
+				require.Contains(t, exporterErr.Error(), "COLLECTION_MODE")
+			}
+			testCase.assertions(address, token, exporterConfig, serverConfig)
+		})
+	}
+}
+
+func TestCollectorFlags(t *testing.T) {
+	testCases := []struct {
+		name       string
+		args       []string
+		configs    map[string]collectorConfig
+		assertions func(map[string]collectorConfig, error)
+	}{
+		{
+			name: "no flags set",
+			configs: map[string]collectorConfig{
+				collectorUsers: {
+					Disabled: true,
+				},
+			},
+			assertions: func(configs map[string]collectorConfig, err error) {
+				require.NoError(t, err)
+				require.Equal(
+					t,
+					map[string]collectorConfig{
+						collectorUsers: {
+							Disabled: true,
+						},
+					},
+					configs,
+				)
+			},
+		},
+		{
+			name: "negative interval",
+			args: []string{"--collector.durations.interval=-1m"},
+			assertions: func(_ map[string]collectorConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "is invalid")
+				require.Contains(t, err.Error(), "collector.durations.interval")
+			},
+		},
+		{
+			name: "contradictory flags",
+			args: []string{"--no-collector.users", "--collector.users"},
+			assertions: func(_ map[string]collectorConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "contradict")
+				require.Contains(t, err.Error(), "collector.users")
+			},
+		},
+		{
+			name: "redundant flags",
+			args: []string{"--no-collector.users", "--collector.users=false"},
+			assertions: func(configs map[string]collectorConfig, err error) {
+				require.NoError(t, err)
+				require.Equal(
+					t,
+					map[string]collectorConfig{
+						collectorUsers: {
+							Disabled: true,
+						},
+					},
+					configs,
+				)
+			},
+		},
+		{
+			name: "flags override other configuration",
+			args: []string{
+				"--collector.users",
+				"--no-collector.projects",
+				"--collector.durations.interval=1m",
+			},
+			configs: map[string]collectorConfig{
+				collectorUsers: {
+					Disabled: true,
+				},
+				collectorDurations: {
+					Interval: time.Hour,
+				},
+			},
+			assertions: func(configs map[string]collectorConfig, err error) {
+				require.NoError(t, err)
+				require.Equal(
+					t,
+					map[string]collectorConfig{
+						collectorUsers: {},
+						collectorProjects: {
+							Disabled: true,
+						},
+						collectorDurations: {
+							Interval: time.Minute,
+						},
+					},
+					configs,
+				)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			flagSet := flag.NewFlagSet("test", flag.ContinueOnError)
+			flags := newCollectorFlags(flagSet)
+			require.NoError(t, flagSet.Parse(testCase.args))
+			configs, err := flags.apply(testCase.configs)
+			testCase.assertions(configs, err)
+		})
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// dedupeStore remembers which keys (e.g. Event IDs) have already been observed
+// so that a value derived from a finished Worker or Job is recorded exactly
+// once, no matter how many times that Worker or Job is subsequently listed.
+//
+// To keep memory use bounded, keys are forgotten once the time associated with
+// them falls outside of the retention window. Anything whose associated time
+// precedes that window (or precedes the creation of the store) is never
+// considered new to begin with, so forgetting such keys is safe.
+type dedupeStore struct {
+	retention time.Duration
+	// watermark is the earliest time for which keys are still tracked.
+	watermark time.Time
+	seen      map[string]time.Time
+	mu        sync.Mutex
+}
+
+// newDedupeStore returns a dedupeStore that tracks keys associated with times
+// no older than the specified retention window. Keys associated with times
+// preceding the store's creation are ignored so that historical Workers and
+// Jobs are not all observed at once when the exporter starts.
+func newDedupeStore(retention time.Duration, now time.Time) *dedupeStore {
+	return &dedupeStore{
+		retention: retention,
+		watermark: now,
+		seen:      map[string]time.Time{},
+	}
+}
+
+// add records the specified key as having been observed and returns true if
+// it was not already known and its associated time falls within the retention
+// window. It returns false in all other cases.
+func (d *dedupeStore) add(key string, t time.Time) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if t.Before(d.watermark) {
+		return false
+	}
+	if _, ok := d.seen[key]; ok {
+		return false
+	}
+	d.seen[key] = t
+	return true
+}
+
+// earliest returns the earliest time for which keys are still tracked. Keys
+// associated with any earlier time are never considered new.
+func (d *dedupeStore) earliest() time.Time {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.watermark
+}
+
+// prune advances the retention window relative to the specified time and
+// forgets all keys that have fallen outside of it.
+func (d *dedupeStore) prune(now time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if watermark := now.Add(-d.retention); watermark.After(d.watermark) {
+		d.watermark = watermark
+	}
+	for key, t := range d.seen {
+		if t.Before(d.watermark) {
+			delete(d.seen, key)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewDedupeStore(t *testing.T) {
+	now := time.Now()
+	store := newDedupeStore(time.Minute, now)
+	require.Equal(t, time.Minute, store.retention)
+	require.Equal(t, now, store.watermark)
+	require.NotNil(t, store.seen)
+}
+
+func TestDedupeStoreAdd(t *testing.T) {
+	now := time.Now()
+	testCases := []struct {
+		name       string
+		store      *dedupeStore
+		key        string
+		t          time.Time
+		assertions func(*dedupeStore, bool)
+	}{
+		{
+			name:  "time precedes watermark",
+			store: newDedupeStore(time.Minute, now),
+			key:   "foo",
+			t:     now.Add(-time.Second),
+			assertions: func(store *dedupeStore, added bool) {
+				require.False(t, added)
+				require.Empty(t, store.seen)
+			},
+		},
+		{
+			name: "key already seen",
+			store: &dedupeStore{
+				watermark: now,
+				seen: map[string]time.Time{
+					"foo": now,
+				},
+			},
+			key: "foo",
+			t:   now.Add(time.Second),
+			assertions: func(store *dedupeStore, added bool) {
+				require.False(t, added)
+				require.Equal(t, now, store.seen["foo"])
+			},
+		},
+		{
+			name:  "key not yet seen",
+			store: newDedupeStore(time.Minute, now),
+			key:   "foo",
+			t:     now.Add(time.Second),
+			assertions: func(store *dedupeStore, added bool) {
+				require.True(t, added)
+				require.Contains(t, store.seen, "foo")
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			added := testCase.store.add(testCase.key, testCase.t)
+			testCase.assertions(testCase.store, added)
+		})
+	}
+}
+
+func TestDedupeStorePrune(t *testing.T) {
+	start := time.Now()
+	store := newDedupeStore(time.Minute, start)
+	require.True(t, store.add("foo", start.Add(time.Second)))
+	require.True(t, store.add("bar", start.Add(time.Minute)))
+
+	// Still within the retention window; nothing should be forgotten
+	store.prune(start.Add(30 * time.Second))
+	require.Equal(t, start, store.watermark)
+	require.Len(t, store.seen, 2)
+
+	// Now foo has fallen out of the window
+	store.prune(start.Add(90 * time.Second))
+	require.Equal(t, start.Add(30*time.Second), store.watermark)
+	require.Len(t, store.seen, 1)
+	require.Contains(t, store.seen, "bar")
+
+	// Re-adding foo must not succeed since it precedes the watermark
+	require.False(t, store.add("foo", start.Add(time.Second)))
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"context"
+	"errors"
+)
+
+const (
+	// errorClassTimeout is the class of errors caused by a request to the
+	// Brigade API exceeding its timeout.
+	errorClassTimeout = "timeout"
+	// errorClassError is the class of all other errors.
+	errorClassError = "error"
+)
+
+// errorClasses returns all of the classes that errorClass may return.
+func errorClasses() []string {
+	return []string{errorClassError, errorClassTimeout}
+}
+
+// errorClass broadly classifies the specified error so that timeouts, which
+// usually indicate that the API server is struggling or unreachable, can be
+// distinguished from other errors.
+func errorClass(err error) string {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return errorClassTimeout
+	}
+	return errorClassError
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	pkgErrors "github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestErrorClass(t *testing.T) {
+	testCases := []struct {
+		name          string
+		err           error
+		expectedClass string
+	}{
+		{
+			name:          "deadline exceeded",
+			err:           context.DeadlineExceeded,
+			expectedClass: errorClassTimeout,
+		},
+		{
+			name: "wrapped deadline exceeded",
+			err: pkgErrors.Wrap(
+				context.DeadlineExceeded,
+				"error invoking API",
+			),
+			expectedClass: errorClassTimeout,
+		},
+		{
+			name:          "other error",
+			err:           errors.New("something went wrong"),
+			expectedClass: errorClassError,
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			require.Equal(t, testCase.expectedClass, errorClass(testCase.err))
+		})
+	}
+}
@@ -0,0 +1,381 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/brigadecore/brigade/sdk/v3"
+	"github.com/brigadecore/brigade/sdk/v3/meta"
+	"github.com/pkg/errors"
+)
+
+// eventIndex is an in-memory index of all Events that spares event-based
+// collectors from listing every Event every time they run. It lists all Events
+// once, then keeps itself current by polling only for Events that are new or
+// may have changed:
+//
+//  1. New Events are listed newest first until an Event that is already known
+//     is encountered.
+//  2. Events whose Workers have not yet reached a terminal phase are listed
+//     and updated.
+//  3. Any Event previously known to have a non-terminal Worker that no longer
+//     appears in that list has changed phase (or been deleted) and is
+//     retrieved individually.
+//
+// Deletion of Events whose Workers had already reached a terminal phase goes
+// unnoticed by the above, so the index is periodically rebuilt from scratch to
+// correct any such drift.
+//
+// Counts of Events by project and Worker phase are maintained incrementally as
+// the index is updated so that the most common queries don't require a scan.
+type eventIndex struct {
+	eventsClient sdk.EventsClient
+	pager        pager
+	// syncInterval is the minimum amount of time between polls for new or
+	// changed Events.
+	syncInterval time.Duration
+	// resyncInterval is the amount of time between rebuilds of the index.
+	resyncInterval time.Duration
+	events         map[string]sdk.Event
+	byPhase        map[sdk.WorkerPhase]int
+	byProjectPhase map[projectPhase]int
+	lastSync       time.Time
+	lastResync     time.Time
+	mu             sync.Mutex
+}
+
+// projectPhase identifies a project and Worker phase for the purposes of
+// counting Events.
+type projectPhase struct {
+	projectID string
+	phase     sdk.WorkerPhase
+}
+
+// newEventIndex returns an empty eventIndex. It will be populated the first
+// time it is queried.
+func newEventIndex(
+	eventsClient sdk.EventsClient,
+	pager pager,
+	syncInterval time.Duration,
+	resyncInterval time.Duration,
+) *eventIndex {
+	return &eventIndex{
+		eventsClient:   eventsClient,
+		pager:          pager,
+		syncInterval:   syncInterval,
+		resyncInterval: resyncInterval,
+	}
+}
+
+// forEachEvent brings the index up to date if necessary and then invokes the
+// provided function once for every indexed Event matching the provided
+// selector.
+func (e *eventIndex) forEachEvent(
+	ctx context.Context,
+	selector *sdk.EventsSelector,
+	fn func(sdk.Event),
+) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err := e.sync(ctx); err != nil {
+		return err
+	}
+	for _, event := range e.events {
+		if eventMatches(selector, event) {
+			fn(event)
+		}
+	}
+	return nil
+}
+
+// countEvents brings the index up to date if necessary and then returns the
+// number of indexed Events matching the provided selector.
+func (e *eventIndex) countEvents(
+	ctx context.Context,
+	selector *sdk.EventsSelector,
+) (int, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err := e.sync(ctx); err != nil {
+		return 0, err
+	}
+	if selector.Source != "" ||
+		selector.Type != "" ||
+		len(selector.SourceState) > 0 ||
+		len(selector.Qualifiers) > 0 ||
+		len(selector.Labels) > 0 {
+		// No counts are maintained for these criteria, so fall back to a scan
+		var count int
+		for _, event := range e.events {
+			if eventMatches(selector, event) {
+				count++
+			}
+		}
+		return count, nil
+	}
+	phases := selector.WorkerPhases
+	if len(phases) == 0 {
+		phases = sdk.WorkerPhasesAll()
+	}
+	var count int
+	for _, phase := range phases {
+		if selector.ProjectID == "" {
+			count += e.byPhase[phase]
+		} else {
+			count += e.byProjectPhase[projectPhase{
+				projectID: selector.ProjectID,
+				phase:     phase,
+			}]
+		}
+	}
+	return count, nil
+}
+
+// sync rebuilds the index if it has never been built or is due to be rebuilt
+// and otherwise polls for new or changed Events if it hasn't done so recently.
+// The caller must hold the lock.
+func (e *eventIndex) sync(ctx context.Context) error {
+	now := time.Now()
+	if e.events == nil || now.Sub(e.lastResync) >= e.resyncInterval {
+		if err := e.resync(ctx); err != nil {
+			return err
+		}
+		e.lastResync = now
+		e.lastSync = now
+		return nil
+	}
+	if now.Sub(e.lastSync) < e.syncInterval {
+		return nil
+	}
+	if err := e.update(ctx); err != nil {
+		return err
+	}
+	e.lastSync = now
+	return nil
+}
+
+// resync rebuilds the index from scratch. The index is left untouched if
+// anything goes wrong.
+func (e *eventIndex) resync(ctx context.Context) error {
+	index := &eventIndex{}
+	index.reset()
+	if err := e.list(
+		ctx,
+		&sdk.EventsSelector{
+			WorkerPhases: sdk.WorkerPhasesAll(),
+		},
+		func(event sdk.Event) bool {
+			index.put(event)
+			return true
+		},
+	); err != nil {
+		return errors.Wrap(err, "error building event index")
+	}
+	e.events = index.events
+	e.byPhase = index.byPhase
+	e.byProjectPhase = index.byProjectPhase
+	return nil
+}
+
+// update polls for new or changed Events and updates the index accordingly.
+// Changes are applied only once every request has succeeded. Were they applied
+// as they were found, a failure partway through listing new Events would leave
+// the newest of them indexed and cause the next update to stop listing upon
+// encountering them, never indexing the older ones.
+func (e *eventIndex) update(ctx context.Context) error {
+	// New Events
+	newEvents := []sdk.Event{}
+	if err := e.list(
+		ctx,
+		&sdk.EventsSelector{
+			WorkerPhases: sdk.WorkerPhasesAll(),
+		},
+		func(event sdk.Event) bool {
+			if _, ok := e.events[event.ID]; ok {
+				return false
+			}
+			newEvents = append(newEvents, event)
+			return true
+		},
+	); err != nil {
+		return errors.Wrap(err, "error listing new events")
+	}
+	// Events that are, or were, in progress
+	inProgress := map[string]struct{}{}
+	for id, event := range e.events {
+		if !eventPhase(event).IsTerminal() {
+			inProgress[id] = struct{}{}
+		}
+	}
+	for _, event := range newEvents {
+		if !eventPhase(event).IsTerminal() {
+			inProgress[event.ID] = struct{}{}
+		}
+	}
+	updated := map[string]sdk.Event{}
+	if err := e.list(
+		ctx,
+		&sdk.EventsSelector{
+			WorkerPhases: workerPhasesNonTerminal(),
+		},
+		func(event sdk.Event) bool {
+			updated[event.ID] = event
+			return true
+		},
+	); err != nil {
+		return errors.Wrap(err, "error listing in-progress events")
+	}
+	// Events that have left the in-progress list. A nil Event indicates the
+	// Event no longer exists.
+	refreshed := map[string]*sdk.Event{}
+	for id := range inProgress {
+		if _, ok := updated[id]; ok {
+			continue
+		}
+		event, err := e.get(ctx, id)
+		if err != nil {
+			return errors.Wrapf(err, "error retrieving event %q", id)
+		}
+		refreshed[id] = event
+	}
+	for _, event := range newEvents {
+		e.put(event)
+	}
+	for _, event := range updated {
+		e.put(event)
+	}
+	for id, event := range refreshed {
+		if event == nil {
+			e.remove(id)
+		} else {
+			e.put(*event)
+		}
+	}
+	return nil
+}
+
+// get retrieves a single Event. It returns nil if the Event does not exist.
+func (e *eventIndex) get(ctx context.Context, id string) (*sdk.Event, error) {
+	ctx, cancel := requestContext(ctx, e.pager.requestTimeout)
+	defer cancel()
+	event, err := e.eventsClient.Get(ctx, id, nil)
+	if err != nil {
+		var notFoundErr *meta.ErrNotFound
+		if errors.As(err, &notFoundErr) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &event, nil
+}
+
+// list invokes the provided function once for every Event matching the
+// provided selector, newest first, until the function returns false.
+func (e *eventIndex) list(
+	ctx context.Context,
+	selector *sdk.EventsSelector,
+	fn func(sdk.Event) bool,
+) error {
+	return e.pager.forEachPage(
+		ctx,
+		func(
+			ctx context.Context,
+			opts *meta.ListOptions,
+		) (int, meta.ListMeta, error) {
+			events, err := e.eventsClient.List(ctx, selector, opts)
+			if err != nil {
+				return 0, events.ListMeta, err
+			}
+			for _, event := range events.Items {
+				if !fn(event) {
+					// Returning no continue value stops the pager
+					return len(events.Items), meta.ListMeta{}, nil
+				}
+			}
+			return len(events.Items), events.ListMeta, nil
+		},
+	)
+}
+
+// reset empties the index.
+func (e *eventIndex) reset() {
+	e.events = map[string]sdk.Event{}
+	e.byPhase = map[sdk.WorkerPhase]int{}
+	e.byProjectPhase = map[projectPhase]int{}
+}
+
+// put adds the provided Event to the index or replaces the indexed copy of it.
+func (e *eventIndex) put(event sdk.Event) {
+	e.remove(event.ID)
+	// The index has no use for these potentially large fields
+	event.Payload = ""
+	event.Summary = ""
+	event.SourceState = nil
+	e.events[event.ID] = event
+	e.count(event, 1)
+}
+
+// remove removes the Event having the specified ID from the index, if present.
+func (e *eventIndex) remove(id string) {
+	if event, ok := e.events[id]; ok {
+		e.count(event, -1)
+		delete(e.events, id)
+	}
+}
+
+// count adjusts the counts for the provided Event's project and Worker phase
+// by the specified amount.
+func (e *eventIndex) count(event sdk.Event, delta int) {
+	phase := eventPhase(event)
+	e.byPhase[phase] += delta
+	key := projectPhase{projectID: event.ProjectID, phase: phase}
+	e.byProjectPhase[key] += delta
+	if e.byProjectPhase[key] == 0 {
+		delete(e.byProjectPhase, key)
+	}
+}
+
+// eventPhase returns the phase of the provided Event's Worker.
+func eventPhase(event sdk.Event) sdk.WorkerPhase {
+	if event.Worker == nil {
+		return sdk.WorkerPhaseUnknown
+	}
+	return event.Worker.Status.Phase
+}
+
+// eventMatches returns true if the provided Event satisfies the criteria of
+// the provided selector. Only the criteria used by collectors are considered.
+func eventMatches(selector *sdk.EventsSelector, event sdk.Event) bool {
+	if selector.ProjectID != "" && selector.ProjectID != event.ProjectID {
+		return false
+	}
+	if selector.Source != "" && selector.Source != event.Source {
+		return false
+	}
+	if selector.Type != "" && selector.Type != event.Type {
+		return false
+	}
+	if len(selector.WorkerPhases) == 0 {
+		return true
+	}
+	phase := eventPhase(event)
+	for _, p := range selector.WorkerPhases {
+		if p == phase {
+			return true
+		}
+	}
+	return false
+}
+
+// workerPhasesNonTerminal returns all non-terminal WorkerPhases. The SDK offers
+// an equivalent, but it omits WorkerPhaseStarting.
+func workerPhasesNonTerminal() []sdk.WorkerPhase {
+	phases := []sdk.WorkerPhase{}
+	for _, phase := range sdk.WorkerPhasesAll() {
+		if !phase.IsTerminal() {
+			phases = append(phases, phase)
+		}
+	}
+	return phases
+}
@@ -0,0 +1,363 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/brigadecore/brigade/sdk/v3"
+	"github.com/brigadecore/brigade/sdk/v3/meta"
+	sdkTesting "github.com/brigadecore/brigade/sdk/v3/testing"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeEventStore stands in for the Brigade API's store of Events. Events are
+// kept newest first, just as the API server lists them.
+type fakeEventStore struct {
+	events []sdk.Event
+	// listed counts how many Events have been returned by List
+	listed int
+	// got records the IDs of Events that have been retrieved by Get
+	got []string
+	err error
+	// pageErr, if set, is returned when listing any page but the first
+	pageErr error
+}
+
+func (f *fakeEventStore) client() sdk.EventsClient {
+	return &sdkTesting.MockEventsClient{
+		ListFn: func(
+			_ context.Context,
+			selector *sdk.EventsSelector,
+			opts *meta.ListOptions,
+		) (sdk.EventList, error) {
+			if f.err != nil {
+				return sdk.EventList{}, f.err
+			}
+			if f.pageErr != nil && opts.Continue != "" {
+				return sdk.EventList{}, f.pageErr
+			}
+			matches := []sdk.Event{}
+			for _, event := range f.events {
+				if eventMatches(selector, event) {
+					matches = append(matches, event)
+				}
+			}
+			start, _ := strconv.Atoi(opts.Continue)
+			end := len(matches)
+			if opts.Limit > 0 && start+int(opts.Limit) < end {
+				end = start + int(opts.Limit)
+			}
+			list := sdk.EventList{Items: matches[start:end]}
+			if end < len(matches) {
+				list.Continue = strconv.Itoa(end)
+			}
+			f.listed += len(list.Items)
+			return list, nil
+		},
+		GetFn: func(
+			_ context.Context,
+			id string,
+			_ *sdk.EventGetOptions,
+		) (sdk.Event, error) {
+			f.got = append(f.got, id)
+			if f.err != nil {
+				return sdk.Event{}, f.err
+			}
+			for _, event := range f.events {
+				if event.ID == id {
+					return event, nil
+				}
+			}
+			return sdk.Event{}, &meta.ErrNotFound{Type: "Event", ID: id}
+		},
+	}
+}
+
+// put adds the provided Event as the newest or replaces it in place.
+func (f *fakeEventStore) put(event sdk.Event) {
+	for i := range f.events {
+		if f.events[i].ID == event.ID {
+			f.events[i] = event
+			return
+		}
+	}
+	f.events = append([]sdk.Event{event}, f.events...)
+}
+
+func (f *fakeEventStore) remove(id string) {
+	for i := range f.events {
+		if f.events[i].ID == id {
+			f.events = append(f.events[:i], f.events[i+1:]...)
+			return
+		}
+	}
+}
+
+func newIndexedEvent(
+	id string,
+	projectID string,
+	phase sdk.WorkerPhase,
+) sdk.Event {
+	return sdk.Event{
+		ObjectMeta: meta.ObjectMeta{ID: id},
+		ProjectID:  projectID,
+		Source:     "brigade.sh/cli",
+		Type:       "exec",
+		Payload:    "a large payload",
+		Worker: &sdk.Worker{
+			Status: sdk.WorkerStatus{Phase: phase},
+		},
+	}
+}
+
+func TestEventIndexBuild(t *testing.T) {
+	store := &fakeEventStore{
+		events: []sdk.Event{
+			newIndexedEvent("4", "italian", sdk.WorkerPhasePending),
+			newIndexedEvent("3", "italian", sdk.WorkerPhaseRunning),
+			newIndexedEvent("2", "thai", sdk.WorkerPhaseSucceeded),
+			newIndexedEvent("1", "italian", sdk.WorkerPhaseSucceeded),
+		},
+	}
+	index := newEventIndex(store.client(), pager{pageSize: 3}, 0, time.Hour)
+	testCases := []struct {
+		name     string
+		selector *sdk.EventsSelector
+		count    int
+	}{
+		{
+			name:     "all events",
+			selector: &sdk.EventsSelector{},
+			count:    4,
+		},
+		{
+			name: "by phase",
+			selector: &sdk.EventsSelector{
+				WorkerPhases: []sdk.WorkerPhase{sdk.WorkerPhaseSucceeded},
+			},
+			count: 2,
+		},
+		{
+			name: "by project and phase",
+			selector: &sdk.EventsSelector{
+				ProjectID:    "italian",
+				WorkerPhases: []sdk.WorkerPhase{sdk.WorkerPhaseSucceeded},
+			},
+			count: 1,
+		},
+		{
+			name: "by source",
+			selector: &sdk.EventsSelector{
+				Source: "brigade.sh/github",
+			},
+			count: 0,
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			count, err := index.countEvents(context.Background(), testCase.selector)
+			require.NoError(t, err)
+			require.Equal(t, testCase.count, count)
+		})
+	}
+	ids := []string{}
+	err := index.forEachEvent(
+		context.Background(),
+		&sdk.EventsSelector{ProjectID: "thai"},
+		func(event sdk.Event) {
+			ids = append(ids, event.ID)
+			// Large fields aren't retained
+			require.Empty(t, event.Payload)
+		},
+	)
+	require.NoError(t, err)
+	require.Equal(t, []string{"2"}, ids)
+}
+
+func TestEventIndexUpdate(t *testing.T) {
+	store := &fakeEventStore{
+		events: []sdk.Event{
+			newIndexedEvent("5", "italian", sdk.WorkerPhasePending),
+			newIndexedEvent("4", "italian", sdk.WorkerPhaseRunning),
+			newIndexedEvent("3", "thai", sdk.WorkerPhaseRunning),
+			newIndexedEvent("2", "thai", sdk.WorkerPhaseSucceeded),
+			newIndexedEvent("1", "italian", sdk.WorkerPhaseSucceeded),
+		},
+	}
+	index := newEventIndex(store.client(), pager{pageSize: 2}, 0, time.Hour)
+	count, err := index.countEvents(
+		context.Background(),
+		&sdk.EventsSelector{},
+	)
+	require.NoError(t, err)
+	require.Equal(t, 5, count)
+
+	// Two new Events, one Event that has finished, and one that was deleted
+	store.put(newIndexedEvent("6", "thai", sdk.WorkerPhasePending))
+	store.put(newIndexedEvent("7", "thai", sdk.WorkerPhaseSucceeded))
+	store.put(newIndexedEvent("4", "italian", sdk.WorkerPhaseFailed))
+	store.remove("5")
+	store.listed = 0
+
+	count, err = index.countEvents(
+		context.Background(),
+		&sdk.EventsSelector{
+			WorkerPhases: []sdk.WorkerPhase{sdk.WorkerPhasePending},
+		},
+	)
+	require.NoError(t, err)
+	require.Equal(t, 1, count)
+	// Only the new Events (plus one page overlapping known Events) and the
+	// in-progress Events should have been listed
+	require.Equal(t, 4+2, store.listed)
+	// Only Events that left the in-progress list should have been retrieved
+	require.ElementsMatch(t, []string{"4", "5"}, store.got)
+
+	for _, testCase := range []struct {
+		selector *sdk.EventsSelector
+		count    int
+	}{
+		{
+			selector: &sdk.EventsSelector{},
+			count:    6,
+		},
+		{
+			selector: &sdk.EventsSelector{
+				ProjectID:    "italian",
+				WorkerPhases: []sdk.WorkerPhase{sdk.WorkerPhaseFailed},
+			},
+			count: 1,
+		},
+		{
+			selector: &sdk.EventsSelector{
+				ProjectID:    "italian",
+				WorkerPhases: workerPhasesNonTerminal(),
+			},
+			count: 0,
+		},
+		{
+			selector: &sdk.EventsSelector{
+				ProjectID: "thai",
+			},
+			count: 4,
+		},
+	} {
+		count, err = index.countEvents(context.Background(), testCase.selector)
+		require.NoError(t, err)
+		require.Equal(t, testCase.count, count)
+	}
+}
+
+func TestEventIndexSyncInterval(t *testing.T) {
+	store := &fakeEventStore{
+		events: []sdk.Event{
+			newIndexedEvent("1", "italian", sdk.WorkerPhasePending),
+		},
+	}
+	index := newEventIndex(store.client(), pager{}, time.Hour, time.Hour)
+	selector := &sdk.EventsSelector{}
+	count, err := index.countEvents(context.Background(), selector)
+	require.NoError(t, err)
+	require.Equal(t, 1, count)
+	store.put(newIndexedEvent("2", "italian", sdk.WorkerPhasePending))
+	// Not enough time has passed to poll again
+	count, err = index.countEvents(context.Background(), selector)
+	require.NoError(t, err)
+	require.Equal(t, 1, count)
+	index.lastSync = time.Now().Add(-time.Hour)
+	count, err = index.countEvents(context.Background(), selector)
+	require.NoError(t, err)
+	require.Equal(t, 2, count)
+}
+
+func TestEventIndexResync(t *testing.T) {
+	store := &fakeEventStore{
+		events: []sdk.Event{
+			newIndexedEvent("2", "italian", sdk.WorkerPhaseSucceeded),
+			newIndexedEvent("1", "italian", sdk.WorkerPhaseSucceeded),
+		},
+	}
+	index := newEventIndex(store.client(), pager{}, 0, time.Hour)
+	selector := &sdk.EventsSelector{}
+	count, err := index.countEvents(context.Background(), selector)
+	require.NoError(t, err)
+	require.Equal(t, 2, count)
+	// Deletion of an Event that had already finished goes unnoticed...
+	store.remove("1")
+	count, err = index.countEvents(context.Background(), selector)
+	require.NoError(t, err)
+	require.Equal(t, 2, count)
+	// ...until the index is rebuilt
+	index.lastResync = time.Now().Add(-time.Hour)
+	count, err = index.countEvents(context.Background(), selector)
+	require.NoError(t, err)
+	require.Equal(t, 1, count)
+}
+
+func TestEventIndexErrors(t *testing.T) {
+	store := &fakeEventStore{
+		err: errors.New("something went wrong"),
+	}
+	index := newEventIndex(store.client(), pager{}, 0, time.Hour)
+	selector := &sdk.EventsSelector{}
+	_, err := index.countEvents(context.Background(), selector)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "error building event index")
+	require.Contains(t, err.Error(), "something went wrong")
+
+	store.err = nil
+	store.put(newIndexedEvent("1", "italian", sdk.WorkerPhaseRunning))
+	count, err := index.countEvents(context.Background(), selector)
+	require.NoError(t, err)
+	require.Equal(t, 1, count)
+
+	// A failed update leaves the index as it was
+	store.err = errors.New("something went wrong")
+	err = index.forEachEvent(context.Background(), selector, func(sdk.Event) {})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "error listing new events")
+	store.err = nil
+	store.put(newIndexedEvent("1", "italian", sdk.WorkerPhaseSucceeded))
+	count, err = index.countEvents(
+		context.Background(),
+		&sdk.EventsSelector{
+			WorkerPhases: []sdk.WorkerPhase{sdk.WorkerPhaseSucceeded},
+		},
+	)
+	require.NoError(t, err)
+	require.Equal(t, 1, count)
+}
+
+func TestEventIndexPartialUpdate(t *testing.T) {
+	store := &fakeEventStore{
+		events: []sdk.Event{
+			newIndexedEvent("1", "italian", sdk.WorkerPhaseSucceeded),
+		},
+	}
+	index := newEventIndex(store.client(), pager{pageSize: 2}, 0, time.Hour)
+	selector := &sdk.EventsSelector{}
+	count, err := index.countEvents(context.Background(), selector)
+	require.NoError(t, err)
+	require.Equal(t, 1, count)
+
+	// More new Events than fit on one page, but only the first page is listed
+	// successfully
+	for i := 2; i <= 4; i++ {
+		store.put(
+			newIndexedEvent(strconv.Itoa(i), "italian", sdk.WorkerPhaseSucceeded),
+		)
+	}
+	store.pageErr = errors.New("something went wrong")
+	_, err = index.countEvents(context.Background(), selector)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "error listing new events")
+
+	// None of the new Events were indexed, so all are found by the next update
+	store.pageErr = nil
+	count, err = index.countEvents(context.Background(), selector)
+	require.NoError(t, err)
+	require.Equal(t, 4, count)
+}
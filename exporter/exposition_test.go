@@ -0,0 +1,219 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/brigadecore/brigade/sdk/v3"
+	"github.com/brigadecore/brigade/sdk/v3/meta"
+	"github.com/stretchr/testify/require"
+)
+
+// Run `go test -run TestExposition -update` to regenerate the golden files
+// after an intentional change to the exposition. Review the resulting diff
+// carefully. Dashboards and alert rules depend upon these names and labels.
+var updateGolden = flag.Bool(
+	"update",
+	false,
+	"update golden files in testdata instead of comparing against them",
+)
+
+// volatileMetricNames are the names of metric families whose values depend
+// upon timing or randomness and therefore cannot be compared against golden
+// files. Their names, help text, and labels are still compared.
+var volatileMetricNames = map[string]struct{}{
+	"brigade_exporter_collector_backoff_seconds":      {},
+	"brigade_exporter_last_success_timestamp_seconds": {},
+	"brigade_exporter_scrape_duration_seconds":        {},
+	"brigade_oldest_pending_event_age_seconds":        {},
+}
+
+// fakeAPIFixturesForExposition returns resources for the fake Brigade API to
+// serve that exercise every metric. Workers and Jobs finish after the exporter
+// starts, so their durations are observed, and they do so at fixed offsets
+// from one another, so those durations are always the same.
+func fakeAPIFixturesForExposition() fakeAPIFixtures {
+	base := time.Now().Add(time.Hour)
+	pendingSince := time.Now().Add(-10 * time.Minute)
+	at := func(offset time.Duration) *time.Time {
+		t := base.Add(offset)
+		return &t
+	}
+	newEvent := func(
+		id string,
+		projectID string,
+		source string,
+		eventType string,
+		phase sdk.WorkerPhase,
+	) sdk.Event {
+		return sdk.Event{
+			ObjectMeta: meta.ObjectMeta{ID: id, Created: at(0)},
+			ProjectID:  projectID,
+			Source:     source,
+			Type:       eventType,
+			Worker: &sdk.Worker{
+				Status: sdk.WorkerStatus{Phase: phase},
+			},
+		}
+	}
+	pending := newEvent(
+		"6", "italian", "brigade.sh/cli", "exec", sdk.WorkerPhasePending,
+	)
+	pending.Created = &pendingSince
+	running := newEvent(
+		"5", "thai", "brigade.sh/github", "push", sdk.WorkerPhaseRunning,
+	)
+	running.Worker.Status.Started = at(30 * time.Second)
+	running.Worker.Jobs = []sdk.Job{
+		{
+			Name:   "build",
+			Status: &sdk.JobStatus{Phase: sdk.JobPhaseRunning},
+		},
+		{
+			Name: "test",
+			Status: &sdk.JobStatus{
+				Phase:   sdk.JobPhaseSucceeded,
+				Started: at(40 * time.Second),
+				Ended:   at(70 * time.Second),
+			},
+		},
+	}
+	succeeded := newEvent(
+		"4", "italian", "brigade.sh/github", "push", sdk.WorkerPhaseSucceeded,
+	)
+	succeeded.Worker.Status.Started = at(30 * time.Second)
+	succeeded.Worker.Status.Ended = at(90 * time.Second)
+	failed := newEvent(
+		"3", "thai", "brigade.sh/github", "pull_request", sdk.WorkerPhaseFailed,
+	)
+	failed.Worker.Status.Started = at(30 * time.Second)
+	failed.Worker.Status.Ended = at(90 * time.Second)
+	failed.Worker.Jobs = []sdk.Job{
+		{
+			Name: "build",
+			Status: &sdk.JobStatus{
+				Phase:   sdk.JobPhaseFailed,
+				Started: at(40 * time.Second),
+				Ended:   at(70 * time.Second),
+			},
+		},
+	}
+	cli := newEvent(
+		"2", "mexican", "brigade.sh/cli", "exec", sdk.WorkerPhaseSucceeded,
+	)
+	cli.Worker.Status.Started = at(30 * time.Second)
+	cli.Worker.Status.Ended = at(90 * time.Second)
+	aborted := newEvent(
+		"1", "mexican", "brigade.sh/cli", "exec", sdk.WorkerPhaseAborted,
+	)
+	return fakeAPIFixtures{
+		Projects: []sdk.Project{
+			{ObjectMeta: meta.ObjectMeta{ID: "italian"}},
+			{ObjectMeta: meta.ObjectMeta{ID: "mexican"}},
+			{ObjectMeta: meta.ObjectMeta{ID: "thai"}},
+		},
+		Users: []sdk.User{
+			{ObjectMeta: meta.ObjectMeta{ID: "tony@starkindustries.com"}},
+			{ObjectMeta: meta.ObjectMeta{ID: "pepper@starkindustries.com"}},
+		},
+		ServiceAccounts: []sdk.ServiceAccount{
+			{ObjectMeta: meta.ObjectMeta{ID: "jarvis"}},
+		},
+		Events: []sdk.Event{
+			pending,
+			running,
+			succeeded,
+			failed,
+			cli,
+			aborted,
+		},
+		RunningWorkers: 1,
+		RunningJobs:    1,
+	}
+}
+
+// normalizeExposition strips everything not produced by the exporter itself
+// (e.g. Go runtime metrics) from the provided exposition text and masks the
+// values of volatile metrics.
+func normalizeExposition(exposition string) string {
+	var normalized strings.Builder
+	var family string
+	for _, line := range strings.Split(exposition, "\n") {
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "#") {
+			// Both HELP and TYPE lines name the family that follows
+			if fields := strings.Fields(line); len(fields) > 2 {
+				family = fields[2]
+			}
+		}
+		if !strings.HasPrefix(family, "brigade_") {
+			continue
+		}
+		if _, ok := volatileMetricNames[family]; ok &&
+			!strings.HasPrefix(line, "#") {
+			line = line[:strings.LastIndex(line, " ")] + " <volatile>"
+		}
+		normalized.WriteString(line)
+		normalized.WriteString("\n")
+	}
+	return normalized.String()
+}
+
+func TestExposition(t *testing.T) {
+	testCases := []struct {
+		name  string
+		setup func(*testing.T, *fakeAPI)
+	}{
+		{
+			name: "default",
+			setup: func(t *testing.T, _ *fakeAPI) {
+				t.Setenv("MAX_CONCURRENT_WORKERS", "4")
+			},
+		},
+		{
+			name: "label_value_limits",
+			setup: func(t *testing.T, _ *fakeAPI) {
+				t.Setenv("LABEL_VALUE_LIMIT", "1")
+				t.Setenv("EVENT_SOURCE_PATTERN", "brigade.sh/github")
+			},
+		},
+		{
+			name: "api_unavailable",
+			setup: func(t *testing.T, _ *fakeAPI) {
+				t.Setenv("API_TOKEN", "bogus")
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			api := newFakeAPI(t, fakeAPIFixturesForExposition())
+			useFakeAPI(t, api)
+			testCase.setup(t, api)
+			handler := startExporter(t)
+			exposition := normalizeExposition(scrape(t, handler))
+			goldenPath := filepath.Join("testdata", testCase.name+".prom")
+			if *updateGolden {
+				err := os.MkdirAll(filepath.Dir(goldenPath), 0750)
+				require.NoError(t, err)
+				err = os.WriteFile(goldenPath, []byte(exposition), 0600)
+				require.NoError(t, err)
+			}
+			golden, err := os.ReadFile(goldenPath)
+			require.NoError(t, err)
+			require.Equal(
+				t,
+				string(golden),
+				exposition,
+				"exposition differs from %s; if the change is intentional, run "+
+					"`go test -run TestExposition -update` and review the diff",
+				goldenPath,
+			)
+		})
+	}
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/brigadecore/brigade/sdk/v3"
+	"github.com/brigadecore/brigade/sdk/v3/meta"
+	"github.com/gorilla/mux"
+)
+
+// fakeAPIToken is the only token the fake Brigade API accepts.
+const fakeAPIToken = "fake-token"
+
+// fakeAPIFixtures are the resources served by the fake Brigade API.
+type fakeAPIFixtures struct {
+	Projects        []sdk.Project
+	Users           []sdk.User
+	ServiceAccounts []sdk.ServiceAccount
+	// Events are served in the order given, so they should be ordered newest
+	// first, just as the real API server orders them.
+	Events         []sdk.Event
+	RunningWorkers int
+	RunningJobs    int
+}
+
+// fakeAPI is an in-process stand-in for the parts of the Brigade REST API that
+// the exporter uses. Lists are paged just as the real API server pages them,
+// and any endpoint can be made to fail or to respond slowly.
+type fakeAPI struct {
+	server   *httptest.Server
+	fixtures fakeAPIFixtures
+	// failures maps request paths to the status code every request for that
+	// path should fail with.
+	failures map[string]int
+	// latencies maps request paths to how long every request for that path
+	// should take.
+	latencies map[string]time.Duration
+	// requests counts the requests received for each path.
+	requests map[string]int
+	mu       sync.Mutex
+}
+
+// defaultFakeAPIPageSize is the page size used when a request doesn't specify
+// one.
+const defaultFakeAPIPageSize = 20
+
+// newFakeAPI starts a fake Brigade API serving the provided fixtures. It is
+// shut down when the test completes.
+func newFakeAPI(t *testing.T, fixtures fakeAPIFixtures) *fakeAPI {
+	f := &fakeAPI{
+		fixtures:  fixtures,
+		failures:  map[string]int{},
+		latencies: map[string]time.Duration{},
+		requests:  map[string]int{},
+	}
+	router := mux.NewRouter()
+	router.HandleFunc("/v2/ping", f.ping)
+	router.HandleFunc("/v2/whoami", f.whoAmI)
+	router.HandleFunc("/v2/projects", f.listProjects)
+	router.HandleFunc("/v2/users", f.listUsers)
+	router.HandleFunc("/v2/service-accounts", f.listServiceAccounts)
+	router.HandleFunc("/v2/events", f.listEvents)
+	router.HandleFunc("/v2/events/{id}", f.getEvent)
+	router.HandleFunc("/v2/substrate/running-workers", f.countRunningWorkers)
+	router.HandleFunc("/v2/substrate/running-jobs", f.countRunningJobs)
+	router.Use(f.middleware)
+	f.server = httptest.NewServer(router)
+	t.Cleanup(f.server.Close)
+	return f
+}
+
+// address returns the address at which the fake Brigade API is listening.
+func (f *fakeAPI) address() string {
+	return f.server.URL
+}
+
+// update applies the provided function to the served fixtures.
+func (f *fakeAPI) update(fn func(*fakeAPIFixtures)) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	fn(&f.fixtures)
+}
+
+// fail causes every request for the specified path to fail with the specified
+// status code. A status code of zero stops the path from failing.
+func (f *fakeAPI) fail(path string, statusCode int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.failures[path] = statusCode
+}
+
+// delay causes every request for the specified path to take the specified
+// amount of time.
+func (f *fakeAPI) delay(path string, latency time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.latencies[path] = latency
+}
+
+// requestCount returns the number of requests received for the specified path.
+func (f *fakeAPI) requestCount(path string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.requests[path]
+}
+
+// middleware authenticates requests and applies any injected latency or
+// failure before handing off to the endpoint.
+func (f *fakeAPI) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		f.requests[r.URL.Path]++
+		latency := f.latencies[r.URL.Path]
+		statusCode := f.failures[r.URL.Path]
+		f.mu.Unlock()
+		select {
+		case <-time.After(latency):
+		case <-r.Context().Done():
+			return
+		}
+		// Like the real API server's, the ping endpoint requires no
+		// authentication
+		if r.URL.Path != "/v2/ping" &&
+			r.Header.Get("Authorization") != "Bearer "+fakeAPIToken {
+			writeFakeAPIResponse(w, http.StatusUnauthorized, &meta.ErrAuthentication{
+				Reason: "Could not authenticate the request.",
+			})
+			return
+		}
+		if statusCode != 0 {
+			writeFakeAPIResponse(w, statusCode, struct{}{})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (f *fakeAPI) ping(w http.ResponseWriter, _ *http.Request) {
+	writeFakeAPIResponse(
+		w,
+		http.StatusOK,
+		sdk.PingResponse{Version: "v2.6.0", Commit: "abcdef"},
+	)
+}
+
+func (f *fakeAPI) whoAmI(w http.ResponseWriter, _ *http.Request) {
+	writeFakeAPIResponse(
+		w,
+		http.StatusOK,
+		sdk.PrincipalReference{
+			Type: sdk.PrincipalTypeServiceAccount,
+			ID:   "brigade-metrics",
+		},
+	)
+}
+
+func (f *fakeAPI) listProjects(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	list := sdk.ProjectList{}
+	start, end := fakeAPIPage(r, len(f.fixtures.Projects), &list.ListMeta)
+	list.Items = f.fixtures.Projects[start:end]
+	writeFakeAPIResponse(w, http.StatusOK, list)
+}
+
+func (f *fakeAPI) listUsers(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	list := sdk.UserList{}
+	start, end := fakeAPIPage(r, len(f.fixtures.Users), &list.ListMeta)
+	list.Items = f.fixtures.Users[start:end]
+	writeFakeAPIResponse(w, http.StatusOK, list)
+}
+
+func (f *fakeAPI) listServiceAccounts(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	list := sdk.ServiceAccountList{}
+	start, end := fakeAPIPage(
+		r,
+		len(f.fixtures.ServiceAccounts),
+		&list.ListMeta,
+	)
+	list.Items = f.fixtures.ServiceAccounts[start:end]
+	writeFakeAPIResponse(w, http.StatusOK, list)
+}
+
+func (f *fakeAPI) listEvents(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	query := r.URL.Query()
+	selector := &sdk.EventsSelector{
+		ProjectID: query.Get("projectID"),
+		Source:    query.Get("source"),
+		Type:      query.Get("type"),
+	}
+	if workerPhases := query.Get("workerPhases"); workerPhases != "" {
+		for _, phase := range strings.Split(workerPhases, ",") {
+			selector.WorkerPhases = append(
+				selector.WorkerPhases,
+				sdk.WorkerPhase(phase),
+			)
+		}
+	}
+	events := []sdk.Event{}
+	for _, event := range f.fixtures.Events {
+		if eventMatches(selector, event) {
+			events = append(events, event)
+		}
+	}
+	list := sdk.EventList{}
+	start, end := fakeAPIPage(r, len(events), &list.ListMeta)
+	list.Items = events[start:end]
+	writeFakeAPIResponse(w, http.StatusOK, list)
+}
+
+func (f *fakeAPI) getEvent(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	id := mux.Vars(r)["id"]
+	for _, event := range f.fixtures.Events {
+		if event.ID == id {
+			writeFakeAPIResponse(w, http.StatusOK, event)
+			return
+		}
+	}
+	writeFakeAPIResponse(
+		w,
+		http.StatusNotFound,
+		&meta.ErrNotFound{Type: "Event", ID: id},
+	)
+}
+
+func (f *fakeAPI) countRunningWorkers(w http.ResponseWriter, _ *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	writeFakeAPIResponse(
+		w,
+		http.StatusOK,
+		sdk.SubstrateWorkerCount{Count: f.fixtures.RunningWorkers},
+	)
+}
+
+func (f *fakeAPI) countRunningJobs(w http.ResponseWriter, _ *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	writeFakeAPIResponse(
+		w,
+		http.StatusOK,
+		sdk.SubstrateJobCount{Count: f.fixtures.RunningJobs},
+	)
+}
+
+// fakeAPIPage determines which of the specified number of items belong on the
+// page requested by the provided request, populates the provided list
+// metadata accordingly, and returns the bounds of the page.
+func fakeAPIPage(
+	r *http.Request,
+	total int,
+	listMeta *meta.ListMeta,
+) (int, int) {
+	limit := defaultFakeAPIPageSize
+	if l, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && l > 0 {
+		limit = l
+	}
+	start, _ := strconv.Atoi(r.URL.Query().Get("continue"))
+	if start > total {
+		start = total
+	}
+	end := start + limit
+	if end > total {
+		end = total
+	}
+	if end < total {
+		listMeta.Continue = strconv.Itoa(end)
+		listMeta.RemainingItemCount = int64(total - end)
+	}
+	return start, end
+}
+
+func writeFakeAPIResponse(
+	w http.ResponseWriter,
+	statusCode int,
+	obj interface{},
+) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	_ = json.NewEncoder(w).Encode(obj)
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// aggregation is a function for combining the values of two gauges that have
+// been folded into a single series.
+type aggregation func(a, b float64) float64
+
+// aggregateSum is an aggregation suitable for gauges that count things.
+func aggregateSum(a, b float64) float64 {
+	return a + b
+}
+
+// aggregateMax is an aggregation suitable for gauges that report the extreme
+// of something, e.g. the age of the oldest of something.
+func aggregateMax(a, b float64) float64 {
+	return math.Max(a, b)
+}
+
+// labelLimiter wraps a metric vector and caps the number of distinct values
+// reported for each of the specified labels. Once a label's limit is reached,
+// series with values not already being reported are folded into a single
+// series with the value "other". Gauges and counters are combined using the
+// specified aggregation, while histograms are merged.
+//
+// A label value that is being reported continues to be reported for as long
+// as it is present, so that limited series don't flap between their own value
+// and "other" from one collection to the next. Values that are no longer
+// present free up room for others.
+type labelLimiter struct {
+	collector prometheus.Collector
+	// labelNames are the variable labels of the wrapped metric vector, in the
+	// order in which they were declared.
+	labelNames []string
+	// limitedLabels are the subset of labelNames whose values are limited.
+	limitedLabels []string
+	limit         int
+	aggregate     aggregation
+	// overflows counts how many times a label value has begun being folded
+	// into otherLabelValue.
+	overflows prometheus.Counter
+	// admitted tracks which values of each limited label are being reported.
+	admitted map[string]map[string]struct{}
+	// folded tracks which values of each limited label are being folded into
+	// otherLabelValue so that each is counted as an overflow only once, rather
+	// than once per collection, for as long as it remains folded.
+	folded map[string]map[string]struct{}
+	mu     sync.Mutex
+}
+
+// Describe implements prometheus.Collector.
+func (l *labelLimiter) Describe(ch chan<- *prometheus.Desc) {
+	l.collector.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (l *labelLimiter) Collect(ch chan<- prometheus.Metric) {
+	metrics, dtoMetrics := l.gather()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.admit(dtoMetrics) {
+		// Nothing exceeds the limit; pass everything through untouched
+		for _, metric := range metrics {
+			ch <- metric
+		}
+		return
+	}
+	type series struct {
+		desc        *prometheus.Desc
+		labelValues []string
+		metric      *dto.Metric
+	}
+	folded := map[string]*series{}
+	for i, dtoMetric := range dtoMetrics {
+		if dtoMetric == nil {
+			// This couldn't be read, so just pass it along
+			ch <- metrics[i]
+			continue
+		}
+		labelValues := l.labelValues(dtoMetric)
+		key := strings.Join(labelValues, "\xff")
+		s, ok := folded[key]
+		if !ok {
+			folded[key] = &series{
+				desc:        metrics[i].Desc(),
+				labelValues: labelValues,
+				metric:      dtoMetric,
+			}
+			continue
+		}
+		s.metric = l.merge(s.metric, dtoMetric)
+	}
+	for _, s := range folded {
+		metric, err := constMetric(s.desc, s.metric, s.labelValues)
+		if err != nil {
+			ch <- prometheus.NewInvalidMetric(s.desc, err)
+			continue
+		}
+		ch <- metric
+	}
+}
+
+// gather collects every series from the wrapped metric vector along with its
+// protobuf representation. The protobuf representation of any series that
+// cannot be read is nil.
+func (l *labelLimiter) gather() ([]prometheus.Metric, []*dto.Metric) {
+	ch := make(chan prometheus.Metric)
+	go func() {
+		defer close(ch)
+		l.collector.Collect(ch)
+	}()
+	metrics := []prometheus.Metric{}
+	dtoMetrics := []*dto.Metric{}
+	for metric := range ch {
+		dtoMetric := &dto.Metric{}
+		if err := metric.Write(dtoMetric); err != nil {
+			dtoMetric = nil
+		}
+		metrics = append(metrics, metric)
+		dtoMetrics = append(dtoMetrics, dtoMetric)
+	}
+	return metrics, dtoMetrics
+}
+
+// admit updates the set of admitted values for each limited label based on
+// the values present in the provided series and returns true if any present
+// value could not be admitted. Each value that could not be admitted, and was
+// not already being folded into otherLabelValue, is counted as an overflow.
+func (l *labelLimiter) admit(dtoMetrics []*dto.Metric) bool {
+	var overflowed bool
+	for _, labelName := range l.limitedLabels {
+		present := map[string]struct{}{}
+		for _, dtoMetric := range dtoMetrics {
+			if dtoMetric == nil {
+				continue
+			}
+			for _, label := range dtoMetric.Label {
+				if label.GetName() == labelName {
+					present[label.GetValue()] = struct{}{}
+				}
+			}
+		}
+		admitted := map[string]struct{}{}
+		// Values already admitted keep their place for as long as they're present
+		for value := range l.admitted[labelName] {
+			if _, ok := present[value]; ok {
+				admitted[value] = struct{}{}
+			}
+		}
+		// Remaining room is filled in a deterministic order
+		candidates := []string{}
+		for value := range present {
+			if _, ok := admitted[value]; !ok {
+				candidates = append(candidates, value)
+			}
+		}
+		sort.Strings(candidates)
+		folded := map[string]struct{}{}
+		for _, value := range candidates {
+			if len(admitted) < l.limit {
+				admitted[value] = struct{}{}
+				continue
+			}
+			folded[value] = struct{}{}
+			if _, ok := l.folded[labelName][value]; !ok {
+				l.overflows.Inc()
+			}
+		}
+		l.admitted[labelName] = admitted
+		l.folded[labelName] = folded
+		overflowed = overflowed || len(folded) > 0
+	}
+	return overflowed
+}
+
+// labelValues returns the values of the provided series' labels in the order
+// in which they were declared, with the values of limited labels that were not
+// admitted replaced by otherLabelValue.
+func (l *labelLimiter) labelValues(dtoMetric *dto.Metric) []string {
+	values := map[string]string{}
+	for _, label := range dtoMetric.Label {
+		values[label.GetName()] = label.GetValue()
+	}
+	for _, labelName := range l.limitedLabels {
+		if _, ok := l.admitted[labelName][values[labelName]]; !ok {
+			values[labelName] = otherLabelValue
+		}
+	}
+	labelValues := make([]string, len(l.labelNames))
+	for i, labelName := range l.labelNames {
+		labelValues[i] = values[labelName]
+	}
+	return labelValues
+}
+
+// merge combines two series of the same metric.
+func (l *labelLimiter) merge(a, b *dto.Metric) *dto.Metric {
+	merged := &dto.Metric{}
+	switch {
+	case a.Gauge != nil && b.Gauge != nil:
+		value := l.aggregate(a.Gauge.GetValue(), b.Gauge.GetValue())
+		merged.Gauge = &dto.Gauge{Value: &value}
+	case a.Counter != nil && b.Counter != nil:
+		value := a.Counter.GetValue() + b.Counter.GetValue()
+		merged.Counter = &dto.Counter{Value: &value}
+	case a.Histogram != nil && b.Histogram != nil:
+		count := a.Histogram.GetSampleCount() + b.Histogram.GetSampleCount()
+		sampleSum := a.Histogram.GetSampleSum() + b.Histogram.GetSampleSum()
+		merged.Histogram = &dto.Histogram{
+			SampleCount: &count,
+			SampleSum:   &sampleSum,
+		}
+		cumulativeCounts := map[float64]uint64{}
+		for _, bucket := range a.Histogram.Bucket {
+			cumulativeCounts[bucket.GetUpperBound()] += bucket.GetCumulativeCount()
+		}
+		for _, bucket := range b.Histogram.Bucket {
+			cumulativeCounts[bucket.GetUpperBound()] += bucket.GetCumulativeCount()
+		}
+		for upperBound, cumulativeCount := range cumulativeCounts {
+			upperBound, cumulativeCount := upperBound, cumulativeCount
+			merged.Histogram.Bucket = append(
+				merged.Histogram.Bucket,
+				&dto.Bucket{
+					UpperBound:      &upperBound,
+					CumulativeCount: &cumulativeCount,
+				},
+			)
+		}
+	default:
+		// Series of the same metric are always of the same type, so this
+		// shouldn't happen. If it somehow does, keep the first.
+		return a
+	}
+	return merged
+}
+
+// constMetric converts the protobuf representation of a series back into a
+// prometheus.Metric having the specified label values.
+func constMetric(
+	desc *prometheus.Desc,
+	dtoMetric *dto.Metric,
+	labelValues []string,
+) (prometheus.Metric, error) {
+	switch {
+	case dtoMetric.Gauge != nil:
+		return prometheus.NewConstMetric(
+			desc,
+			prometheus.GaugeValue,
+			dtoMetric.Gauge.GetValue(),
+			labelValues...,
+		)
+	case dtoMetric.Counter != nil:
+		return prometheus.NewConstMetric(
+			desc,
+			prometheus.CounterValue,
+			dtoMetric.Counter.GetValue(),
+			labelValues...,
+		)
+	case dtoMetric.Histogram != nil:
+		buckets := map[float64]uint64{}
+		for _, bucket := range dtoMetric.Histogram.Bucket {
+			buckets[bucket.GetUpperBound()] = bucket.GetCumulativeCount()
+		}
+		return prometheus.NewConstHistogram(
+			desc,
+			dtoMetric.Histogram.GetSampleCount(),
+			dtoMetric.Histogram.GetSampleSum(),
+			buckets,
+			labelValues...,
+		)
+	default:
+		return prometheus.NewConstMetric(
+			desc,
+			prometheus.UntypedValue,
+			dtoMetric.Untyped.GetValue(),
+			labelValues...,
+		)
+	}
+}
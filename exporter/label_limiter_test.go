@@ -0,0 +1,200 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestLabelLimiter returns a labelLimiter wrapping the provided metric
+// vector, which must have a single label named project, along with the
+// counter it uses to count overflows.
+func newTestLabelLimiter(
+	collector prometheus.Collector,
+	limit int,
+	aggregate aggregation,
+) (*labelLimiter, prometheus.Counter) {
+	overflows := prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "overflows",
+		},
+	)
+	return &labelLimiter{
+		collector:     collector,
+		labelNames:    []string{"project"},
+		limitedLabels: []string{"project"},
+		limit:         limit,
+		aggregate:     aggregate,
+		overflows:     overflows,
+		admitted:      map[string]map[string]struct{}{},
+		folded:        map[string]map[string]struct{}{},
+	}, overflows
+}
+
+// collectSeries collects every series from the provided collector and returns
+// them keyed by the value of their project label.
+func collectSeries(
+	t *testing.T,
+	collector prometheus.Collector,
+) map[string]*dto.Metric {
+	ch := make(chan prometheus.Metric)
+	go func() {
+		defer close(ch)
+		collector.Collect(ch)
+	}()
+	series := map[string]*dto.Metric{}
+	for metric := range ch {
+		dtoMetric := &dto.Metric{}
+		require.NoError(t, metric.Write(dtoMetric))
+		for _, label := range dtoMetric.Label {
+			if label.GetName() == "project" {
+				series[label.GetValue()] = dtoMetric
+			}
+		}
+	}
+	return series
+}
+
+func TestLabelLimiterUnderLimit(t *testing.T) {
+	gauge := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "gauge",
+		},
+		[]string{"project"},
+	)
+	gauge.WithLabelValues("italian").Set(1)
+	gauge.WithLabelValues("tunisian").Set(2)
+	limiter, overflows := newTestLabelLimiter(gauge, 2, aggregateSum)
+	series := collectSeries(t, limiter)
+	require.Len(t, series, 2)
+	require.Equal(t, 1.0, series["italian"].GetGauge().GetValue())
+	require.Equal(t, 2.0, series["tunisian"].GetGauge().GetValue())
+	require.Equal(t, 0.0, testutil.ToFloat64(overflows))
+}
+
+func TestLabelLimiterGauge(t *testing.T) {
+	testCases := []struct {
+		name      string
+		aggregate aggregation
+		expected  float64
+	}{
+		{
+			name:      "sum",
+			aggregate: aggregateSum,
+			expected:  5,
+		},
+		{
+			name:      "max",
+			aggregate: aggregateMax,
+			expected:  3,
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			gauge := prometheus.NewGaugeVec(
+				prometheus.GaugeOpts{
+					Name: "gauge",
+				},
+				[]string{"project"},
+			)
+			gauge.WithLabelValues("italian").Set(1)
+			gauge.WithLabelValues("tunisian").Set(2)
+			gauge.WithLabelValues("vietnamese").Set(3)
+			limiter, overflows := newTestLabelLimiter(gauge, 1, testCase.aggregate)
+			series := collectSeries(t, limiter)
+			// Values are admitted in lexical order
+			require.Len(t, series, 2)
+			require.Equal(t, 1.0, series["italian"].GetGauge().GetValue())
+			require.Equal(
+				t,
+				testCase.expected,
+				series[otherLabelValue].GetGauge().GetValue(),
+			)
+			require.Equal(t, 2.0, testutil.ToFloat64(overflows))
+		})
+	}
+}
+
+func TestLabelLimiterHistogram(t *testing.T) {
+	histogram := prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "histogram",
+			Buckets: []float64{1, 10},
+		},
+		[]string{"project"},
+	)
+	histogram.WithLabelValues("italian").Observe(5)
+	histogram.WithLabelValues("tunisian").Observe(0.5)
+	histogram.WithLabelValues("vietnamese").Observe(5)
+	limiter, overflows := newTestLabelLimiter(histogram, 1, nil)
+	series := collectSeries(t, limiter)
+	require.Len(t, series, 2)
+	other := series[otherLabelValue].GetHistogram()
+	require.Equal(t, uint64(2), other.GetSampleCount())
+	require.Equal(t, 5.5, other.GetSampleSum())
+	buckets := map[float64]uint64{}
+	for _, bucket := range other.Bucket {
+		buckets[bucket.GetUpperBound()] = bucket.GetCumulativeCount()
+	}
+	require.Equal(t, map[float64]uint64{1: 1, 10: 2}, buckets)
+	require.Equal(t, 2.0, testutil.ToFloat64(overflows))
+}
+
+func TestLabelLimiterAdmission(t *testing.T) {
+	gauge := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "gauge",
+		},
+		[]string{"project"},
+	)
+	gauge.WithLabelValues("tunisian").Set(1)
+	limiter, _ := newTestLabelLimiter(gauge, 1, aggregateSum)
+	series := collectSeries(t, limiter)
+	require.Contains(t, series, "tunisian")
+
+	// A value that sorts earlier must not displace one already admitted
+	gauge.WithLabelValues("italian").Set(1)
+	series = collectSeries(t, limiter)
+	require.Contains(t, series, "tunisian")
+	require.Contains(t, series, otherLabelValue)
+	require.NotContains(t, series, "italian")
+
+	// Once an admitted value is gone, it frees up room for another
+	gauge.DeleteLabelValues("tunisian")
+	series = collectSeries(t, limiter)
+	require.Len(t, series, 1)
+	require.Contains(t, series, "italian")
+}
+
+func TestLabelLimiterOverflows(t *testing.T) {
+	gauge := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "gauge",
+		},
+		[]string{"project"},
+	)
+	gauge.WithLabelValues("italian").Set(1)
+	gauge.WithLabelValues("tunisian").Set(1)
+	gauge.WithLabelValues("vietnamese").Set(1)
+	limiter, overflows := newTestLabelLimiter(gauge, 1, aggregateSum)
+	collectSeries(t, limiter)
+	require.Equal(t, 2.0, testutil.ToFloat64(overflows))
+
+	// Values that remain folded are not counted again
+	collectSeries(t, limiter)
+	require.Equal(t, 2.0, testutil.ToFloat64(overflows))
+
+	// Only the newly folded value is counted
+	gauge.WithLabelValues("zambian").Set(1)
+	collectSeries(t, limiter)
+	require.Equal(t, 3.0, testutil.ToFloat64(overflows))
+
+	// Admitting a previously folded value doesn't count anything
+	gauge.DeleteLabelValues("italian")
+	series := collectSeries(t, limiter)
+	require.Contains(t, series, "tunisian")
+	require.Equal(t, 3.0, testutil.ToFloat64(overflows))
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// logLevel represents the severity of a log message.
+type logLevel int
+
+const (
+	// logLevelDebug is the logLevel of messages useful only when diagnosing a
+	// problem, such as the outcome of every run of every collector.
+	logLevelDebug logLevel = iota
+	// logLevelInfo is the logLevel of messages describing normal operation.
+	logLevelInfo
+	// logLevelWarn is the logLevel of messages describing problems the
+	// exporter works around.
+	logLevelWarn
+	// logLevelError is the logLevel of messages describing failures.
+	logLevelError
+)
+
+// String returns the name of the logLevel.
+func (l logLevel) String() string {
+	switch l {
+	case logLevelDebug:
+		return "debug"
+	case logLevelInfo:
+		return "info"
+	case logLevelWarn:
+		return "warn"
+	default:
+		return "error"
+	}
+}
+
+// parseLogLevel returns the logLevel having the specified name.
+func parseLogLevel(name string) (logLevel, bool) {
+	for _, level := range []logLevel{
+		logLevelDebug,
+		logLevelInfo,
+		logLevelWarn,
+		logLevelError,
+	} {
+		if level.String() == name {
+			return level, true
+		}
+	}
+	return logLevelInfo, false
+}
+
+// logFormat represents an encoding of log messages.
+type logFormat string
+
+const (
+	// logFormatLogfmt is a logFormat wherein each message is a line of
+	// space-delimited key=value pairs.
+	logFormatLogfmt logFormat = "logfmt"
+	// logFormatJSON is a logFormat wherein each message is a line containing a
+	// JSON object.
+	logFormatJSON logFormat = "json"
+)
+
+// logConfig represents configuration for logging.
+type logConfig struct {
+	// Level specifies the least severe messages that are logged.
+	Level logLevel
+	// Format specifies how messages are encoded.
+	Format logFormat
+	// ErrorRepeatInterval specifies how often a failure that keeps recurring,
+	// such as a collector failing every time it runs, is logged. Failures are
+	// always logged when an operation begins failing, and recoveries are always
+	// logged when it stops. A value of zero means every failure is logged.
+	ErrorRepeatInterval time.Duration
+}
+
+// logger writes structured, levelled log messages. Each message has a time, a
+// level, and a message, followed by any number of key/value pairs. The
+// exporter's needs are modest, and this module targets Go 1.18, which predates
+// log/slog, so a small logger of its own is preferred to another dependency.
+type logger struct {
+	out    io.Writer
+	config logConfig
+	// now returns the current time. It is overridden by tests.
+	now func() time.Time
+	mu  sync.Mutex
+}
+
+// appLogger is the exporter's logger. It logs informational messages and above
+// in logfmt format to stderr until configured otherwise.
+var appLogger = &logger{
+	out: os.Stderr,
+	config: logConfig{
+		Level:  logLevelInfo,
+		Format: logFormatLogfmt,
+	},
+	now: time.Now,
+}
+
+// configure replaces the logger's configuration. Messages written by the
+// standard library's logger, including those written by dependencies, are
+// redirected to the logger as well.
+func (l *logger) configure(config logConfig) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.config = config
+	log.SetFlags(0)
+	log.SetOutput(stdLogWriter{logger: l})
+}
+
+func (l *logger) debug(msg string, keyvals ...interface{}) {
+	l.log(logLevelDebug, msg, keyvals...)
+}
+
+func (l *logger) info(msg string, keyvals ...interface{}) {
+	l.log(logLevelInfo, msg, keyvals...)
+}
+
+func (l *logger) warn(msg string, keyvals ...interface{}) {
+	l.log(logLevelWarn, msg, keyvals...)
+}
+
+func (l *logger) error(msg string, keyvals ...interface{}) {
+	l.log(logLevelError, msg, keyvals...)
+}
+
+// fatal logs a message at logLevelError and then exits.
+func (l *logger) fatal(msg string, keyvals ...interface{}) {
+	l.log(logLevelError, msg, keyvals...)
+	os.Exit(1)
+}
+
+// log writes a message having the specified level, if that level is enabled,
+// along with the provided key/value pairs.
+func (l *logger) log(level logLevel, msg string, keyvals ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if level < l.config.Level {
+		return
+	}
+	if len(keyvals)%2 != 0 {
+		keyvals = append(keyvals, "")
+	}
+	keyvals = append(
+		[]interface{}{
+			"time", l.now().UTC().Format(time.RFC3339Nano),
+			"level", level.String(),
+			"msg", msg,
+		},
+		keyvals...,
+	)
+	var line []byte
+	if l.config.Format == logFormatJSON {
+		line = encodeJSONLogLine(keyvals)
+	} else {
+		line = encodeLogfmtLine(keyvals)
+	}
+	_, _ = l.out.Write(line)
+}
+
+// encodeLogfmtLine encodes the provided key/value pairs as a line of logfmt.
+func encodeLogfmtLine(keyvals []interface{}) []byte {
+	buf := &bytes.Buffer{}
+	for i := 0; i < len(keyvals); i += 2 {
+		if i > 0 {
+			buf.WriteByte(' ')
+		}
+		buf.WriteString(fmt.Sprint(keyvals[i]))
+		buf.WriteByte('=')
+		value := logValueString(keyvals[i+1])
+		if value == "" || strings.ContainsAny(value, " =\"\\\t\r\n") {
+			value = strconv.Quote(value)
+		}
+		buf.WriteString(value)
+	}
+	buf.WriteByte('\n')
+	return buf.Bytes()
+}
+
+// encodeJSONLogLine encodes the provided key/value pairs as a line containing
+// a JSON object. Keys appear in the order given.
+func encodeJSONLogLine(keyvals []interface{}) []byte {
+	buf := &bytes.Buffer{}
+	buf.WriteByte('{')
+	for i := 0; i < len(keyvals); i += 2 {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, _ := json.Marshal(fmt.Sprint(keyvals[i]))
+		buf.Write(key)
+		buf.WriteByte(':')
+		var value interface{}
+		switch v := keyvals[i+1].(type) {
+		case bool, int, int64, float64:
+			value = v
+		default:
+			value = logValueString(v)
+		}
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			encoded, _ = json.Marshal(err.Error())
+		}
+		buf.Write(encoded)
+	}
+	buf.WriteString("}\n")
+	return buf.Bytes()
+}
+
+// logValueString returns the string representation of a logged value.
+func logValueString(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case error:
+		return v.Error()
+	case fmt.Stringer:
+		return v.String()
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// stdLogWriter is an io.Writer that relays messages written by the standard
+// library's logger to a logger at logLevelInfo.
+type stdLogWriter struct {
+	logger *logger
+}
+
+// Write implements io.Writer.
+func (s stdLogWriter) Write(p []byte) (int, error) {
+	s.logger.info(strings.TrimSpace(string(p)))
+	return len(p), nil
+}
+
+// failureTracker tracks the outcomes of a recurring operation, such as a run
+// of a collector or a push of metrics, so that a failure that keeps recurring
+// is not logged every time it recurs. It is not safe for concurrent use.
+type failureTracker struct {
+	// failingSince is when the operation began failing. It is the zero value
+	// if the operation's most recent outcome was a success.
+	failingSince time.Time
+	// lastLogged is when a failure of the operation was last logged.
+	lastLogged time.Time
+	// suppressed is the number of failures since lastLogged that were not
+	// logged.
+	suppressed int
+}
+
+// logOutcome logs the outcome of a recurring operation, described by subject,
+// tracked by the provided failureTracker. The provided error is nil if the
+// operation succeeded. A failure is logged as an error when the operation
+// begins failing and then only once every ErrorRepeatInterval for as long as
+// it keeps failing. The failures in between are logged only at logLevelDebug.
+// A recovery is logged when the operation stops failing. The provided
+// key/value pairs are logged with every message.
+func (l *logger) logOutcome(
+	tracker *failureTracker,
+	subject string,
+	err error,
+	keyvals ...interface{},
+) {
+	l.mu.Lock()
+	now := l.now()
+	interval := l.config.ErrorRepeatInterval
+	l.mu.Unlock()
+	if err == nil {
+		if tracker.failingSince.IsZero() {
+			l.debug(subject+" succeeded", keyvals...)
+			return
+		}
+		l.info(
+			subject+" recovered",
+			append(
+				keyvals,
+				"failingFor", now.Sub(tracker.failingSince),
+				"suppressed", tracker.suppressed,
+			)...,
+		)
+		*tracker = failureTracker{}
+		return
+	}
+	keyvals = append(keyvals, "error", err)
+	if tracker.failingSince.IsZero() {
+		tracker.failingSince = now
+		tracker.lastLogged = now
+		l.error(subject+" failed", keyvals...)
+		return
+	}
+	if now.Sub(tracker.lastLogged) < interval {
+		tracker.suppressed++
+		l.debug(subject+" failed", keyvals...)
+		return
+	}
+	l.error(
+		subject+" still failing",
+		append(
+			keyvals,
+			"failingFor", now.Sub(tracker.failingSince),
+			"suppressed", tracker.suppressed,
+		)...,
+	)
+	tracker.lastLogged = now
+	tracker.suppressed = 0
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// newTestLogger returns a logger that writes to the returned buffer and
+// believes the current time is whatever the returned pointer points to.
+func newTestLogger(config logConfig) (*logger, *bytes.Buffer, *time.Time) {
+	buf := &bytes.Buffer{}
+	now := time.Date(2022, time.June, 1, 12, 0, 0, 0, time.UTC)
+	return &logger{
+		out:    buf,
+		config: config,
+		now:    func() time.Time { return now },
+	}, buf, &now
+}
+
+func TestLoggerLog(t *testing.T) {
+	testCases := []struct {
+		name     string
+		config   logConfig
+		logFn    func(*logger)
+		expected string
+	}{
+		{
+			name:   "logfmt",
+			config: logConfig{Level: logLevelInfo, Format: logFormatLogfmt},
+			logFn: func(l *logger) {
+				l.error(
+					"collector failed",
+					"collector", "projects",
+					"duration", 1500*time.Millisecond,
+					"error", errors.New(`unexpected "status"`),
+					"attempts", 3,
+					"empty", "",
+				)
+			},
+			expected: `time=2022-06-01T12:00:00Z level=error ` +
+				`msg="collector failed" collector=projects duration=1.5s ` +
+				`error="unexpected \"status\"" attempts=3 empty=""` + "\n",
+		},
+		{
+			name:   "json",
+			config: logConfig{Level: logLevelInfo, Format: logFormatJSON},
+			logFn: func(l *logger) {
+				l.warn(
+					"collector failed",
+					"collector", "projects",
+					"duration", 1500*time.Millisecond,
+					"attempts", 3,
+					"tls", true,
+				)
+			},
+			expected: `{"time":"2022-06-01T12:00:00Z","level":"warn",` +
+				`"msg":"collector failed","collector":"projects",` +
+				`"duration":"1.5s","attempts":3,"tls":true}` + "\n",
+		},
+		{
+			name:   "odd number of key/value pairs",
+			config: logConfig{Level: logLevelInfo, Format: logFormatLogfmt},
+			logFn: func(l *logger) {
+				l.info("foo", "bar")
+			},
+			expected: `time=2022-06-01T12:00:00Z level=info msg=foo bar=""` + "\n",
+		},
+		{
+			name:   "messages below the configured level are not logged",
+			config: logConfig{Level: logLevelWarn, Format: logFormatLogfmt},
+			logFn: func(l *logger) {
+				l.debug("foo")
+				l.info("bar")
+				l.warn("baz")
+			},
+			expected: "time=2022-06-01T12:00:00Z level=warn msg=baz\n",
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			l, buf, _ := newTestLogger(testCase.config)
+			testCase.logFn(l)
+			require.Equal(t, testCase.expected, buf.String())
+		})
+	}
+}
+
+func TestLoggerLogOutcome(t *testing.T) {
+	l, buf, now := newTestLogger(
+		logConfig{
+			Level:               logLevelInfo,
+			Format:              logFormatLogfmt,
+			ErrorRepeatInterval: time.Minute,
+		},
+	)
+	tracker := &failureTracker{}
+	// logOutcome logs the outcome after the specified amount of time has elapsed
+	// and returns whatever was logged as a result
+	logOutcome := func(elapsed time.Duration, err error) string {
+		*now = now.Add(elapsed)
+		buf.Reset()
+		l.logOutcome(tracker, "collector", err, "collector", "projects")
+		return buf.String()
+	}
+	failure := errors.New("something went wrong")
+	// Success is logged only at debug level
+	require.Empty(t, logOutcome(0, nil))
+	// The first failure is logged
+	require.Contains(
+		t,
+		logOutcome(time.Second, failure),
+		`msg="collector failed"`,
+	)
+	// Subsequent failures are not logged until the interval has elapsed
+	require.Empty(t, logOutcome(20*time.Second, failure))
+	require.Empty(t, logOutcome(20*time.Second, failure))
+	line := logOutcome(20*time.Second, failure)
+	require.Contains(t, line, `msg="collector still failing"`)
+	require.Contains(t, line, "failingFor=1m0s")
+	require.Contains(t, line, "suppressed=2")
+	require.Contains(t, line, `error="something went wrong"`)
+	require.Empty(t, logOutcome(20*time.Second, failure))
+	// Recovery is logged
+	line = logOutcome(20*time.Second, nil)
+	require.Contains(t, line, `msg="collector recovered"`)
+	require.Contains(t, line, "failingFor=1m40s")
+	require.Contains(t, line, "suppressed=1")
+	require.Empty(t, logOutcome(time.Second, nil))
+	// A new failure is logged right away
+	require.Contains(
+		t,
+		logOutcome(time.Second, failure),
+		`msg="collector failed"`,
+	)
+}
+
+func TestLoggerRelaysStandardLogger(t *testing.T) {
+	l, buf, _ := newTestLogger(logConfig{})
+	l.configure(logConfig{Level: logLevelInfo, Format: logFormatJSON})
+	defer func() {
+		log.SetFlags(log.LstdFlags)
+		log.SetOutput(os.Stderr)
+	}()
+	log.Printf("Server is listening on 0.0.0.0:%d", 8080)
+	require.Equal(
+		t,
+		`{"time":"2022-06-01T12:00:00Z","level":"info",`+
+			`"msg":"Server is listening on 0.0.0.0:8080"}`,
+		strings.TrimSpace(buf.String()),
+	)
+}
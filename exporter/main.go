@@ -1,55 +1,289 @@
 package main
 
 import (
-	"log"
+	"context"
+	"flag"
+	"net"
 	"net/http"
+	"strconv"
 
 	libHTTP "github.com/brigadecore/brigade-foundations/http"
+	"github.com/brigadecore/brigade-foundations/os"
 	"github.com/brigadecore/brigade-foundations/signals"
 	"github.com/brigadecore/brigade-foundations/version"
 	"github.com/brigadecore/brigade/sdk/v3"
 	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 func main() {
-	log.Printf(
-		"Starting Brigade Metrics Exporter -- version %s -- commit %s",
-		version.Version(),
-		version.Commit(),
+	configPath := flag.String(
+		"config",
+		os.GetEnvVar("CONFIG_FILE", ""),
+		"path to a YAML or JSON configuration file; environment variables take "+
+			"precedence over its contents",
 	)
+	collectorFlags := newCollectorFlags(flag.CommandLine)
+	flag.Parse()
 
 	ctx := signals.Context()
 
-	{
-		address, token, opts, err := apiClientConfig()
-		if err != nil {
-			log.Fatal(err)
-		}
-		scrapeInterval, err := scrapeDuration()
+	_, server, err := setup(ctx, *configPath, collectorFlags)
+	if err != nil {
+		appLogger.fatal("error starting exporter", "error", err)
+	}
+
+	appLogger.info(
+		"server stopped",
+		"error", server.ListenAndServe(signals.Context()),
+	)
+}
+
+// setup loads configuration from the specified file (if any), the environment,
+// and the provided collector flags, configures logging, starts a metrics
+// exporter, and returns a handler for the exporter's HTTP endpoints along with
+// a server, not yet started, that hosts it. The exporter stops when the
+// provided context is canceled.
+func setup(
+	ctx context.Context,
+	configPath string,
+	collectorFlags *collectorFlags,
+) (http.Handler, libHTTP.Server, error) {
+	file, err := loadFileConfig(configPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	logCfg, err := loggingConfig(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	appLogger.configure(logCfg)
+	appLogger.info(
+		"Starting Brigade Metrics Exporter",
+		"version", version.Version(),
+		"commit", version.Commit(),
+	)
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	// Metrics produced by the exporter itself are kept apart from those
+	// describing the process so that only the former are pushed to sinks
+	exporterRegistry := prometheus.NewRegistry()
+	exporter, apiAddress, err := newExporter(ctx, file, collectorFlags)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err = exporterRegistry.Register(exporter); err != nil {
+		return nil, nil, err
+	}
+	pushers, err := newPushers(file, exporterRegistry, apiAddress)
+	if err != nil {
+		return nil, nil, err
+	}
+	metricsHandler, err := newMetricsHandler(file, registry, exporterRegistry)
+	if err != nil {
+		return nil, nil, err
+	}
+	if metricsHandler == nil && len(pushers) == 0 {
+		return nil, nil, errors.New(
+			"the /metrics endpoint is disabled and metrics are not pushed " +
+				"anywhere; enable the endpoint or at least one push mode",
+		)
+	}
+	exporter.start(ctx)
+	for _, p := range pushers {
+		go p.run(ctx)
+	}
+
+	router := mux.NewRouter()
+	router.StrictSlash(true)
+	if metricsHandler != nil {
+		router.Handle("/metrics", metricsHandler).Methods(http.MethodGet)
+	}
+	router.HandleFunc("/healthz", libHTTP.Healthz).Methods(http.MethodGet)
+	router.HandleFunc("/readyz", exporter.readyz).Methods(http.MethodGet)
+	server, err := newExporterServer(file, router)
+	if err != nil {
+		return nil, nil, err
+	}
+	return router, server, nil
+}
+
+// newExporter returns a metrics exporter, not yet started, configured by the
+// environment, the specified file, and the provided collector flags, along
+// with the address of the Brigade API server it queries. Preflight checks are
+// run against that API server before the exporter is returned.
+func newExporter(
+	ctx context.Context,
+	file fileConfig,
+	collectorFlags *collectorFlags,
+) (*metricsExporter, string, error) {
+	address, token, opts, err := apiClientConfig(file)
+	if err != nil {
+		return nil, "", err
+	}
+	config, err := exporterConfig(file)
+	if err != nil {
+		return nil, "", err
+	}
+	if config.Collectors, err = collectorFlags.apply(
+		config.Collectors,
+	); err != nil {
+		return nil, "", err
+	}
+	exporter := newMetricsExporter(
+		sdk.NewAPIClient(address, token, &opts),
+		config,
+	)
+	if err = exporter.preflight(ctx); err != nil {
+		return nil, "", errors.Wrapf(
+			err,
+			"preflight check against Brigade API server %s failed",
+			address,
+		)
+	}
+	return exporter, address, nil
+}
+
+// newMetricsHandler returns a handler that serves metrics from the provided
+// gatherers, requiring authentication if configuration from the environment or
+// the specified file calls for it. The provided registry also collects metrics
+// about the handler itself. If the /metrics endpoint is disabled, a nil handler
+// is returned.
+func newMetricsHandler(
+	file fileConfig,
+	registry *prometheus.Registry,
+	gatherers ...prometheus.Gatherer,
+) (http.Handler, error) {
+	enabled, err := metricsEndpointEnabled(file)
+	if err != nil || !enabled {
+		return nil, err
+	}
+	authCfg, err := metricsAuthConfig(file)
+	if err != nil {
+		return nil, err
+	}
+	var handler http.Handler = promhttp.InstrumentMetricHandler(
+		registry,
+		promhttp.HandlerFor(
+			append(prometheus.Gatherers{registry}, gatherers...),
+			promhttp.HandlerOpts{},
+		),
+	)
+	if !authCfg.enabled() {
+		return handler, nil
+	}
+	auth, err := newAuthenticator(authCfg)
+	if err != nil {
+		return nil, err
+	}
+	return auth.wrap(handler), nil
+}
+
+// newExporterServer returns a server, not yet started, that hosts the provided
+// handler as dictated by configuration from the environment or the specified
+// file.
+func newExporterServer(
+	file fileConfig,
+	handler http.Handler,
+) (libHTTP.Server, error) {
+	serverConfig, err := serverConfig(file)
+	if err != nil {
+		return nil, err
+	}
+	clientAuthCfg, err := tlsClientAuthConfig(file)
+	if err != nil {
+		return nil, err
+	}
+	return newServer(handler, serverConfig, clientAuthCfg)
+}
+
+// newPushers returns a pusher for every push mode enabled by configuration from
+// the environment or the specified file. Each pushes metrics from the provided
+// gatherer. The provided Brigade API address helps to identify pushed metrics
+// where a sink supports that.
+func newPushers(
+	file fileConfig,
+	gatherer prometheus.Gatherer,
+	apiAddress string,
+) ([]*pusher, error) {
+	pushers := []*pusher{}
+	otlpCfg, err := otlpSinkConfig(file)
+	if err != nil {
+		return nil, err
+	}
+	if otlpCfg.Enabled {
+		var sink *otlpSink
+		sink, err = newOTLPSink(
+			otlpCfg,
+			map[string]string{
+				"service.name":        "brigade-metrics-exporter",
+				"service.version":     version.Version(),
+				"brigade.api.address": apiAddress,
+			},
+		)
 		if err != nil {
-			log.Fatal(err)
+			return nil, err
 		}
-		newMetricsExporter(
-			sdk.NewAPIClient(address, token, &opts),
-			scrapeInterval,
-		).start(ctx)
-	}
-
-	var server libHTTP.Server
-	{
-		router := mux.NewRouter()
-		router.StrictSlash(true)
-		router.Handle("/metrics", promhttp.Handler()).Methods(http.MethodGet)
-		router.HandleFunc("/healthz", libHTTP.Healthz).Methods(http.MethodGet)
-		serverConfig, err := serverConfig()
+		pushers = append(pushers, &pusher{
+			name:     "OTLP endpoint " + otlpCfg.Endpoint,
+			gatherer: gatherer,
+			sink:     sink,
+			interval: otlpCfg.Interval,
+			timeout:  otlpCfg.Timeout,
+		})
+	}
+	pushgatewayCfg, err := pushgatewaySinkConfig(file)
+	if err != nil {
+		return nil, err
+	}
+	if pushgatewayCfg.Enabled {
+		pushers = append(pushers, &pusher{
+			name:     "Pushgateway " + pushgatewayCfg.URL,
+			gatherer: gatherer,
+			sink:     newPushgatewaySink(pushgatewayCfg),
+			interval: pushgatewayCfg.Interval,
+			timeout:  pushgatewayCfg.Timeout,
+		})
+	}
+	remoteWriteCfg, err := remoteWriteSinkConfig(file)
+	if err != nil {
+		return nil, err
+	}
+	if remoteWriteCfg.Enabled {
+		pushers = append(pushers, &pusher{
+			name:     "remote-write endpoint " + remoteWriteCfg.URL,
+			gatherer: gatherer,
+			sink:     newRemoteWriteSink(remoteWriteCfg),
+			interval: remoteWriteCfg.Interval,
+			timeout:  remoteWriteCfg.Timeout,
+		})
+	}
+	statsdCfg, err := statsdSinkConfig(file)
+	if err != nil {
+		return nil, err
+	}
+	if statsdCfg.Enabled {
+		var sink *statsdSink
+		sink, err = newStatsdSink(statsdCfg)
 		if err != nil {
-			log.Fatal(err)
+			return nil, err
 		}
-		server = libHTTP.NewServer(router, &serverConfig)
+		pushers = append(pushers, &pusher{
+			name: "StatsD server " +
+				net.JoinHostPort(statsdCfg.Host, strconv.Itoa(statsdCfg.Port)),
+			gatherer: gatherer,
+			sink:     sink,
+			interval: statsdCfg.Interval,
+			// No single push should outlast the interval between pushes
+			timeout: statsdCfg.Interval,
+		})
 	}
-
-	log.Println(
-		server.ListenAndServe(signals.Context()),
-	)
+	return pushers, nil
 }
@@ -0,0 +1,769 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/brigadecore/brigade/sdk/v3"
+	"github.com/brigadecore/brigade/sdk/v3/meta"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeAPIFixturesForEndToEnd returns a small but representative set of
+// resources for the fake Brigade API to serve.
+func fakeAPIFixturesForEndToEnd() fakeAPIFixtures {
+	now := time.Now()
+	created := now.Add(-time.Hour)
+	started := now.Add(-50 * time.Minute)
+	ended := now.Add(-40 * time.Minute)
+	newEvent := func(
+		id string,
+		projectID string,
+		phase sdk.WorkerPhase,
+	) sdk.Event {
+		return sdk.Event{
+			ObjectMeta: meta.ObjectMeta{ID: id, Created: &created},
+			ProjectID:  projectID,
+			Source:     "brigade.sh/cli",
+			Type:       "exec",
+			Worker: &sdk.Worker{
+				Status: sdk.WorkerStatus{Phase: phase},
+			},
+		}
+	}
+	pending := newEvent("4", "italian", sdk.WorkerPhasePending)
+	running := newEvent("3", "thai", sdk.WorkerPhaseRunning)
+	running.Worker.Status.Started = &started
+	running.Worker.Jobs = []sdk.Job{
+		{
+			Name:   "build",
+			Status: &sdk.JobStatus{Phase: sdk.JobPhaseRunning},
+		},
+	}
+	succeeded := newEvent("2", "italian", sdk.WorkerPhaseSucceeded)
+	succeeded.Worker.Status.Started = &started
+	succeeded.Worker.Status.Ended = &ended
+	failed := newEvent("1", "thai", sdk.WorkerPhaseFailed)
+	failed.Worker.Status.Started = &started
+	failed.Worker.Status.Ended = &ended
+	return fakeAPIFixtures{
+		Projects: []sdk.Project{
+			{ObjectMeta: meta.ObjectMeta{ID: "italian"}},
+			{ObjectMeta: meta.ObjectMeta{ID: "mexican"}},
+			{ObjectMeta: meta.ObjectMeta{ID: "thai"}},
+		},
+		Users: []sdk.User{
+			{ObjectMeta: meta.ObjectMeta{ID: "tony@starkindustries.com"}},
+			{ObjectMeta: meta.ObjectMeta{ID: "pepper@starkindustries.com"}},
+		},
+		ServiceAccounts: []sdk.ServiceAccount{
+			{ObjectMeta: meta.ObjectMeta{ID: "jarvis"}},
+		},
+		Events:         []sdk.Event{pending, running, succeeded, failed},
+		RunningWorkers: 1,
+		RunningJobs:    1,
+	}
+}
+
+// useFakeAPI configures, via the environment, any exporter subsequently
+// started by startExporter to query the provided fake Brigade API on demand
+// every time it is scraped. Preflight checks are skipped so that tests can
+// observe how the exporter copes with an API server that misbehaves after it
+// has started. Tests of the preflight checks themselves re-enable them.
+func useFakeAPI(t *testing.T, api *fakeAPI) {
+	t.Setenv("API_ADDRESS", api.address())
+	t.Setenv("API_TOKEN", fakeAPIToken)
+	t.Setenv("COLLECTION_MODE", "on-demand")
+	t.Setenv("MIN_REFRESH_INTERVAL", "1ns")
+	t.Setenv("PREFLIGHT_MODE", "off")
+}
+
+// startExporter starts an exporter configured by the environment and returns a
+// handler for its HTTP endpoints. The exporter is stopped when the test
+// completes.
+func startExporter(t *testing.T) http.Handler {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	handler, _, err := setup(
+		ctx,
+		"",
+		newCollectorFlags(flag.NewFlagSet("test", flag.ContinueOnError)),
+	)
+	require.NoError(t, err)
+	return handler
+}
+
+// scrape requests /metrics from the provided handler and returns the body of
+// the response.
+func scrape(t *testing.T, handler http.Handler) string {
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+	return rr.Body.String()
+}
+
+func TestEndToEnd(t *testing.T) {
+	testCases := []struct {
+		name       string
+		setup      func(*testing.T, *fakeAPI)
+		assertions func(*testing.T, *fakeAPI, http.Handler)
+	}{
+		{
+			name:  "metrics reflect the contents of the API server",
+			setup: func(t *testing.T, _ *fakeAPI) {},
+			assertions: func(t *testing.T, api *fakeAPI, handler http.Handler) {
+				metrics := scrape(t, handler)
+				for _, line := range []string{
+					"brigade_projects_total 3",
+					"brigade_users_total 2",
+					"brigade_service_accounts_total 1",
+					`brigade_events_by_worker_phase{workerPhase="PENDING"} 1`,
+					`brigade_events_by_worker_phase{workerPhase="RUNNING"} 1`,
+					`brigade_project_events_by_worker_phase{project="italian",` +
+						`workerPhase="SUCCEEDED"} 1`,
+					`brigade_jobs_by_phase{phase="RUNNING",project="thai"} 1`,
+					`brigade_events_total{project="thai",source="brigade.sh/cli",` +
+						`type="exec"} 2`,
+					"brigade_substrate_running_workers 1",
+					"brigade_substrate_running_jobs 1",
+					"brigade_up 1",
+					// Metrics from the Go runtime are still served
+					"go_goroutines",
+				} {
+					require.Contains(t, metrics, line)
+				}
+			},
+		},
+		{
+			name:  "durations of workers that finish are observed",
+			setup: func(*testing.T, *fakeAPI) {},
+			assertions: func(t *testing.T, api *fakeAPI, handler http.Handler) {
+				// Workers that finished before the exporter started are ignored
+				metrics := scrape(t, handler)
+				require.NotContains(t, metrics, "brigade_worker_duration_seconds_count")
+				// A pending Worker starts and a running Worker finishes
+				api.update(func(fixtures *fakeAPIFixtures) {
+					now := time.Now()
+					fixtures.Events[0].Worker.Status.Phase = sdk.WorkerPhaseRunning
+					fixtures.Events[0].Worker.Status.Started = &now
+					fixtures.Events[1].Worker.Status.Phase = sdk.WorkerPhaseSucceeded
+					fixtures.Events[1].Worker.Status.Ended = &now
+				})
+				metrics = scrape(t, handler)
+				for _, line := range []string{
+					`brigade_event_queue_wait_seconds_count{project="italian"} 1`,
+					`brigade_worker_duration_seconds_count{project="thai",` +
+						`workerPhase="SUCCEEDED"} 1`,
+				} {
+					require.Contains(t, metrics, line)
+				}
+			},
+		},
+		{
+			name: "every page of a list is retrieved",
+			setup: func(t *testing.T, _ *fakeAPI) {
+				t.Setenv("PAGINATION_MODE", "walk")
+				t.Setenv("API_PAGE_SIZE", "1")
+			},
+			assertions: func(t *testing.T, api *fakeAPI, handler http.Handler) {
+				metrics := scrape(t, handler)
+				require.Contains(t, metrics, "brigade_users_total 2")
+				require.Contains(t, metrics, "brigade_up 1")
+				require.Equal(t, 2, api.requestCount("/v2/users"))
+			},
+		},
+		{
+			name: "failing endpoint",
+			setup: func(t *testing.T, api *fakeAPI) {
+				// Don't back off, so the collector is retried by the next scrape
+				t.Setenv("COLLECTOR_BACKOFF_MAX_INTERVAL", "0s")
+				api.fail("/v2/users", http.StatusInternalServerError)
+			},
+			assertions: func(t *testing.T, api *fakeAPI, handler http.Handler) {
+				metrics := scrape(t, handler)
+				require.Contains(
+					t,
+					metrics,
+					`brigade_exporter_scrape_errors_total{class="error",`+
+						`collector="users"} 1`,
+				)
+				require.Contains(t, metrics, "brigade_up 0")
+				// Other collectors are unaffected
+				require.Contains(t, metrics, "brigade_projects_total 3")
+				// The collector recovers once the endpoint does
+				api.fail("/v2/users", 0)
+				metrics = scrape(t, handler)
+				require.Contains(t, metrics, "brigade_users_total 2")
+				require.Contains(t, metrics, "brigade_up 1")
+			},
+		},
+		{
+			name: "failing endpoint backs off",
+			setup: func(t *testing.T, api *fakeAPI) {
+				api.fail("/v2/users", http.StatusInternalServerError)
+			},
+			assertions: func(t *testing.T, api *fakeAPI, handler http.Handler) {
+				metrics := scrape(t, handler)
+				require.Contains(t, metrics, "brigade_up 0")
+				require.NotContains(
+					t,
+					metrics,
+					`brigade_exporter_collector_backoff_seconds{collector="users"} 0`,
+				)
+				require.Contains(
+					t,
+					metrics,
+					`brigade_exporter_collector_backoff_seconds{collector="projects"} 0`,
+				)
+				userRequests := api.requestCount("/v2/users")
+				projectRequests := api.requestCount("/v2/projects")
+				// The failing collector isn't retried by the next scrape, but other
+				// collectors are run as usual
+				api.fail("/v2/users", 0)
+				metrics = scrape(t, handler)
+				require.Equal(t, userRequests, api.requestCount("/v2/users"))
+				require.Greater(t, api.requestCount("/v2/projects"), projectRequests)
+				require.Contains(t, metrics, "brigade_up 0")
+			},
+		},
+		{
+			name: "slow endpoint",
+			setup: func(t *testing.T, api *fakeAPI) {
+				t.Setenv("API_REQUEST_TIMEOUT", "50ms")
+				api.delay("/v2/substrate/running-workers", time.Second)
+			},
+			assertions: func(t *testing.T, api *fakeAPI, handler http.Handler) {
+				metrics := scrape(t, handler)
+				require.Contains(
+					t,
+					metrics,
+					`brigade_exporter_scrape_errors_total{class="timeout",`+
+						`collector="substrate_workers"} 1`,
+				)
+				require.Contains(t, metrics, "brigade_up 0")
+				require.Contains(t, metrics, "brigade_substrate_running_jobs 1")
+			},
+		},
+		{
+			name: "invalid token",
+			setup: func(t *testing.T, _ *fakeAPI) {
+				t.Setenv("API_TOKEN", "bogus")
+			},
+			assertions: func(t *testing.T, api *fakeAPI, handler http.Handler) {
+				metrics := scrape(t, handler)
+				require.Contains(
+					t,
+					metrics,
+					`brigade_exporter_scrape_errors_total{class="error",`+
+						`collector="projects"} 1`,
+				)
+				require.Contains(t, metrics, "brigade_up 0")
+			},
+		},
+		{
+			name: "poll mode runs every collector right away",
+			setup: func(t *testing.T, _ *fakeAPI) {
+				t.Setenv("COLLECTION_MODE", "poll")
+				t.Setenv("PROM_SCRAPE_INTERVAL", "1m")
+			},
+			assertions: func(t *testing.T, api *fakeAPI, handler http.Handler) {
+				// events_by_source runs no more often than every 5m by default, but
+				// that mustn't delay its first run, or brigade_up
+				require.Eventually(
+					t,
+					func() bool {
+						metrics := scrape(t, handler)
+						return strings.Contains(metrics, "brigade_up 1") &&
+							strings.Contains(metrics, "brigade_events_total{")
+					},
+					5*time.Second,
+					10*time.Millisecond,
+				)
+			},
+		},
+		{
+			name: "event index",
+			setup: func(t *testing.T, _ *fakeAPI) {
+				t.Setenv("EVENT_INDEX_ENABLED", "true")
+				t.Setenv("EVENT_INDEX_SYNC_INTERVAL", "1ns")
+			},
+			assertions: func(t *testing.T, api *fakeAPI, handler http.Handler) {
+				metrics := scrape(t, handler)
+				require.Contains(
+					t,
+					metrics,
+					`brigade_events_by_worker_phase{workerPhase="PENDING"} 1`,
+				)
+				// A pending Event starts running and another is created
+				api.update(func(fixtures *fakeAPIFixtures) {
+					now := time.Now()
+					fixtures.Events[0].Worker.Status.Phase = sdk.WorkerPhaseRunning
+					fixtures.Events[0].Worker.Status.Started = &now
+					fixtures.Events = append(
+						[]sdk.Event{
+							{
+								ObjectMeta: meta.ObjectMeta{ID: "5", Created: &now},
+								ProjectID:  "mexican",
+								Worker: &sdk.Worker{
+									Status: sdk.WorkerStatus{
+										Phase: sdk.WorkerPhasePending,
+									},
+								},
+							},
+						},
+						fixtures.Events...,
+					)
+				})
+				metrics = scrape(t, handler)
+				for _, line := range []string{
+					`brigade_events_by_worker_phase{workerPhase="PENDING"} 1`,
+					`brigade_events_by_worker_phase{workerPhase="RUNNING"} 2`,
+					`brigade_project_events_by_worker_phase{project="mexican",` +
+						`workerPhase="PENDING"} 1`,
+				} {
+					require.Contains(t, metrics, line)
+				}
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			api := newFakeAPI(t, fakeAPIFixturesForEndToEnd())
+			useFakeAPI(t, api)
+			testCase.setup(t, api)
+			testCase.assertions(t, api, startExporter(t))
+		})
+	}
+}
+
+func TestSetupPushModes(t *testing.T) {
+	testCases := []struct {
+		name       string
+		setup      func(*testing.T, string)
+		assertions func(*testing.T, http.Handler, <-chan struct{}, error)
+	}{
+		{
+			name:  "metrics served and not pushed by default",
+			setup: func(*testing.T, string) {},
+			assertions: func(
+				t *testing.T,
+				handler http.Handler,
+				_ <-chan struct{},
+				err error,
+			) {
+				require.NoError(t, err)
+				require.Contains(t, scrape(t, handler), "brigade_up")
+			},
+		},
+		{
+			name: "metrics neither served nor pushed",
+			setup: func(t *testing.T, _ string) {
+				t.Setenv("METRICS_ENDPOINT_ENABLED", "false")
+			},
+			assertions: func(
+				t *testing.T,
+				_ http.Handler,
+				_ <-chan struct{},
+				err error,
+			) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "/metrics endpoint is disabled")
+			},
+		},
+		{
+			name: "metrics pushed instead of served",
+			setup: func(t *testing.T, receiverURL string) {
+				t.Setenv("METRICS_ENDPOINT_ENABLED", "false")
+				t.Setenv("REMOTE_WRITE_ENABLED", "true")
+				t.Setenv("REMOTE_WRITE_URL", receiverURL)
+				t.Setenv("REMOTE_WRITE_PUSH_INTERVAL", "10ms")
+			},
+			assertions: func(
+				t *testing.T,
+				handler http.Handler,
+				pushed <-chan struct{},
+				err error,
+			) {
+				require.NoError(t, err)
+				req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+				rr := httptest.NewRecorder()
+				handler.ServeHTTP(rr, req)
+				require.Equal(t, http.StatusNotFound, rr.Code)
+				select {
+				case <-pushed:
+				case <-time.After(5 * time.Second):
+					require.Fail(t, "metrics were never pushed")
+				}
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			api := newFakeAPI(t, fakeAPIFixturesForEndToEnd())
+			useFakeAPI(t, api)
+			pushed := make(chan struct{}, 1)
+			receiver := httptest.NewServer(
+				http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+					select {
+					case pushed <- struct{}{}:
+					default:
+					}
+					w.WriteHeader(http.StatusNoContent)
+				}),
+			)
+			defer receiver.Close()
+			testCase.setup(t, receiver.URL)
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			handler, _, err := setup(
+				ctx,
+				"",
+				newCollectorFlags(flag.NewFlagSet("test", flag.ContinueOnError)),
+			)
+			testCase.assertions(t, handler, pushed, err)
+		})
+	}
+}
+
+func TestMetricsAuthentication(t *testing.T) {
+	// writeSecret writes a secret file and ensures its modification time
+	// changes, even on filesystems with coarse timestamps
+	writeSecret := func(t *testing.T, path string, contents string) {
+		require.NoError(t, os.WriteFile(path, []byte(contents), 0600))
+		modTime := time.Now().Add(time.Duration(len(contents)) * time.Second)
+		require.NoError(t, os.Chtimes(path, modTime, modTime))
+	}
+	request := func(
+		handler http.Handler,
+		path string,
+		authorize func(*http.Request),
+	) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		authorize(req)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+	bearer := func(token string) func(*http.Request) {
+		return func(req *http.Request) {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+	}
+	basic := func(username, password string) func(*http.Request) {
+		return func(req *http.Request) {
+			req.SetBasicAuth(username, password)
+		}
+	}
+	anonymous := func(*http.Request) {}
+	testCases := []struct {
+		name       string
+		setup      func(t *testing.T, dir string)
+		assertions func(t *testing.T, dir string, handler http.Handler)
+	}{
+		{
+			name:  "no authentication configured",
+			setup: func(*testing.T, string) {},
+			assertions: func(t *testing.T, _ string, handler http.Handler) {
+				rr := request(handler, "/metrics", anonymous)
+				require.Equal(t, http.StatusOK, rr.Code)
+			},
+		},
+		{
+			name: "bearer token",
+			setup: func(t *testing.T, dir string) {
+				path := filepath.Join(dir, "tokens")
+				writeSecret(t, path, "foo\n")
+				t.Setenv("METRICS_AUTH_TOKEN_FILE", path)
+			},
+			assertions: func(t *testing.T, dir string, handler http.Handler) {
+				rr := request(handler, "/metrics", anonymous)
+				require.Equal(t, http.StatusUnauthorized, rr.Code)
+				require.Equal(
+					t,
+					`Bearer realm="brigade-metrics"`,
+					rr.Header().Get("WWW-Authenticate"),
+				)
+				rr = request(handler, "/metrics", bearer("bar"))
+				require.Equal(t, http.StatusUnauthorized, rr.Code)
+				rr = request(handler, "/metrics", bearer("foo"))
+				require.Equal(t, http.StatusOK, rr.Code)
+				require.Contains(t, rr.Body.String(), "brigade_up")
+				// /healthz remains open
+				rr = request(handler, "/healthz", anonymous)
+				require.Equal(t, http.StatusOK, rr.Code)
+				// Tokens are rotated without a restart. Both old and new tokens are
+				// accepted while both are listed.
+				writeSecret(t, filepath.Join(dir, "tokens"), "foo\nbar\n")
+				rr = request(handler, "/metrics", bearer("foo"))
+				require.Equal(t, http.StatusOK, rr.Code)
+				rr = request(handler, "/metrics", bearer("bar"))
+				require.Equal(t, http.StatusOK, rr.Code)
+				writeSecret(t, filepath.Join(dir, "tokens"), "bar\n")
+				rr = request(handler, "/metrics", bearer("foo"))
+				require.Equal(t, http.StatusUnauthorized, rr.Code)
+				rr = request(handler, "/metrics", bearer("bar"))
+				require.Equal(t, http.StatusOK, rr.Code)
+			},
+		},
+		{
+			name: "basic auth",
+			setup: func(t *testing.T, dir string) {
+				path := filepath.Join(dir, "password")
+				writeSecret(t, path, "foo")
+				t.Setenv("METRICS_AUTH_USERNAME", "prometheus")
+				t.Setenv("METRICS_AUTH_PASSWORD_FILE", path)
+			},
+			assertions: func(t *testing.T, dir string, handler http.Handler) {
+				rr := request(handler, "/metrics", anonymous)
+				require.Equal(t, http.StatusUnauthorized, rr.Code)
+				require.Equal(
+					t,
+					`Basic realm="brigade-metrics"`,
+					rr.Header().Get("WWW-Authenticate"),
+				)
+				rr = request(handler, "/metrics", basic("grafana", "foo"))
+				require.Equal(t, http.StatusUnauthorized, rr.Code)
+				rr = request(handler, "/metrics", basic("prometheus", "bar"))
+				require.Equal(t, http.StatusUnauthorized, rr.Code)
+				rr = request(handler, "/metrics", basic("prometheus", "foo"))
+				require.Equal(t, http.StatusOK, rr.Code)
+				rr = request(handler, "/healthz", anonymous)
+				require.Equal(t, http.StatusOK, rr.Code)
+				// The password is rotated without a restart
+				writeSecret(t, filepath.Join(dir, "password"), "barbaz")
+				rr = request(handler, "/metrics", basic("prometheus", "foo"))
+				require.Equal(t, http.StatusUnauthorized, rr.Code)
+				rr = request(handler, "/metrics", basic("prometheus", "barbaz"))
+				require.Equal(t, http.StatusOK, rr.Code)
+			},
+		},
+		{
+			name: "bearer token and basic auth",
+			setup: func(t *testing.T, dir string) {
+				tokenPath := filepath.Join(dir, "tokens")
+				writeSecret(t, tokenPath, "foo")
+				passwordPath := filepath.Join(dir, "password")
+				writeSecret(t, passwordPath, "bar")
+				t.Setenv("METRICS_AUTH_TOKEN_FILE", tokenPath)
+				t.Setenv("METRICS_AUTH_USERNAME", "prometheus")
+				t.Setenv("METRICS_AUTH_PASSWORD_FILE", passwordPath)
+			},
+			assertions: func(t *testing.T, _ string, handler http.Handler) {
+				rr := request(handler, "/metrics", anonymous)
+				require.Equal(t, http.StatusUnauthorized, rr.Code)
+				require.Len(t, rr.Header().Values("WWW-Authenticate"), 2)
+				rr = request(handler, "/metrics", bearer("foo"))
+				require.Equal(t, http.StatusOK, rr.Code)
+				rr = request(handler, "/metrics", basic("prometheus", "bar"))
+				require.Equal(t, http.StatusOK, rr.Code)
+			},
+		},
+		{
+			name: "secret file removed",
+			setup: func(t *testing.T, dir string) {
+				path := filepath.Join(dir, "tokens")
+				writeSecret(t, path, "foo")
+				t.Setenv("METRICS_AUTH_TOKEN_FILE", path)
+			},
+			assertions: func(t *testing.T, dir string, handler http.Handler) {
+				require.NoError(t, os.Remove(filepath.Join(dir, "tokens")))
+				rr := request(handler, "/metrics", bearer("foo"))
+				require.Equal(t, http.StatusInternalServerError, rr.Code)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			api := newFakeAPI(t, fakeAPIFixturesForEndToEnd())
+			useFakeAPI(t, api)
+			dir := t.TempDir()
+			testCase.setup(t, dir)
+			testCase.assertions(t, dir, startExporter(t))
+		})
+	}
+}
+
+func TestReadiness(t *testing.T) {
+	// readyz requests /readyz from the provided handler and returns the status
+	// code and decoded body of the response
+	readyz := func(
+		t *testing.T,
+		handler http.Handler,
+	) (int, readinessReport) {
+		req := httptest.NewRequest(http.MethodGet, "/readyz", nil)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		require.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+		report := readinessReport{}
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &report))
+		return rr.Code, report
+	}
+	// failAll causes every endpoint of the provided fake API to fail
+	failAll := func(api *fakeAPI) {
+		for _, path := range []string{
+			"/v2/projects",
+			"/v2/users",
+			"/v2/service-accounts",
+			"/v2/events",
+			"/v2/substrate/running-workers",
+			"/v2/substrate/running-jobs",
+		} {
+			api.fail(path, http.StatusInternalServerError)
+		}
+	}
+	testCases := []struct {
+		name       string
+		setup      func(*testing.T)
+		assertions func(*testing.T, *fakeAPI, http.Handler)
+	}{
+		{
+			name:  "API server reachable",
+			setup: func(*testing.T) {},
+			assertions: func(t *testing.T, _ *fakeAPI, handler http.Handler) {
+				code, report := readyz(t, handler)
+				require.Equal(t, http.StatusOK, code)
+				require.True(t, report.Ready)
+				require.Len(t, report.Collectors, len(collectorNames()))
+				for name, collector := range report.Collectors {
+					require.True(t, collector.Healthy, name)
+					require.NotNil(t, collector.LastSuccess, name)
+				}
+			},
+		},
+		{
+			name: "token revoked",
+			setup: func(t *testing.T) {
+				t.Setenv("API_TOKEN", "revoked")
+			},
+			assertions: func(t *testing.T, _ *fakeAPI, handler http.Handler) {
+				code, report := readyz(t, handler)
+				require.Equal(t, http.StatusServiceUnavailable, code)
+				require.False(t, report.Ready)
+				require.Equal(t, "no collector has succeeded yet", report.Reason)
+				projects := report.Collectors[collectorProjects]
+				require.False(t, projects.Healthy)
+				require.Nil(t, projects.LastSuccess)
+				require.NotNil(t, projects.FailingSince)
+				// The reason collectors are failing is logged, but must not be
+				// disclosed to unauthenticated clients
+				req := httptest.NewRequest(http.MethodGet, "/readyz", nil)
+				rr := httptest.NewRecorder()
+				handler.ServeHTTP(rr, req)
+				require.NotContains(t, rr.Body.String(), "authenticate")
+			},
+		},
+		{
+			name: "API server failing for less than the threshold",
+			setup: func(t *testing.T) {
+				t.Setenv("READINESS_FAILURE_THRESHOLD", "1h")
+			},
+			assertions: func(t *testing.T, api *fakeAPI, handler http.Handler) {
+				code, _ := readyz(t, handler)
+				require.Equal(t, http.StatusOK, code)
+				failAll(api)
+				code, report := readyz(t, handler)
+				require.Equal(t, http.StatusOK, code)
+				require.False(t, report.Collectors[collectorProjects].Healthy)
+			},
+		},
+		{
+			name: "API server failing for longer than the threshold",
+			setup: func(t *testing.T) {
+				t.Setenv("READINESS_FAILURE_THRESHOLD", "0s")
+				// Otherwise, this collector wouldn't run again for several minutes
+				t.Setenv("COLLECTOR_EVENTS_BY_SOURCE_INTERVAL", "1ns")
+			},
+			assertions: func(t *testing.T, api *fakeAPI, handler http.Handler) {
+				code, _ := readyz(t, handler)
+				require.Equal(t, http.StatusOK, code)
+				failAll(api)
+				code, report := readyz(t, handler)
+				require.Equal(t, http.StatusServiceUnavailable, code)
+				require.Contains(t, report.Reason, "every collector has been failing")
+				for name, collector := range report.Collectors {
+					require.False(t, collector.Healthy, name)
+					require.NotNil(t, collector.LastSuccess, name)
+					require.NotNil(t, collector.FailingSince, name)
+				}
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			api := newFakeAPI(t, fakeAPIFixturesForEndToEnd())
+			useFakeAPI(t, api)
+			testCase.setup(t)
+			testCase.assertions(t, api, startExporter(t))
+		})
+	}
+}
+
+func TestSetupPreflight(t *testing.T) {
+	testCases := []struct {
+		name       string
+		setup      func(*testing.T, *fakeAPI)
+		assertions func(*testing.T, *fakeAPI, http.Handler, error)
+	}{
+		{
+			name: "token not accepted",
+			setup: func(t *testing.T, _ *fakeAPI) {
+				t.Setenv("API_TOKEN", "bogus")
+			},
+			assertions: func(
+				t *testing.T,
+				api *fakeAPI,
+				_ http.Handler,
+				err error,
+			) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), api.address())
+				require.Contains(t, err.Error(), "did not accept the API token")
+			},
+		},
+		{
+			name: "unauthorized collectors disabled",
+			setup: func(t *testing.T, api *fakeAPI) {
+				t.Setenv("PREFLIGHT_MODE", "degrade")
+				api.fail("/v2/users", http.StatusForbidden)
+			},
+			assertions: func(
+				t *testing.T,
+				_ *fakeAPI,
+				handler http.Handler,
+				err error,
+			) {
+				require.NoError(t, err)
+				metrics := scrape(t, handler)
+				require.NotContains(t, metrics, "brigade_users_total")
+				require.NotContains(t, metrics, `collector="users"`)
+				require.Contains(t, metrics, "brigade_projects_total 3")
+				require.Contains(t, metrics, "brigade_up 1")
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			api := newFakeAPI(t, fakeAPIFixturesForEndToEnd())
+			useFakeAPI(t, api)
+			t.Setenv("PREFLIGHT_MODE", "fail")
+			testCase.setup(t, api)
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			handler, _, err := setup(
+				ctx,
+				"",
+				newCollectorFlags(flag.NewFlagSet("test", flag.ContinueOnError)),
+			)
+			testCase.assertions(t, api, handler, err)
+		})
+	}
+}
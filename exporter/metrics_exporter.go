@@ -2,204 +2,1347 @@ package main
 
 import (
 	"context"
-	"log"
+	"math/rand"
+	"regexp"
+	"sync"
 	"time"
 
 	"github.com/brigadecore/brigade/sdk/v3"
 	"github.com/brigadecore/brigade/sdk/v3/meta"
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promauto"
 )
 
+// collectionMode represents a strategy for deciding when the exporter queries
+// the Brigade API.
+type collectionMode string
+
+const (
+	// collectionModePoll is a collectionMode wherein the exporter queries the
+	// Brigade API on a fixed interval, regardless of whether metrics are being
+	// scraped.
+	collectionModePoll collectionMode = "poll"
+	// collectionModeOnDemand is a collectionMode wherein the exporter queries
+	// the Brigade API only when metrics are scraped.
+	collectionModeOnDemand collectionMode = "on-demand"
+)
+
+// metricsExporterConfig represents configuration for the metrics exporter.
+type metricsExporterConfig struct {
+	// CollectionMode specifies when the exporter queries the Brigade API.
+	CollectionMode collectionMode
+	// ScrapeInterval specifies how often the Brigade API is queried when
+	// CollectionMode is collectionModePoll.
+	ScrapeInterval time.Duration
+	// APIRequestTimeout specifies the maximum amount of time any single request
+	// to the Brigade API may take. A value of zero means no timeout.
+	APIRequestTimeout time.Duration
+	// PaginationMode specifies how the number of items in paginated lists of
+	// resources is determined.
+	PaginationMode paginationMode
+	// PageSize specifies how many items should be requested per page when
+	// listing resources. A value of zero defers to the API server's default.
+	PageSize int64
+	// MinRefreshInterval specifies the minimum amount of time that must elapse
+	// between successive queries of the Brigade API when CollectionMode is
+	// collectionModeOnDemand. Scrapes that occur more frequently than this are
+	// served from a cached snapshot.
+	MinRefreshInterval time.Duration
+	// MaxConcurrentWorkers specifies the maximum number of Workers the Brigade
+	// scheduler will run concurrently. It is used to determine how saturated the
+	// substrate is. A value of zero means the limit is unknown, in which case
+	// saturation is not reported.
+	MaxConcurrentWorkers int
+	// EventSourcePattern, if non-nil, specifies which Event sources are
+	// reported individually. Events from all other sources are reported with a
+	// source of "other".
+	EventSourcePattern *regexp.Regexp
+	// EventTypePattern, if non-nil, specifies which Event types are reported
+	// individually. Events of all other types are reported with a type of
+	// "other".
+	EventTypePattern *regexp.Regexp
+	// MaxWorkerLifetime specifies the longest time that may elapse between the
+	// creation of an Event and its Worker and all of that Worker's Jobs
+	// finishing. Events older than this cannot have a Worker or Job that
+	// finished recently, so listing Events to observe durations and queue waits
+	// stops upon reaching them. A value of zero means every Event is listed.
+	MaxWorkerLifetime time.Duration
+	// EventIndexEnabled specifies whether event-based collectors should be
+	// served from an in-memory index of all Events instead of listing Events
+	// from the Brigade API every time they run.
+	EventIndexEnabled bool
+	// EventIndexSyncInterval specifies the minimum amount of time between polls
+	// for new or changed Events when EventIndexEnabled is true.
+	EventIndexSyncInterval time.Duration
+	// EventIndexResyncInterval specifies how often the event index is rebuilt
+	// from scratch when EventIndexEnabled is true.
+	EventIndexResyncInterval time.Duration
+	// LabelValueLimit specifies the maximum number of distinct values any metric
+	// may report for each of its labels of unbounded cardinality, such as
+	// project, source, or type. Values in excess of this are reported as
+	// "other". A value of zero means no limit.
+	LabelValueLimit int
+	// LabelValueLimits overrides LabelValueLimit for individual metrics, keyed
+	// by metric name.
+	LabelValueLimits map[string]int
+	// ReadinessFailureThreshold specifies how long every collector must have
+	// been failing before the exporter reports itself not ready.
+	ReadinessFailureThreshold time.Duration
+	// BackoffMaxInterval specifies the maximum amount of time a collector that
+	// keeps failing waits between runs. Each consecutive failure doubles the
+	// time the collector waits, starting from its usual interval, until this
+	// maximum is reached. A value of zero means collectors never back off.
+	BackoffMaxInterval time.Duration
+	// PreflightMode specifies how the exporter verifies, at startup, that it
+	// can reach the Brigade API and is authorized to query everything its
+	// enabled collectors query.
+	PreflightMode preflightMode
+	// PreflightTimeout specifies for how long preflight checks keep retrying
+	// when the Brigade API cannot be reached. Rejection of the exporter's token
+	// is never retried. A value of zero means nothing is retried.
+	PreflightTimeout time.Duration
+	// Collectors holds configuration for individual collectors, keyed by
+	// collector name. Collectors not represented here are enabled and use the
+	// exporter-wide interval.
+	Collectors map[string]collectorConfig
+}
+
+// collectorConfig represents configuration for an individual collector.
+type collectorConfig struct {
+	// Disabled specifies whether the collector should never be run.
+	Disabled bool
+	// Interval overrides how often the collector queries the Brigade API. When
+	// CollectionMode is collectionModePoll, this replaces ScrapeInterval. When
+	// CollectionMode is collectionModeOnDemand, the collector is re-run no more
+	// often than this, even if the snapshot is refreshed more often. A value of
+	// zero means no override.
+	Interval time.Duration
+}
+
 type metricsExporter struct {
-	coreClient           sdk.CoreClient
-	authnClient          sdk.AuthnClient
-	scrapeInterval       time.Duration
-	projectsGauge        prometheus.Gauge
-	usersGauge           prometheus.Gauge
-	serviceAccountsGauge prometheus.Gauge
-	allWorkersByPhase    *prometheus.GaugeVec
-	pendingJobsGauge     prometheus.Gauge
+	config          metricsExporterConfig
+	coreClient      sdk.CoreClient
+	authnClient     sdk.AuthnClient
+	substrateClient sdk.SubstrateClient
+	systemClient    sdk.SystemClient
+	pager           pager
+	// eventIndex, if non-nil, serves Events to event-based collectors.
+	eventIndex            *eventIndex
+	projectsGauge         prometheus.Gauge
+	usersGauge            prometheus.Gauge
+	serviceAccountsGauge  prometheus.Gauge
+	allWorkersByPhase     *prometheus.GaugeVec
+	projectWorkersByPhase *prometheus.GaugeVec
+	jobsByPhase           *prometheus.GaugeVec
+	eventsBySource        *prometheus.GaugeVec
+	sourceEventsByPhase   *prometheus.GaugeVec
+	workerDurations       *prometheus.HistogramVec
+	jobDurations          *prometheus.HistogramVec
+	queueWaits            *prometheus.HistogramVec
+	oldestPendingAges     *prometheus.GaugeVec
+	runningWorkersGauge   prometheus.Gauge
+	runningJobsGauge      prometheus.Gauge
+	saturationGauge       prometheus.Gauge
+	// observed tracks which finished Workers and Jobs have already had their
+	// durations recorded and which started Workers have already had their
+	// queue wait recorded.
+	observed *dedupeStore
+	// recentWorkersObserved records which of the durations and queue
+	// collectors have had their metrics observed, by a listing of recent
+	// Workers made on behalf of the other, since they last ran. It is guarded
+	// by recentWorkersMu.
+	recentWorkersObserved map[string]bool
+	recentWorkersMu       sync.Mutex
+	scrapeErrors          *prometheus.CounterVec
+	scrapeDurations       *prometheus.GaugeVec
+	lastSuccesses         *prometheus.GaugeVec
+	backoffs              *prometheus.GaugeVec
+	upGauge               prometheus.Gauge
+	labelOverflows        *prometheus.CounterVec
+	// limiters maps metrics having labels of unbounded cardinality to wrappers
+	// that limit that cardinality.
+	limiters map[prometheus.Collector]prometheus.Collector
+	// collectorsUp tracks whether the most recent run of each collector
+	// succeeded.
+	collectorsUp map[string]bool
+	// collectorStatuses tracks the outcome of recent runs of each collector for
+	// the purpose of reporting readiness. Like collectorsUp, it is guarded by
+	// collectorsUpMu.
+	collectorStatuses map[string]*collectorStatus
+	collectorsUpMu    sync.Mutex
+	// snapshot holds the metrics collected during the most recent refresh when
+	// the exporter is operating in collectionModeOnDemand.
+	snapshot    []prometheus.Metric
+	lastRefresh time.Time
+	// lastRuns tracks when each collector was last run as part of a refresh.
+	lastRuns  map[string]time.Time
+	refreshMu sync.Mutex
+	// runCtx is the context provided to start. Collectors run as part of a
+	// refresh use it so that shutting the exporter down cancels any requests
+	// they have in flight.
+	runCtx context.Context
+	// publishMu is held for writing while a collector repopulates gauges it has
+	// reset and for reading while metrics are collected.
+	publishMu sync.RWMutex
 }
 
+// labelLimitedMetricNames returns the names of all metrics having labels of
+// unbounded cardinality.
+func labelLimitedMetricNames() []string {
+	return []string{
+		"brigade_project_events_by_worker_phase",
+		"brigade_jobs_by_phase",
+		"brigade_events_total",
+		"brigade_source_events_by_worker_phase",
+		"brigade_worker_duration_seconds",
+		"brigade_job_duration_seconds",
+		"brigade_event_queue_wait_seconds",
+		"brigade_oldest_pending_event_age_seconds",
+	}
+}
+
+// otherLabelValue is the label value reported in place of values that are
+// excluded in order to keep the cardinality of a metric bounded.
+const otherLabelValue = "other"
+
+// minObservationRetention is the least amount of time for which the exporter
+// remembers that the duration of a finished Worker or Job has already been
+// recorded. See observationRetention.
+const minObservationRetention = 15 * time.Minute
+
+// durationBuckets are the histogram buckets used for Worker and Job durations.
+// They range from one second to a little over four and a half hours.
+var durationBuckets = prometheus.ExponentialBuckets(1, 2, 15)
+
+// queueWaitBuckets are the histogram buckets used for the time Events wait for
+// their Workers to start. They range from a quarter of a second to a little
+// over an hour.
+var queueWaitBuckets = prometheus.ExponentialBuckets(0.25, 2, 15)
+
 func newMetricsExporter(
 	apiClient sdk.APIClient,
-	scrapeInterval time.Duration,
+	config metricsExporterConfig,
 ) *metricsExporter {
-	return &metricsExporter{
-		coreClient:     apiClient.Core(),
-		authnClient:    apiClient.Authn(),
-		scrapeInterval: scrapeInterval,
-		projectsGauge: promauto.NewGauge(
+	m := &metricsExporter{
+		config:          config,
+		coreClient:      apiClient.Core(),
+		authnClient:     apiClient.Authn(),
+		substrateClient: apiClient.Core().Substrate(),
+		systemClient:    apiClient.System(),
+		pager: pager{
+			mode:           config.PaginationMode,
+			pageSize:       config.PageSize,
+			requestTimeout: config.APIRequestTimeout,
+		},
+		projectsGauge: prometheus.NewGauge(
 			prometheus.GaugeOpts{
 				Name: "brigade_projects_total",
 				Help: "The total number of projects",
 			},
 		),
-		usersGauge: promauto.NewGauge(
+		usersGauge: prometheus.NewGauge(
 			prometheus.GaugeOpts{
 				Name: "brigade_users_total",
 				Help: "The total number of users",
 			},
 		),
-		serviceAccountsGauge: promauto.NewGauge(
+		serviceAccountsGauge: prometheus.NewGauge(
 			prometheus.GaugeOpts{
 				Name: "brigade_service_accounts_total",
 				Help: "The total number of service accounts",
 			},
 		),
-		allWorkersByPhase: promauto.NewGaugeVec(
+		allWorkersByPhase: prometheus.NewGaugeVec(
 			prometheus.GaugeOpts{
 				Name: "brigade_events_by_worker_phase",
 				Help: "The total number of events grouped by worker phase",
 			},
 			[]string{"workerPhase"},
 		),
-		pendingJobsGauge: promauto.NewGauge(
+		projectWorkersByPhase: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "brigade_project_events_by_worker_phase",
+				Help: "The total number of events for each project grouped by " +
+					"worker phase",
+			},
+			[]string{"project", "workerPhase"},
+		),
+		jobsByPhase: prometheus.NewGaugeVec(
 			prometheus.GaugeOpts{
-				Name: "brigade_pending_jobs_total",
-				Help: "The total number of pending jobs",
+				Name: "brigade_jobs_by_phase",
+				Help: "The total number of jobs belonging to running workers for " +
+					"each project grouped by phase",
 			},
+			[]string{"phase", "project"},
 		),
+		eventsBySource: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "brigade_events_total",
+				Help: "The total number of events for each project grouped by " +
+					"source and type",
+			},
+			[]string{"source", "type", "project"},
+		),
+		sourceEventsByPhase: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "brigade_source_events_by_worker_phase",
+				Help: "The total number of events for each project grouped by " +
+					"source, type, and worker phase",
+			},
+			[]string{"source", "type", "project", "workerPhase"},
+		),
+		workerDurations: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "brigade_worker_duration_seconds",
+				Help:    "The duration of finished workers",
+				Buckets: durationBuckets,
+			},
+			[]string{"project", "workerPhase"},
+		),
+		jobDurations: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "brigade_job_duration_seconds",
+				Help:    "The duration of finished jobs",
+				Buckets: durationBuckets,
+			},
+			[]string{"project", "jobPhase"},
+		),
+		queueWaits: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name: "brigade_event_queue_wait_seconds",
+				Help: "The time between the creation of an event and the start " +
+					"of its worker",
+				Buckets: queueWaitBuckets,
+			},
+			[]string{"project"},
+		),
+		oldestPendingAges: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "brigade_oldest_pending_event_age_seconds",
+				Help: "The age of the oldest event with a pending worker for each " +
+					"project",
+			},
+			[]string{"project"},
+		),
+		runningWorkersGauge: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "brigade_substrate_running_workers",
+				Help: "The number of workers currently executing on the substrate",
+			},
+		),
+		runningJobsGauge: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "brigade_substrate_running_jobs",
+				Help: "The number of jobs currently executing on the substrate",
+			},
+		),
+		saturationGauge: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "brigade_substrate_worker_saturation_ratio",
+				Help: "The number of workers currently executing on the substrate " +
+					"relative to the maximum number of concurrent workers",
+			},
+		),
+		scrapeErrors: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "brigade_exporter_scrape_errors_total",
+				Help: "The total number of errors encountered by each collector, " +
+					"grouped by class of error",
+			},
+			[]string{"collector", "class"},
+		),
+		scrapeDurations: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "brigade_exporter_scrape_duration_seconds",
+				Help: "The duration of the most recent run of each collector",
+			},
+			[]string{"collector"},
+		),
+		lastSuccesses: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "brigade_exporter_last_success_timestamp_seconds",
+				Help: "The time of the most recent successful run of each " +
+					"collector",
+			},
+			[]string{"collector"},
+		),
+		backoffs: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "brigade_exporter_collector_backoff_seconds",
+				Help: "How long each collector that is backing off after " +
+					"consecutive failures waits before its next run, or 0 if it " +
+					"is not backing off",
+			},
+			[]string{"collector"},
+		),
+		upGauge: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "brigade_up",
+				Help: "Whether the most recent run of every collector succeeded",
+			},
+		),
+		labelOverflows: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "brigade_exporter_label_overflow_total",
+				Help: "The total number of times a label value began being " +
+					"reported as \"other\" for each metric to keep its " +
+					"cardinality bounded",
+			},
+			[]string{"metric"},
+		),
+		limiters:              map[prometheus.Collector]prometheus.Collector{},
+		collectorsUp:          map[string]bool{},
+		collectorStatuses:     map[string]*collectorStatus{},
+		lastRuns:              map[string]time.Time{},
+		recentWorkersObserved: map[string]bool{},
+		runCtx:                context.Background(),
+	}
+	if config.EventIndexEnabled {
+		m.eventIndex = newEventIndex(
+			m.coreClient.Events(),
+			m.pager,
+			config.EventIndexSyncInterval,
+			config.EventIndexResyncInterval,
+		)
+	}
+	m.observed = newDedupeStore(m.observationRetention(), time.Now())
+	// Every metric listed here must also be named in labelLimitedMetricNames()
+	for _, l := range []struct {
+		metric        prometheus.Collector
+		name          string
+		labelNames    []string
+		limitedLabels []string
+		aggregate     aggregation
+	}{
+		{
+			metric:        m.projectWorkersByPhase,
+			name:          "brigade_project_events_by_worker_phase",
+			labelNames:    []string{"project", "workerPhase"},
+			limitedLabels: []string{"project"},
+			aggregate:     aggregateSum,
+		},
+		{
+			metric:        m.jobsByPhase,
+			name:          "brigade_jobs_by_phase",
+			labelNames:    []string{"phase", "project"},
+			limitedLabels: []string{"project"},
+			aggregate:     aggregateSum,
+		},
+		{
+			metric:        m.eventsBySource,
+			name:          "brigade_events_total",
+			labelNames:    []string{"source", "type", "project"},
+			limitedLabels: []string{"source", "type", "project"},
+			aggregate:     aggregateSum,
+		},
+		{
+			metric:        m.sourceEventsByPhase,
+			name:          "brigade_source_events_by_worker_phase",
+			labelNames:    []string{"source", "type", "project", "workerPhase"},
+			limitedLabels: []string{"source", "type", "project"},
+			aggregate:     aggregateSum,
+		},
+		{
+			metric:        m.workerDurations,
+			name:          "brigade_worker_duration_seconds",
+			labelNames:    []string{"project", "workerPhase"},
+			limitedLabels: []string{"project"},
+		},
+		{
+			metric:        m.jobDurations,
+			name:          "brigade_job_duration_seconds",
+			labelNames:    []string{"project", "jobPhase"},
+			limitedLabels: []string{"project"},
+		},
+		{
+			metric:        m.queueWaits,
+			name:          "brigade_event_queue_wait_seconds",
+			labelNames:    []string{"project"},
+			limitedLabels: []string{"project"},
+		},
+		{
+			metric:        m.oldestPendingAges,
+			name:          "brigade_oldest_pending_event_age_seconds",
+			labelNames:    []string{"project"},
+			limitedLabels: []string{"project"},
+			aggregate:     aggregateMax,
+		},
+	} {
+		limit := config.LabelValueLimit
+		if metricLimit, ok := config.LabelValueLimits[l.name]; ok {
+			limit = metricLimit
+		}
+		if limit <= 0 {
+			continue
+		}
+		m.limiters[l.metric] = &labelLimiter{
+			collector:     l.metric,
+			labelNames:    l.labelNames,
+			limitedLabels: l.limitedLabels,
+			limit:         limit,
+			aggregate:     l.aggregate,
+			overflows:     m.labelOverflows.WithLabelValues(l.name),
+			admitted:      map[string]map[string]struct{}{},
+			folded:        map[string]map[string]struct{}{},
+		}
+	}
+	// Initialize error counts and backoffs so that they are reported before the
+	// first error
+	for _, c := range m.enabledCollectors() {
+		for _, class := range errorClasses() {
+			m.scrapeErrors.WithLabelValues(c.name, class)
+		}
+		m.backoffs.WithLabelValues(c.name)
 	}
+	return m
 }
 
+// metrics returns all of the metrics reported by the exporter. This includes
+// the exporter's own metrics and the metrics of every enabled collector, with
+// the cardinality of the latter limited where applicable.
+func (m *metricsExporter) metrics() []prometheus.Collector {
+	metrics := []prometheus.Collector{
+		m.scrapeErrors,
+		m.scrapeDurations,
+		m.lastSuccesses,
+		m.backoffs,
+		m.upGauge,
+		m.labelOverflows,
+	}
+	for _, c := range m.enabledCollectors() {
+		for _, metric := range c.metrics {
+			if limiter, ok := m.limiters[metric]; ok {
+				metric = limiter
+			}
+			metrics = append(metrics, metric)
+		}
+	}
+	return metrics
+}
+
+// Describe implements prometheus.Collector.
+func (m *metricsExporter) Describe(ch chan<- *prometheus.Desc) {
+	for _, metric := range m.metrics() {
+		metric.Describe(ch)
+	}
+}
+
+// Collect implements prometheus.Collector. When the exporter is operating in
+// collectionModeOnDemand, this queries the Brigade API if the most recent
+// snapshot is older than the configured minimum refresh interval. Otherwise,
+// it simply reports the current values of all metrics.
+func (m *metricsExporter) Collect(ch chan<- prometheus.Metric) {
+	if m.config.CollectionMode != collectionModeOnDemand {
+		m.collectMetrics(ch)
+		return
+	}
+	for _, metric := range m.refresh() {
+		ch <- metric
+	}
+}
+
+// collectMetrics reports the current values of all metrics. It waits for any
+// collector that is repopulating gauges to finish doing so.
+func (m *metricsExporter) collectMetrics(ch chan<- prometheus.Metric) {
+	m.publishMu.RLock()
+	defer m.publishMu.RUnlock()
+	for _, metric := range m.metrics() {
+		metric.Collect(ch)
+	}
+}
+
+// publish invokes the provided function, which updates metrics, while no
+// metrics are being collected. Collectors that reset a gauge before
+// repopulating it do so via publish so that the reset and the repopulation
+// are, as far as any scrape is concerned, a single atomic update.
+func (m *metricsExporter) publish(fn func()) {
+	m.publishMu.Lock()
+	defer m.publishMu.Unlock()
+	fn()
+}
+
+// refresh queries the Brigade API, updates all metrics, and caches a snapshot
+// of the results, unless the existing snapshot is still fresh. It returns the
+// snapshot. Concurrent callers wait for any refresh in progress and then share
+// its results, so concurrent scrapes never multiply load on the API server.
+func (m *metricsExporter) refresh() []prometheus.Metric {
+	m.refreshMu.Lock()
+	defer m.refreshMu.Unlock()
+	if m.snapshot != nil &&
+		time.Since(m.lastRefresh) < m.config.MinRefreshInterval {
+		return m.snapshot
+	}
+	now := time.Now()
+	collectors := []collector{}
+	for _, c := range m.enabledCollectors() {
+		// Collectors with their own interval keep their previous results until
+		// that interval has elapsed. So do collectors that are backing off.
+		interval := m.config.Collectors[c.name].Interval
+		if interval == 0 {
+			interval = m.collectorMinInterval(c.name)
+		}
+		if backoff := m.collectorBackoff(c.name); backoff > interval {
+			interval = backoff
+		}
+		if lastRun, ok := m.lastRuns[c.name]; ok && now.Sub(lastRun) < interval {
+			continue
+		}
+		m.lastRuns[c.name] = now
+		collectors = append(collectors, c)
+	}
+	wg := sync.WaitGroup{}
+	wg.Add(len(collectors))
+	for _, c := range collectors {
+		go func(c collector) {
+			defer wg.Done()
+			m.runCollector(m.runCtx, c)
+		}(c)
+	}
+	wg.Wait()
+	ch := make(chan prometheus.Metric)
+	go func() {
+		defer close(ch)
+		m.collectMetrics(ch)
+	}()
+	snapshot := []prometheus.Metric{}
+	for metric := range ch {
+		snapshot = append(snapshot, metric)
+	}
+	m.snapshot = snapshot
+	m.lastRefresh = time.Now()
+	return m.snapshot
+}
+
+// start begins periodically querying the Brigade API when the exporter is
+// operating in collectionModePoll. Otherwise, it only arranges for queries
+// made when metrics are scraped to be canceled along with the provided
+// context. It must be called before the exporter is first scraped.
 func (m *metricsExporter) start(ctx context.Context) {
-	go m.recordMetric(ctx, func() error {
-		return m.recordProjectsCount()
-	})
-	go m.recordMetric(ctx, func() error {
-		return m.recordUsersCount()
-	})
-	go m.recordMetric(ctx, func() error {
-		return m.recordServiceAccountsCount()
-	})
-	go m.recordMetric(ctx, func() error {
-		return m.recordEventCountsByWorkersPhase()
-	})
-	go m.recordMetric(ctx, func() error {
-		return m.recordPendingJobsCount()
-	})
+	m.runCtx = ctx
+	if m.config.CollectionMode == collectionModeOnDemand {
+		return
+	}
+	for _, c := range m.enabledCollectors() {
+		go m.recordMetric(ctx, c)
+	}
 }
 
-func (m *metricsExporter) recordMetric(
-	ctx context.Context,
-	recordFn func() error,
-) {
-	ticker := time.NewTicker(m.scrapeInterval)
-	defer ticker.Stop()
+// minPollDelay is the least amount of time recordMetric waits between runs of
+// a collector. Intervals are validated when the exporter is configured, but a
+// zero interval slipping through must not become a busy loop that hammers the
+// Brigade API.
+const minPollDelay = time.Second
+
+// recordMetric runs the specified collector repeatedly until the provided
+// context is canceled. The first run begins right away so that every metric,
+// and brigade_up, is reported soon after startup, even for collectors with
+// long intervals. Each subsequent run begins one interval after the previous
+// run finished, or later if the collector is backing off, but never sooner
+// than minPollDelay.
+func (m *metricsExporter) recordMetric(ctx context.Context, c collector) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
 	for {
 		select {
-		case <-ticker.C:
-			if err := recordFn(); err != nil {
-				log.Println(err)
-			}
+		case <-timer.C:
+			timer.Reset(pollDelay(m.runCollector(ctx, c)))
 		case <-ctx.Done():
 			return
 		}
 	}
 }
 
-func (m *metricsExporter) recordProjectsCount() error {
+// pollDelay returns the provided delay, or minPollDelay if that is longer.
+func pollDelay(delay time.Duration) time.Duration {
+	if delay < minPollDelay {
+		return minPollDelay
+	}
+	return delay
+}
+
+// runCollector runs the specified collector once and records the outcome in
+// the exporter's own metrics. It returns how long to wait before running the
+// collector again, which exceeds the collector's usual interval if the
+// collector is backing off after consecutive failures.
+func (m *metricsExporter) runCollector(
+	ctx context.Context,
+	c collector,
+) time.Duration {
+	interval := m.collectorInterval(c.name)
+	start := time.Now()
+	err := c.recordFn(ctx)
+	if err != nil && ctx.Err() != nil {
+		// The exporter is shutting down. This isn't worth reporting.
+		return interval
+	}
+	duration := time.Since(start)
+	m.scrapeDurations.WithLabelValues(c.name).Set(duration.Seconds())
+	keyvals := []interface{}{"collector", c.name, "duration", duration}
+	if err != nil {
+		class := errorClass(err)
+		m.scrapeErrors.WithLabelValues(c.name, class).Inc()
+		keyvals = append(keyvals, "class", class)
+	} else {
+		m.lastSuccesses.WithLabelValues(c.name).SetToCurrentTime()
+	}
+	m.collectorsUpMu.Lock()
+	defer m.collectorsUpMu.Unlock()
+	m.collectorsUp[c.name] = err == nil
+	status, ok := m.collectorStatuses[c.name]
+	if !ok {
+		status = &collectorStatus{}
+		m.collectorStatuses[c.name] = status
+	}
+	status.record(err, time.Now())
+	// Jitter only needs to spread retries out, so it needn't be secure
+	delay := backoffDelay(
+		interval,
+		m.config.BackoffMaxInterval,
+		status.consecutiveFailures,
+		rand.Float64(), // nolint: gosec
+	)
+	status.backoff = 0
+	if delay > interval {
+		status.backoff = delay
+		keyvals = append(keyvals, "backoff", delay)
+	}
+	m.backoffs.WithLabelValues(c.name).Set(status.backoff.Seconds())
+	// Logging the outcome while the lock is held keeps the messages about each
+	// collector in the order of its runs
+	appLogger.logOutcome(&status.failures, "collector", err, keyvals...)
+	// brigade_up is only 1 once every enabled collector has run and the most
+	// recent run of each succeeded
+	up := true
+	for _, c := range m.enabledCollectors() {
+		up = up && m.collectorsUp[c.name]
+	}
+	if up {
+		m.upGauge.Set(1)
+	} else {
+		m.upGauge.Set(0)
+	}
+	return delay
+}
+
+// collectorBackoff returns how long the named collector waits before its next
+// run while it is backing off after consecutive failures, or zero if it is not
+// backing off.
+func (m *metricsExporter) collectorBackoff(name string) time.Duration {
+	m.collectorsUpMu.Lock()
+	defer m.collectorsUpMu.Unlock()
+	if status, ok := m.collectorStatuses[name]; ok {
+		return status.backoff
+	}
+	return 0
+}
+
+func (m *metricsExporter) recordProjectsCount(ctx context.Context) error {
 	// brigade_projects_total
-	projects, err := m.coreClient.Projects().List(
-		context.Background(),
-		&sdk.ProjectsSelector{},
-		&meta.ListOptions{},
+	count, err := m.pager.count(
+		ctx,
+		func(
+			ctx context.Context,
+			opts *meta.ListOptions,
+		) (int, meta.ListMeta, error) {
+			projects, err :=
+				m.coreClient.Projects().List(ctx, &sdk.ProjectsSelector{}, opts)
+			return len(projects.Items), projects.ListMeta, err
+		},
 	)
 	if err != nil {
 		return err
 	}
-	m.projectsGauge.Set(
-		float64(len(projects.Items) + int(projects.RemainingItemCount)),
-	)
+	m.projectsGauge.Set(float64(count))
 	return nil
 }
 
-func (m *metricsExporter) recordUsersCount() error {
+func (m *metricsExporter) recordUsersCount(ctx context.Context) error {
 	// brigade_users_total
-	users, err := m.authnClient.Users().List(
-		context.Background(),
-		&sdk.UsersSelector{},
-		&meta.ListOptions{},
+	count, err := m.pager.count(
+		ctx,
+		func(
+			ctx context.Context,
+			opts *meta.ListOptions,
+		) (int, meta.ListMeta, error) {
+			users, err := m.authnClient.Users().List(ctx, &sdk.UsersSelector{}, opts)
+			return len(users.Items), users.ListMeta, err
+		},
 	)
 	if err != nil {
 		return err
 	}
-	m.usersGauge.Set(
-		float64(int64(len(users.Items)) + users.RemainingItemCount),
-	)
+	m.usersGauge.Set(float64(count))
 	return nil
 }
 
-func (m *metricsExporter) recordServiceAccountsCount() error {
+func (m *metricsExporter) recordServiceAccountsCount(
+	ctx context.Context,
+) error {
 	// brigade_service_accounts_total
-	serviceAccounts, err := m.authnClient.ServiceAccounts().List(
-		context.Background(),
-		&sdk.ServiceAccountsSelector{},
-		&meta.ListOptions{},
+	count, err := m.pager.count(
+		ctx,
+		func(
+			ctx context.Context,
+			opts *meta.ListOptions,
+		) (int, meta.ListMeta, error) {
+			serviceAccounts, err := m.authnClient.ServiceAccounts().List(
+				ctx,
+				&sdk.ServiceAccountsSelector{},
+				opts,
+			)
+			return len(serviceAccounts.Items), serviceAccounts.ListMeta, err
+		},
 	)
 	if err != nil {
 		return err
 	}
-	m.serviceAccountsGauge.Set(
-		float64(
-			int64(len(serviceAccounts.Items)) +
-				serviceAccounts.RemainingItemCount,
-		),
-	)
+	m.serviceAccountsGauge.Set(float64(count))
 	return nil
 }
 
-func (m *metricsExporter) recordEventCountsByWorkersPhase() error {
+// countEvents returns the number of Events matching the provided selector.
+func (m *metricsExporter) countEvents(
+	ctx context.Context,
+	selector *sdk.EventsSelector,
+) (int, error) {
+	if m.eventIndex != nil {
+		return m.eventIndex.countEvents(ctx, selector)
+	}
+	return m.pager.count(
+		ctx,
+		func(
+			ctx context.Context,
+			opts *meta.ListOptions,
+		) (int, meta.ListMeta, error) {
+			events, err := m.coreClient.Events().List(ctx, selector, opts)
+			return len(events.Items), events.ListMeta, err
+		},
+	)
+}
+
+// forEachEvent invokes the provided function once for every Event matching
+// the provided selector.
+func (m *metricsExporter) forEachEvent(
+	ctx context.Context,
+	selector *sdk.EventsSelector,
+	fn func(sdk.Event),
+) error {
+	if m.eventIndex != nil {
+		return m.eventIndex.forEachEvent(ctx, selector, fn)
+	}
+	return m.pager.forEachPage(
+		ctx,
+		func(
+			ctx context.Context,
+			opts *meta.ListOptions,
+		) (int, meta.ListMeta, error) {
+			events, err := m.coreClient.Events().List(ctx, selector, opts)
+			if err != nil {
+				return 0, events.ListMeta, err
+			}
+			for _, event := range events.Items {
+				fn(event)
+			}
+			return len(events.Items), events.ListMeta, nil
+		},
+	)
+}
+
+// forEachRecentEvent invokes the provided function once for every Event
+// matching the provided selector that was created no earlier than the
+// specified time. Because the API server lists Events newest first, listing
+// stops at the first Event that is too old, which spares collectors that are
+// only interested in recent Events from paging through every Event ever
+// created.
+func (m *metricsExporter) forEachRecentEvent(
+	ctx context.Context,
+	selector *sdk.EventsSelector,
+	since time.Time,
+	fn func(sdk.Event),
+) error {
+	recent := func(event sdk.Event) bool {
+		return event.Created == nil || !event.Created.Before(since)
+	}
+	if m.eventIndex != nil {
+		// The index is in memory, so there is nothing to be saved by stopping
+		// early
+		return m.eventIndex.forEachEvent(
+			ctx,
+			selector,
+			func(event sdk.Event) {
+				if recent(event) {
+					fn(event)
+				}
+			},
+		)
+	}
+	return m.pager.forEachPage(
+		ctx,
+		func(
+			ctx context.Context,
+			opts *meta.ListOptions,
+		) (int, meta.ListMeta, error) {
+			events, err := m.coreClient.Events().List(ctx, selector, opts)
+			if err != nil {
+				return 0, events.ListMeta, err
+			}
+			for _, event := range events.Items {
+				if !recent(event) {
+					// Returning no continue value stops the pager
+					return len(events.Items), meta.ListMeta{}, nil
+				}
+				fn(event)
+			}
+			return len(events.Items), events.ListMeta, nil
+		},
+	)
+}
+
+func (m *metricsExporter) recordEventCountsByWorkersPhase(
+	ctx context.Context,
+) error {
 	// brigade_events_by_worker_phase
 	for _, phase := range sdk.WorkerPhasesAll() {
-		events, err := m.coreClient.Events().List(
-			context.Background(),
+		count, err := m.countEvents(
+			ctx,
 			&sdk.EventsSelector{
 				WorkerPhases: []sdk.WorkerPhase{phase},
 			},
-			&meta.ListOptions{},
 		)
 		if err != nil {
 			return err
 		}
 		m.allWorkersByPhase.With(
 			prometheus.Labels{"workerPhase": string(phase)},
-		).Set(float64(len(events.Items) + int(events.RemainingItemCount)))
+		).Set(float64(count))
 	}
 	return nil
 }
 
-func (m *metricsExporter) recordPendingJobsCount() error {
-	// brigade_pending_jobs_total
-	var pendingJobs int
-	var continueValue string
-	for {
-		events, err := m.coreClient.Events().List(
-			context.Background(),
-			&sdk.EventsSelector{
-				WorkerPhases: []sdk.WorkerPhase{sdk.WorkerPhaseRunning},
-			},
-			&meta.ListOptions{
-				Continue: continueValue,
-			},
-		)
-		if err != nil {
-			return err
+func (m *metricsExporter) recordProjectEventCountsByWorkersPhase(
+	ctx context.Context,
+) error {
+	// brigade_project_events_by_worker_phase
+	//
+	// Counts are collected in full before the gauge is touched so that projects
+	// that have since been deleted drop out of the results. Because this means
+	// resetting the gauge, it is repopulated via publish so that no scrape
+	// observes it partially populated.
+	projectIDs := []string{}
+	if err := m.pager.forEachPage(
+		ctx,
+		func(
+			ctx context.Context,
+			opts *meta.ListOptions,
+		) (int, meta.ListMeta, error) {
+			projects, err :=
+				m.coreClient.Projects().List(ctx, &sdk.ProjectsSelector{}, opts)
+			for _, project := range projects.Items {
+				projectIDs = append(projectIDs, project.ID)
+			}
+			return len(projects.Items), projects.ListMeta, err
+		},
+	); err != nil {
+		return err
+	}
+	counts := map[string]map[sdk.WorkerPhase]int{}
+	for _, projectID := range projectIDs {
+		counts[projectID] = map[sdk.WorkerPhase]int{}
+		for _, phase := range sdk.WorkerPhasesAll() {
+			count, err := m.countEvents(
+				ctx,
+				&sdk.EventsSelector{
+					ProjectID:    projectID,
+					WorkerPhases: []sdk.WorkerPhase{phase},
+				},
+			)
+			if err != nil {
+				return err
+			}
+			counts[projectID][phase] = count
 		}
-		for _, event := range events.Items {
+	}
+	m.publish(func() {
+		m.projectWorkersByPhase.Reset()
+		for projectID, phaseCounts := range counts {
+			for phase, count := range phaseCounts {
+				m.projectWorkersByPhase.With(
+					prometheus.Labels{
+						"project":     projectID,
+						"workerPhase": string(phase),
+					},
+				).Set(float64(count))
+			}
+		}
+	})
+	return nil
+}
+
+// jobPhasesAll returns a slice of JobPhases containing ALL possible phases.
+// The SDK offers an equivalent for WorkerPhases, but not for JobPhases.
+func jobPhasesAll() []sdk.JobPhase {
+	return []sdk.JobPhase{
+		sdk.JobPhaseAborted,
+		sdk.JobPhaseCanceled,
+		sdk.JobPhaseFailed,
+		sdk.JobPhasePending,
+		sdk.JobPhaseRunning,
+		sdk.JobPhaseSchedulingFailed,
+		sdk.JobPhaseStarting,
+		sdk.JobPhaseSucceeded,
+		sdk.JobPhaseTimedOut,
+		sdk.JobPhaseUnknown,
+	}
+}
+
+func (m *metricsExporter) recordJobCountsByPhase(ctx context.Context) error {
+	// brigade_jobs_by_phase
+	//
+	// As with per-project event counts, counts are collected in full before the
+	// gauge is touched.
+	counts := map[string]map[sdk.JobPhase]int{}
+	if err := m.forEachEvent(
+		ctx,
+		&sdk.EventsSelector{
+			WorkerPhases: []sdk.WorkerPhase{sdk.WorkerPhaseRunning},
+		},
+		func(event sdk.Event) {
+			if event.Worker == nil {
+				return
+			}
+			if _, ok := counts[event.ProjectID]; !ok {
+				counts[event.ProjectID] = map[sdk.JobPhase]int{}
+			}
 			for _, job := range event.Worker.Jobs {
-				if job.Status.Phase == sdk.JobPhasePending {
-					pendingJobs++
+				if job.Status != nil {
+					counts[event.ProjectID][job.Status.Phase]++
 				}
 			}
+		},
+	); err != nil {
+		return err
+	}
+	m.publish(func() {
+		m.jobsByPhase.Reset()
+		for projectID, phaseCounts := range counts {
+			// Report every phase, even those with no jobs, for every project with a
+			// running worker
+			for _, phase := range jobPhasesAll() {
+				m.jobsByPhase.With(
+					prometheus.Labels{
+						"phase":   string(phase),
+						"project": projectID,
+					},
+				).Set(float64(phaseCounts[phase]))
+			}
+		}
+	})
+	return nil
+}
+
+// allowedLabelValue returns the provided value if the provided pattern is nil
+// or matches it. Otherwise, it returns otherLabelValue.
+func allowedLabelValue(pattern *regexp.Regexp, value string) string {
+	if pattern == nil || pattern.MatchString(value) {
+		return value
+	}
+	return otherLabelValue
+}
+
+func (m *metricsExporter) recordEventCountsBySource(
+	ctx context.Context,
+) error {
+	// brigade_events_total
+	// brigade_source_events_by_worker_phase
+	//
+	// Both metrics are derived from a single walk of all Events. As with
+	// per-project event counts, counts are collected in full before either gauge
+	// is touched.
+	type sourceKey struct {
+		source    string
+		eventType string
+		projectID string
+	}
+	type phaseKey struct {
+		sourceKey
+		phase sdk.WorkerPhase
+	}
+	totals := map[sourceKey]int{}
+	phaseCounts := map[phaseKey]int{}
+	if err := m.forEachEvent(
+		ctx,
+		&sdk.EventsSelector{
+			WorkerPhases: sdk.WorkerPhasesAll(),
+		},
+		func(event sdk.Event) {
+			key := sourceKey{
+				source:    allowedLabelValue(m.config.EventSourcePattern, event.Source),
+				eventType: allowedLabelValue(m.config.EventTypePattern, event.Type),
+				projectID: event.ProjectID,
+			}
+			totals[key]++
+			phase := sdk.WorkerPhaseUnknown
+			if event.Worker != nil {
+				phase = event.Worker.Status.Phase
+			}
+			phaseCounts[phaseKey{sourceKey: key, phase: phase}]++
+		},
+	); err != nil {
+		return err
+	}
+	m.publish(func() {
+		m.eventsBySource.Reset()
+		for key, count := range totals {
+			m.eventsBySource.With(
+				prometheus.Labels{
+					"source":  key.source,
+					"type":    key.eventType,
+					"project": key.projectID,
+				},
+			).Set(float64(count))
 		}
-		if events.Continue == "" {
-			break
+		m.sourceEventsByPhase.Reset()
+		for key, count := range phaseCounts {
+			m.sourceEventsByPhase.With(
+				prometheus.Labels{
+					"source":      key.source,
+					"type":        key.eventType,
+					"project":     key.projectID,
+					"workerPhase": string(key.phase),
+				},
+			).Set(float64(count))
 		}
-		continueValue = events.Continue
+	})
+	return nil
+}
+
+// observationRetention returns how long the exporter remembers that the
+// duration of a finished Worker or Job, or the queue wait of a started Worker,
+// has already been recorded. Anything that finished or started longer ago than
+// this when first listed is never observed, so retention must comfortably
+// exceed the longest time that may elapse between listings, which is the
+// longer of the durations and queue collectors' intervals plus the longest
+// they may back off for.
+func (m *metricsExporter) observationRetention() time.Duration {
+	interval := m.collectorInterval(collectorDurations)
+	if queueInterval := m.collectorInterval(collectorQueue); queueInterval >
+		interval {
+		interval = queueInterval
+	}
+	if retention := 2 * (interval + m.config.BackoffMaxInterval); retention >
+		minObservationRetention {
+		return retention
+	}
+	return minObservationRetention
+}
+
+// startedWorkerPhases returns every phase of a Worker that has started, or at
+// least could have, i.e. running and every terminal phase. Terminal phases are
+// determined using IsTerminal() because sdk.WorkerPhasesTerminal() omits
+// sdk.WorkerPhaseSchedulingFailed.
+func startedWorkerPhases() []sdk.WorkerPhase {
+	phases := []sdk.WorkerPhase{sdk.WorkerPhaseRunning}
+	for _, phase := range sdk.WorkerPhasesAll() {
+		if phase.IsTerminal() {
+			phases = append(phases, phase)
+		}
+	}
+	return phases
+}
+
+// observationWindowStart prunes the dedupe store and returns the creation time
+// of the oldest Event whose Worker or Jobs could still start or finish within
+// the dedupe store's retention window. Only such starts and finishes can be
+// observed, so older Events need not be listed. The zero time is returned if
+// no bound on how long Workers may run has been configured.
+func (m *metricsExporter) observationWindowStart() time.Time {
+	m.observed.prune(time.Now())
+	if m.config.MaxWorkerLifetime <= 0 {
+		return time.Time{}
+	}
+	return m.observed.earliest().Add(-m.config.MaxWorkerLifetime)
+}
+
+func (m *metricsExporter) recordDurations(ctx context.Context) error {
+	// brigade_worker_duration_seconds
+	// brigade_job_duration_seconds
+	return m.observeRecentWorkers(ctx, collectorDurations)
+}
+
+// observeRecentWorkers lists Events whose Workers have started recently, on
+// behalf of the named collector, and observes the durations of any newly
+// finished Workers and Jobs and the queue waits of any newly started Workers.
+// The durations and queue collectors need the very same listing, so a single
+// listing serves both of them if both are enabled: if a listing made on behalf
+// of one has succeeded since the other last ran, the other doesn't list again.
+func (m *metricsExporter) observeRecentWorkers(
+	ctx context.Context,
+	collectorName string,
+) error {
+	m.recentWorkersMu.Lock()
+	defer m.recentWorkersMu.Unlock()
+	if m.recentWorkersObserved[collectorName] {
+		m.recentWorkersObserved[collectorName] = false
+		return nil
+	}
+	observeDurations := !m.config.Collectors[collectorDurations].Disabled
+	observeQueueWaits := !m.config.Collectors[collectorQueue].Disabled
+	// Every Worker that has started is either running or has finished. Jobs can
+	// finish while their Worker is still running, so running Workers are of
+	// interest to the durations collector as well.
+	if err := m.forEachRecentEvent(
+		ctx,
+		&sdk.EventsSelector{WorkerPhases: startedWorkerPhases()},
+		m.observationWindowStart(),
+		func(event sdk.Event) {
+			if event.Worker == nil {
+				return
+			}
+			if observeDurations {
+				m.observeDurations(event)
+			}
+			if observeQueueWaits {
+				m.observeQueueWait(event)
+			}
+		},
+	); err != nil {
+		return err
+	}
+	for _, name := range []string{collectorDurations, collectorQueue} {
+		if name != collectorName && !m.config.Collectors[name].Disabled {
+			m.recentWorkersObserved[name] = true
+		}
+	}
+	return nil
+}
+
+// observeDurations observes the durations of the provided Event's Worker and
+// its Jobs, if they have finished and haven't been observed already.
+func (m *metricsExporter) observeDurations(event sdk.Event) {
+	status := event.Worker.Status
+	if status.Phase.IsTerminal() &&
+		status.Started != nil &&
+		status.Ended != nil &&
+		m.observed.add("worker/"+event.ID, *status.Ended) {
+		m.workerDurations.With(
+			prometheus.Labels{
+				"project":     event.ProjectID,
+				"workerPhase": string(status.Phase),
+			},
+		).Observe(status.Ended.Sub(*status.Started).Seconds())
+	}
+	for _, job := range event.Worker.Jobs {
+		if job.Status == nil ||
+			!job.Status.Phase.IsTerminal() ||
+			job.Status.Started == nil ||
+			job.Status.Ended == nil ||
+			!m.observed.add(
+				"job/"+event.ID+"/"+job.Name,
+				*job.Status.Ended,
+			) {
+			continue
+		}
+		m.jobDurations.With(
+			prometheus.Labels{
+				"project":  event.ProjectID,
+				"jobPhase": string(job.Status.Phase),
+			},
+		).Observe(job.Status.Ended.Sub(*job.Status.Started).Seconds())
+	}
+}
+
+// observeQueueWait observes how long the provided Event waited for its Worker
+// to start, if it has started and its wait hasn't been observed already.
+func (m *metricsExporter) observeQueueWait(event sdk.Event) {
+	if event.Created == nil || event.Worker.Status.Started == nil {
+		return
+	}
+	started := *event.Worker.Status.Started
+	if m.observed.add("queue/"+event.ID, started) {
+		m.queueWaits.With(
+			prometheus.Labels{"project": event.ProjectID},
+		).Observe(started.Sub(*event.Created).Seconds())
+	}
+}
+
+func (m *metricsExporter) recordQueue(ctx context.Context) error {
+	// brigade_oldest_pending_event_age_seconds
+	// brigade_event_queue_wait_seconds
+	if err := m.recordOldestPendingEventAges(ctx); err != nil {
+		return err
+	}
+	return m.recordQueueWaits(ctx)
+}
+
+func (m *metricsExporter) recordOldestPendingEventAges(
+	ctx context.Context,
+) error {
+	// brigade_oldest_pending_event_age_seconds
+	//
+	// As with per-project event counts, ages are collected in full before the
+	// gauge is touched. Projects with no pending events drop out of the results.
+	oldest := map[string]time.Time{}
+	if err := m.forEachEvent(
+		ctx,
+		&sdk.EventsSelector{
+			WorkerPhases: []sdk.WorkerPhase{sdk.WorkerPhasePending},
+		},
+		func(event sdk.Event) {
+			if event.Created == nil {
+				return
+			}
+			if created, ok := oldest[event.ProjectID]; !ok ||
+				event.Created.Before(created) {
+				oldest[event.ProjectID] = *event.Created
+			}
+		},
+	); err != nil {
+		return err
+	}
+	now := time.Now()
+	m.publish(func() {
+		m.oldestPendingAges.Reset()
+		for projectID, created := range oldest {
+			m.oldestPendingAges.With(
+				prometheus.Labels{"project": projectID},
+			).Set(now.Sub(created).Seconds())
+		}
+	})
+	return nil
+}
+
+func (m *metricsExporter) recordQueueWaits(ctx context.Context) error {
+	// brigade_event_queue_wait_seconds
+	return m.observeRecentWorkers(ctx, collectorQueue)
+}
+
+func (m *metricsExporter) recordRunningWorkersCount(
+	ctx context.Context,
+) error {
+	// brigade_substrate_running_workers
+	// brigade_substrate_worker_saturation_ratio
+	ctx, cancel := requestContext(ctx, m.config.APIRequestTimeout)
+	defer cancel()
+	count, err := m.substrateClient.CountRunningWorkers(ctx, nil)
+	if err != nil {
+		return err
+	}
+	m.runningWorkersGauge.Set(float64(count.Count))
+	if m.config.MaxConcurrentWorkers > 0 {
+		m.saturationGauge.Set(
+			float64(count.Count) / float64(m.config.MaxConcurrentWorkers),
+		)
+	}
+	return nil
+}
+
+func (m *metricsExporter) recordRunningJobsCount(ctx context.Context) error {
+	// brigade_substrate_running_jobs
+	ctx, cancel := requestContext(ctx, m.config.APIRequestTimeout)
+	defer cancel()
+	count, err := m.substrateClient.CountRunningJobs(ctx, nil)
+	if err != nil {
+		return err
 	}
-	m.pendingJobsGauge.Set(float64(pendingJobs))
+	m.runningJobsGauge.Set(float64(count.Count))
 	return nil
 }
@@ -3,6 +3,8 @@ package main
 import (
 	"context"
 	"errors"
+	"regexp"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -11,6 +13,7 @@ import (
 	sdkTesting "github.com/brigadecore/brigade/sdk/v3/testing"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -21,16 +24,487 @@ func TestNewMetricsExporter(t *testing.T) {
 			CoreClient:  &sdkTesting.MockCoreClient{},
 			AuthnClient: &sdkTesting.MockAuthnClient{},
 		},
-		5*time.Second,
+		metricsExporterConfig{
+			ScrapeInterval: 5 * time.Second,
+		},
 	)
+	require.Equal(t, 5*time.Second, exporter.config.ScrapeInterval)
 	require.NotNil(t, exporter.coreClient)
 	require.NotNil(t, exporter.authnClient)
-	require.NotNil(t, exporter.scrapeInterval)
 	require.NotNil(t, exporter.projectsGauge)
 	require.NotNil(t, exporter.usersGauge)
 	require.NotNil(t, exporter.serviceAccountsGauge)
 	require.NotNil(t, exporter.allWorkersByPhase)
-	require.NotNil(t, exporter.pendingJobsGauge)
+	require.NotNil(t, exporter.projectWorkersByPhase)
+	require.NotNil(t, exporter.jobsByPhase)
+	require.NotNil(t, exporter.eventsBySource)
+	require.NotNil(t, exporter.sourceEventsByPhase)
+	require.NotNil(t, exporter.workerDurations)
+	require.NotNil(t, exporter.jobDurations)
+	require.NotNil(t, exporter.queueWaits)
+	require.NotNil(t, exporter.oldestPendingAges)
+	require.NotNil(t, exporter.runningWorkersGauge)
+	require.NotNil(t, exporter.runningJobsGauge)
+	require.NotNil(t, exporter.saturationGauge)
+	require.NotNil(t, exporter.observed)
+	require.NotNil(t, exporter.recentWorkersObserved)
+	require.NotNil(t, exporter.scrapeErrors)
+	require.NotNil(t, exporter.scrapeDurations)
+	require.NotNil(t, exporter.lastSuccesses)
+	require.NotNil(t, exporter.upGauge)
+	require.NotNil(t, exporter.labelOverflows)
+	require.NotNil(t, exporter.limiters)
+	require.NotNil(t, exporter.collectorsUp)
+	require.NotNil(t, exporter.collectorStatuses)
+	// Every collector's error count should be initialized
+	require.Equal(
+		t,
+		len(exporter.collectors())*len(errorClasses()),
+		testutil.CollectAndCount(exporter.scrapeErrors),
+	)
+}
+
+func TestMetricsExporterCollect(t *testing.T) {
+	testCases := []struct {
+		name       string
+		config     metricsExporterConfig
+		assertions func(apiCalls int, registry *prometheus.Registry)
+	}{
+		{
+			name: "poll mode",
+			config: metricsExporterConfig{
+				CollectionMode: collectionModePoll,
+			},
+			assertions: func(apiCalls int, registry *prometheus.Registry) {
+				// Scraping must not have queried the API
+				require.Equal(t, 0, apiCalls)
+				count, err := testutil.GatherAndCount(
+					registry,
+					"brigade_projects_total",
+				)
+				require.NoError(t, err)
+				require.Equal(t, 1, count)
+			},
+		},
+		{
+			name: "on-demand mode",
+			config: metricsExporterConfig{
+				CollectionMode:     collectionModeOnDemand,
+				MinRefreshInterval: time.Hour,
+			},
+			assertions: func(apiCalls int, registry *prometheus.Registry) {
+				// Only the first scrape should have queried the API, which involves
+				// listing projects once for the projects count and once for the
+				// per-project event counts
+				require.Equal(t, 2, apiCalls)
+				count, err := testutil.GatherAndCount(
+					registry,
+					"brigade_projects_total",
+				)
+				require.NoError(t, err)
+				require.Equal(t, 1, count)
+			},
+		},
+		{
+			name: "on-demand mode; collector disabled",
+			config: metricsExporterConfig{
+				CollectionMode: collectionModeOnDemand,
+				Collectors: map[string]collectorConfig{
+					collectorProjectEventsByWorkerPhase: {
+						Disabled: true,
+					},
+				},
+			},
+			assertions: func(apiCalls int, _ *prometheus.Registry) {
+				// Both scrapes should have queried the API, but only for the
+				// projects count
+				require.Equal(t, 2, apiCalls)
+			},
+		},
+		{
+			name: "on-demand mode; collector interval",
+			config: metricsExporterConfig{
+				CollectionMode: collectionModeOnDemand,
+				Collectors: map[string]collectorConfig{
+					collectorProjects: {
+						Interval: time.Hour,
+					},
+				},
+			},
+			assertions: func(apiCalls int, _ *prometheus.Registry) {
+				// Both scrapes should have listed projects for the per-project event
+				// counts, but only the first should have done so for the projects
+				// count
+				require.Equal(t, 3, apiCalls)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			var apiCalls int32
+			exporter := newMetricsExporter(
+				&sdkTesting.MockAPIClient{
+					CoreClient: &sdkTesting.MockCoreClient{
+						ProjectsClient: &sdkTesting.MockProjectsClient{
+							ListFn: func(
+								context.Context,
+								*sdk.ProjectsSelector,
+								*meta.ListOptions,
+							) (sdk.ProjectList, error) {
+								atomic.AddInt32(&apiCalls, 1)
+								return sdk.ProjectList{}, nil
+							},
+						},
+						EventsClient: &sdkTesting.MockEventsClient{
+							ListFn: func(
+								context.Context,
+								*sdk.EventsSelector,
+								*meta.ListOptions,
+							) (sdk.EventList, error) {
+								return sdk.EventList{}, nil
+							},
+						},
+						SubstrateClient: &sdkTesting.MockSubstrateClient{
+							CountRunningWorkersFn: func(
+								context.Context,
+								*sdk.RunningWorkerCountOptions,
+							) (sdk.SubstrateWorkerCount, error) {
+								return sdk.SubstrateWorkerCount{}, nil
+							},
+							CountRunningJobsFn: func(
+								context.Context,
+								*sdk.RunningJobCountOptions,
+							) (sdk.SubstrateJobCount, error) {
+								return sdk.SubstrateJobCount{}, nil
+							},
+						},
+					},
+					AuthnClient: &sdkTesting.MockAuthnClient{
+						UsersClient: &sdkTesting.MockUsersClient{
+							ListFn: func(
+								context.Context,
+								*sdk.UsersSelector,
+								*meta.ListOptions,
+							) (sdk.UserList, error) {
+								return sdk.UserList{}, nil
+							},
+						},
+						ServiceAccountsClient: &sdkTesting.MockServiceAccountsClient{
+							ListFn: func(
+								context.Context,
+								*sdk.ServiceAccountsSelector,
+								*meta.ListOptions,
+							) (sdk.ServiceAccountList, error) {
+								return sdk.ServiceAccountList{}, nil
+							},
+						},
+					},
+				},
+				testCase.config,
+			)
+			registry := prometheus.NewRegistry()
+			require.NoError(t, registry.Register(exporter))
+			_, err := registry.Gather()
+			require.NoError(t, err)
+			_, err = registry.Gather()
+			require.NoError(t, err)
+			testCase.assertions(int(atomic.LoadInt32(&apiCalls)), registry)
+		})
+	}
+}
+
+func TestMetricsExporterCollectCanceled(t *testing.T) {
+	collectors := map[string]collectorConfig{}
+	for _, name := range collectorNames() {
+		if name != collectorProjects {
+			collectors[name] = collectorConfig{Disabled: true}
+		}
+	}
+	var listErr error
+	exporter := newMetricsExporter(
+		&sdkTesting.MockAPIClient{
+			CoreClient: &sdkTesting.MockCoreClient{
+				ProjectsClient: &sdkTesting.MockProjectsClient{
+					ListFn: func(
+						ctx context.Context,
+						_ *sdk.ProjectsSelector,
+						_ *meta.ListOptions,
+					) (sdk.ProjectList, error) {
+						listErr = ctx.Err()
+						return sdk.ProjectList{}, listErr
+					},
+				},
+			},
+			AuthnClient: &sdkTesting.MockAuthnClient{},
+		},
+		metricsExporterConfig{
+			CollectionMode: collectionModeOnDemand,
+			Collectors:     collectors,
+		},
+	)
+	registry := prometheus.NewRegistry()
+	require.NoError(t, registry.Register(exporter))
+	ctx, cancel := context.WithCancel(context.Background())
+	exporter.start(ctx)
+	cancel()
+	_, err := registry.Gather()
+	require.NoError(t, err)
+	// Queries made by a scrape must be canceled along with the exporter
+	require.ErrorIs(t, listErr, context.Canceled)
+}
+
+func TestMetricsExporterPublish(t *testing.T) {
+	exporter := newMetricsExporter(
+		&sdkTesting.MockAPIClient{
+			CoreClient:  &sdkTesting.MockCoreClient{},
+			AuthnClient: &sdkTesting.MockAuthnClient{},
+		},
+		metricsExporterConfig{CollectionMode: collectionModePoll},
+	)
+	registry := prometheus.NewRegistry()
+	require.NoError(t, registry.Register(exporter))
+	labels := prometheus.Labels{"project": "italian"}
+	exporter.oldestPendingAges.With(labels).Set(1)
+	reset := make(chan struct{})
+	repopulate := make(chan struct{})
+	published := make(chan struct{})
+	go func() {
+		defer close(published)
+		exporter.publish(func() {
+			exporter.oldestPendingAges.Reset()
+			close(reset)
+			<-repopulate
+			exporter.oldestPendingAges.With(labels).Set(2)
+		})
+	}()
+	<-reset
+	gathered := make(chan float64)
+	go func() {
+		defer close(gathered)
+		families, err := registry.Gather()
+		assert.NoError(t, err)
+		for _, family := range families {
+			if family.GetName() == "brigade_oldest_pending_event_age_seconds" {
+				gathered <- family.Metric[0].GetGauge().GetValue()
+			}
+		}
+	}()
+	// The scrape must wait for the gauge to be repopulated
+	select {
+	case <-gathered:
+		require.Fail(t, "scrape observed a partially populated gauge")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(repopulate)
+	<-published
+	require.Equal(t, float64(2), <-gathered)
+}
+
+func TestRunCollector(t *testing.T) {
+	exporter := newMetricsExporter(
+		&sdkTesting.MockAPIClient{
+			CoreClient:  &sdkTesting.MockCoreClient{},
+			AuthnClient: &sdkTesting.MockAuthnClient{},
+		},
+		metricsExporterConfig{},
+	)
+	var failing bool
+	projects := collector{
+		name: "projects",
+		recordFn: func(context.Context) error {
+			if failing {
+				return errors.New("something went wrong")
+			}
+			return nil
+		},
+	}
+
+	// Successful run
+	exporter.runCollector(context.Background(), projects)
+	assert.Equal(
+		t,
+		0.0,
+		testutil.ToFloat64(
+			exporter.scrapeErrors.WithLabelValues("projects", errorClassError),
+		),
+	)
+	lastSuccess :=
+		testutil.ToFloat64(exporter.lastSuccesses.WithLabelValues("projects"))
+	assert.NotZero(t, lastSuccess)
+	assert.Equal(t, 1, testutil.CollectAndCount(exporter.scrapeDurations))
+	// Not every collector has run yet, so the exporter cannot be considered up
+	assert.Equal(t, 0.0, testutil.ToFloat64(exporter.upGauge))
+
+	// Run every collector successfully
+	for _, c := range exporter.collectors() {
+		c.recordFn = projects.recordFn
+		exporter.runCollector(context.Background(), c)
+	}
+	assert.Equal(t, 1.0, testutil.ToFloat64(exporter.upGauge))
+	lastSuccess =
+		testutil.ToFloat64(exporter.lastSuccesses.WithLabelValues("projects"))
+
+	// Failed run
+	failing = true
+	exporter.runCollector(context.Background(), projects)
+	assert.Equal(
+		t,
+		1.0,
+		testutil.ToFloat64(
+			exporter.scrapeErrors.WithLabelValues("projects", errorClassError),
+		),
+	)
+	assert.Equal(
+		t,
+		lastSuccess,
+		testutil.ToFloat64(exporter.lastSuccesses.WithLabelValues("projects")),
+	)
+	assert.Equal(t, 0.0, testutil.ToFloat64(exporter.upGauge))
+}
+
+func TestRunCollectorTimeout(t *testing.T) {
+	exporter := newMetricsExporter(
+		&sdkTesting.MockAPIClient{
+			CoreClient: &sdkTesting.MockCoreClient{
+				ProjectsClient: &sdkTesting.MockProjectsClient{
+					ListFn: func(
+						ctx context.Context,
+						_ *sdk.ProjectsSelector,
+						_ *meta.ListOptions,
+					) (sdk.ProjectList, error) {
+						// Simulate an API server that never responds
+						<-ctx.Done()
+						return sdk.ProjectList{}, ctx.Err()
+					},
+				},
+			},
+			AuthnClient: &sdkTesting.MockAuthnClient{},
+		},
+		metricsExporterConfig{
+			APIRequestTimeout: time.Millisecond,
+		},
+	)
+	exporter.runCollector(
+		context.Background(),
+		collector{
+			name:     "projects",
+			recordFn: exporter.recordProjectsCount,
+		},
+	)
+	assert.Equal(
+		t,
+		1.0,
+		testutil.ToFloat64(
+			exporter.scrapeErrors.WithLabelValues("projects", errorClassTimeout),
+		),
+	)
+	assert.Equal(
+		t,
+		0.0,
+		testutil.ToFloat64(
+			exporter.scrapeErrors.WithLabelValues("projects", errorClassError),
+		),
+	)
+
+	// Errors caused by the exporter shutting down should not be counted
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	exporter.runCollector(
+		ctx,
+		collector{
+			name:     "projects",
+			recordFn: exporter.recordProjectsCount,
+		},
+	)
+	assert.Equal(
+		t,
+		0.0,
+		testutil.ToFloat64(
+			exporter.scrapeErrors.WithLabelValues("projects", errorClassError),
+		),
+	)
+}
+
+func TestRunCollectorBackoff(t *testing.T) {
+	exporter := newMetricsExporter(
+		&sdkTesting.MockAPIClient{
+			CoreClient:  &sdkTesting.MockCoreClient{},
+			AuthnClient: &sdkTesting.MockAuthnClient{},
+		},
+		metricsExporterConfig{
+			ScrapeInterval:     time.Second,
+			BackoffMaxInterval: 3 * time.Second,
+		},
+	)
+	var failing bool
+	projects := collector{
+		name: "projects",
+		recordFn: func(context.Context) error {
+			if failing {
+				return errors.New("something went wrong")
+			}
+			return nil
+		},
+	}
+	backoff := func() float64 {
+		return testutil.ToFloat64(exporter.backoffs.WithLabelValues("projects"))
+	}
+
+	// Successful runs don't back off
+	require.Equal(
+		t,
+		time.Second,
+		exporter.runCollector(context.Background(), projects),
+	)
+	require.Zero(t, backoff())
+
+	// Each consecutive failure doubles the delay, less up to 20% jitter
+	failing = true
+	delay := exporter.runCollector(context.Background(), projects)
+	require.GreaterOrEqual(t, delay, 1600*time.Millisecond)
+	require.LessOrEqual(t, delay, 2*time.Second)
+	require.Equal(t, delay.Seconds(), backoff())
+	delay = exporter.runCollector(context.Background(), projects)
+	require.GreaterOrEqual(t, delay, 2400*time.Millisecond)
+	require.LessOrEqual(t, delay, 3*time.Second)
+	require.Equal(t, delay, exporter.collectorBackoff("projects"))
+	// But never exceeds the maximum
+	delay = exporter.runCollector(context.Background(), projects)
+	require.LessOrEqual(t, delay, 3*time.Second)
+
+	// Success resets the backoff
+	failing = false
+	require.Equal(
+		t,
+		time.Second,
+		exporter.runCollector(context.Background(), projects),
+	)
+	require.Zero(t, backoff())
+	require.Zero(t, exporter.collectorBackoff("projects"))
+}
+
+func TestRecordMetricZeroInterval(t *testing.T) {
+	exporter := newMetricsExporter(
+		&sdkTesting.MockAPIClient{
+			CoreClient:  &sdkTesting.MockCoreClient{},
+			AuthnClient: &sdkTesting.MockAuthnClient{},
+		},
+		metricsExporterConfig{},
+	)
+	var runs int32
+	projects := collector{
+		name: "projects",
+		recordFn: func(context.Context) error {
+			atomic.AddInt32(&runs, 1)
+			return nil
+		},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), minPollDelay/2)
+	defer cancel()
+	exporter.recordMetric(ctx, projects)
+	// The collector runs right away, but without a minimum delay, it would have
+	// run continuously thereafter
+	require.Equal(t, int32(1), atomic.LoadInt32(&runs))
 }
 
 func TestRecordProjectsCount(t *testing.T) {
@@ -92,7 +566,7 @@ func TestRecordProjectsCount(t *testing.T) {
 	}
 	for _, testCase := range testCases {
 		t.Run(testCase.name, func(t *testing.T) {
-			err := testCase.exporter.recordProjectsCount()
+			err := testCase.exporter.recordProjectsCount(context.Background())
 			testCase.assertions(testCase.exporter, err)
 		})
 	}
@@ -157,7 +631,7 @@ func TestRecordUsersCount(t *testing.T) {
 	}
 	for _, testCase := range testCases {
 		t.Run(testCase.name, func(t *testing.T) {
-			err := testCase.exporter.recordUsersCount()
+			err := testCase.exporter.recordUsersCount(context.Background())
 			testCase.assertions(testCase.exporter, err)
 		})
 	}
@@ -223,7 +697,7 @@ func TestRecordServiceAccountsCount(t *testing.T) {
 	}
 	for _, testCase := range testCases {
 		t.Run(testCase.name, func(t *testing.T) {
-			err := testCase.exporter.recordServiceAccountsCount()
+			err := testCase.exporter.recordServiceAccountsCount(context.Background())
 			testCase.assertions(testCase.exporter, err)
 		})
 	}
@@ -315,22 +789,72 @@ func TestRecordEventCountsByWorkersPhase(t *testing.T) {
 	}
 	for _, testCase := range testCases {
 		t.Run(testCase.name, func(t *testing.T) {
-			err := testCase.exporter.recordEventCountsByWorkersPhase()
+			err := testCase.exporter.recordEventCountsByWorkersPhase(
+				context.Background(),
+			)
 			testCase.assertions(testCase.exporter, err)
 		})
 	}
 }
 
-func TestRecordPendingJobsCount(t *testing.T) {
+func TestRecordProjectEventCountsByWorkersPhase(t *testing.T) {
 	testCases := []struct {
 		name       string
 		exporter   *metricsExporter
 		assertions func(*metricsExporter, error)
 	}{
+		{
+			name: "error listing projects",
+			exporter: &metricsExporter{
+				coreClient: &sdkTesting.MockCoreClient{
+					ProjectsClient: &sdkTesting.MockProjectsClient{
+						ListFn: func(
+							context.Context,
+							*sdk.ProjectsSelector,
+							*meta.ListOptions,
+						) (sdk.ProjectList, error) {
+							return sdk.ProjectList{}, errors.New("something went wrong")
+						},
+					},
+				},
+				projectWorkersByPhase: prometheus.NewGaugeVec(
+					prometheus.GaugeOpts{
+						Name: "brigade_project_events_by_worker_phase",
+					},
+					[]string{"project", "workerPhase"},
+				),
+			},
+			assertions: func(exporter *metricsExporter, err error) {
+				require.Error(t, err)
+				require.Equal(t, "something went wrong", err.Error())
+				require.Equal(
+					t,
+					0,
+					testutil.CollectAndCount(exporter.projectWorkersByPhase),
+				)
+			},
+		},
 		{
 			name: "error listing events",
 			exporter: &metricsExporter{
 				coreClient: &sdkTesting.MockCoreClient{
+					ProjectsClient: &sdkTesting.MockProjectsClient{
+						ListFn: func(
+							context.Context,
+							*sdk.ProjectsSelector,
+							*meta.ListOptions,
+						) (sdk.ProjectList, error) {
+							return sdk.ProjectList{
+								Items: []sdk.Project{
+									{
+										ObjectMeta: meta.ObjectMeta{
+											ID: "italian",
+										},
+									},
+								},
+							}, nil
+						},
+					},
 					EventsClient: &sdkTesting.MockEventsClient{
 						ListFn: func(
 							context.Context,
@@ -341,54 +865,1195 @@ func TestRecordPendingJobsCount(t *testing.T) {
 						},
 					},
 				},
-				pendingJobsGauge: prometheus.NewGauge(prometheus.GaugeOpts{}),
+				projectWorkersByPhase: prometheus.NewGaugeVec(
+					prometheus.GaugeOpts{
+						Name: "brigade_project_events_by_worker_phase",
+					},
+					[]string{"project", "workerPhase"},
+				),
 			},
 			assertions: func(exporter *metricsExporter, err error) {
 				require.Error(t, err)
 				require.Equal(t, "something went wrong", err.Error())
-				assert.Equal(t, 0.0, testutil.ToFloat64(exporter.pendingJobsGauge))
+				require.Equal(
+					t,
+					0,
+					testutil.CollectAndCount(exporter.projectWorkersByPhase),
+				)
 			},
 		},
 		{
 			name: "success",
 			exporter: &metricsExporter{
 				coreClient: &sdkTesting.MockCoreClient{
+					ProjectsClient: &sdkTesting.MockProjectsClient{
+						ListFn: func(
+							_ context.Context,
+							_ *sdk.ProjectsSelector,
+							opts *meta.ListOptions,
+						) (sdk.ProjectList, error) {
+							// Return one project per page across two pages
+							if opts.Continue == "" {
+								return sdk.ProjectList{
+									ListMeta: meta.ListMeta{
+										Continue: "tunisian",
+									},
+									Items: []sdk.Project{
+										{
+											ObjectMeta: meta.ObjectMeta{
+												ID: "italian",
+											},
+										},
+									},
+								}, nil
+							}
+							return sdk.ProjectList{
+								Items: []sdk.Project{
+									{
+										ObjectMeta: meta.ObjectMeta{
+											ID: opts.Continue,
+										},
+									},
+								},
+							}, nil
+						},
+					},
 					EventsClient: &sdkTesting.MockEventsClient{
 						ListFn: func(
-							context.Context,
-							*sdk.EventsSelector,
-							*meta.ListOptions,
+							_ context.Context,
+							selector *sdk.EventsSelector,
+							_ *meta.ListOptions,
 						) (sdk.EventList, error) {
-							return sdk.EventList{
+							list := sdk.EventList{
 								Items: []sdk.Event{
 									{
-										Worker: &sdk.Worker{
-											Jobs: []sdk.Job{
-												{ // 1 pending job
-													Status: &sdk.JobStatus{
-														Phase: sdk.JobPhasePending,
-													},
-												},
-											},
-										},
+										ProjectID: selector.ProjectID,
 									},
 								},
-							}, nil
+							}
+							// The second project has more events waiting to be run
+							if selector.ProjectID == "tunisian" &&
+								selector.WorkerPhases[0] == sdk.WorkerPhasePending {
+								list.RemainingItemCount = 2
+							}
+							return list, nil
 						},
 					},
 				},
-				pendingJobsGauge: prometheus.NewGauge(prometheus.GaugeOpts{}),
+				projectWorkersByPhase: prometheus.NewGaugeVec(
+					prometheus.GaugeOpts{
+						Name: "brigade_project_events_by_worker_phase",
+					},
+					[]string{"project", "workerPhase"},
+				),
 			},
 			assertions: func(exporter *metricsExporter, err error) {
 				require.NoError(t, err)
-				assert.Equal(t, 1.0, testutil.ToFloat64(exporter.pendingJobsGauge))
+				require.Equal(
+					t,
+					2*len(sdk.WorkerPhasesAll()),
+					testutil.CollectAndCount(exporter.projectWorkersByPhase),
+				)
+				for _, project := range []string{"italian", "tunisian"} {
+					for _, phase := range sdk.WorkerPhasesAll() {
+						expected := 1.0
+						if project == "tunisian" && phase == sdk.WorkerPhasePending {
+							expected = 3.0
+						}
+						assert.Equal(
+							t,
+							expected,
+							testutil.ToFloat64(
+								exporter.projectWorkersByPhase.With(
+									prometheus.Labels{
+										"project":     project,
+										"workerPhase": string(phase),
+									},
+								),
+							),
+						)
+					}
+				}
 			},
 		},
 	}
 	for _, testCase := range testCases {
 		t.Run(testCase.name, func(t *testing.T) {
-			err := testCase.exporter.recordPendingJobsCount()
+			err := testCase.exporter.recordProjectEventCountsByWorkersPhase(
+				context.Background(),
+			)
 			testCase.assertions(testCase.exporter, err)
 		})
 	}
 }
+
+func TestRecordJobCountsByPhase(t *testing.T) {
+	testCases := []struct {
+		name       string
+		exporter   *metricsExporter
+		assertions func(*metricsExporter, error)
+	}{
+		{
+			name: "error listing events",
+			exporter: &metricsExporter{
+				coreClient: &sdkTesting.MockCoreClient{
+					EventsClient: &sdkTesting.MockEventsClient{
+						ListFn: func(
+							context.Context,
+							*sdk.EventsSelector,
+							*meta.ListOptions,
+						) (sdk.EventList, error) {
+							return sdk.EventList{}, errors.New("something went wrong")
+						},
+					},
+				},
+				jobsByPhase: prometheus.NewGaugeVec(
+					prometheus.GaugeOpts{
+						Name: "brigade_jobs_by_phase",
+					},
+					[]string{"phase", "project"},
+				),
+			},
+			assertions: func(exporter *metricsExporter, err error) {
+				require.Error(t, err)
+				require.Equal(t, "something went wrong", err.Error())
+				require.Equal(t, 0, testutil.CollectAndCount(exporter.jobsByPhase))
+			},
+		},
+		{
+			name: "success",
+			exporter: &metricsExporter{
+				coreClient: &sdkTesting.MockCoreClient{
+					EventsClient: &sdkTesting.MockEventsClient{
+						ListFn: func(
+							context.Context,
+							*sdk.EventsSelector,
+							*meta.ListOptions,
+						) (sdk.EventList, error) {
+							return sdk.EventList{
+								Items: []sdk.Event{
+									{
+										ProjectID: "italian",
+										Worker: &sdk.Worker{
+											Jobs: []sdk.Job{
+												{ // 1 pending job
+													Status: &sdk.JobStatus{
+														Phase: sdk.JobPhasePending,
+													},
+												},
+												{ // 1 running job
+													Status: &sdk.JobStatus{
+														Phase: sdk.JobPhaseRunning,
+													},
+												},
+											},
+										},
+									},
+									{
+										ProjectID: "italian",
+										Worker: &sdk.Worker{
+											Jobs: []sdk.Job{
+												{ // Another running job
+													Status: &sdk.JobStatus{
+														Phase: sdk.JobPhaseRunning,
+													},
+												},
+											},
+										},
+									},
+									{
+										ProjectID: "tunisian",
+										Worker: &sdk.Worker{
+											Jobs: []sdk.Job{
+												{ // 1 failed job
+													Status: &sdk.JobStatus{
+														Phase: sdk.JobPhaseFailed,
+													},
+												},
+											},
+										},
+									},
+								},
+							}, nil
+						},
+					},
+				},
+				jobsByPhase: prometheus.NewGaugeVec(
+					prometheus.GaugeOpts{
+						Name: "brigade_jobs_by_phase",
+					},
+					[]string{"phase", "project"},
+				),
+			},
+			assertions: func(exporter *metricsExporter, err error) {
+				require.NoError(t, err)
+				// Every phase should be reported for both projects
+				require.Equal(
+					t,
+					2*len(jobPhasesAll()),
+					testutil.CollectAndCount(exporter.jobsByPhase),
+				)
+				expected := map[string]map[sdk.JobPhase]float64{
+					"italian": {
+						sdk.JobPhasePending: 1,
+						sdk.JobPhaseRunning: 2,
+					},
+					"tunisian": {
+						sdk.JobPhaseFailed: 1,
+					},
+				}
+				for project, phaseCounts := range expected {
+					for _, phase := range jobPhasesAll() {
+						assert.Equal(
+							t,
+							phaseCounts[phase],
+							testutil.ToFloat64(
+								exporter.jobsByPhase.With(
+									prometheus.Labels{
+										"phase":   string(phase),
+										"project": project,
+									},
+								),
+							),
+						)
+					}
+				}
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			err := testCase.exporter.recordJobCountsByPhase(context.Background())
+			testCase.assertions(testCase.exporter, err)
+		})
+	}
+}
+
+func TestAllowedLabelValue(t *testing.T) {
+	pattern, err := compileLabelPattern("brigade.sh/.*")
+	require.NoError(t, err)
+	require.Equal(t, "foo", allowedLabelValue(nil, "foo"))
+	require.Equal(
+		t,
+		"brigade.sh/github",
+		allowedLabelValue(pattern, "brigade.sh/github"),
+	)
+	// Patterns must match the entire value
+	require.Equal(
+		t,
+		otherLabelValue,
+		allowedLabelValue(pattern, "example.com/brigade.sh/github"),
+	)
+}
+
+func TestRecordEventCountsBySource(t *testing.T) {
+	newExporter := func(
+		eventsClient sdk.EventsClient,
+		config metricsExporterConfig,
+	) *metricsExporter {
+		return &metricsExporter{
+			config: config,
+			coreClient: &sdkTesting.MockCoreClient{
+				EventsClient: eventsClient,
+			},
+			eventsBySource: prometheus.NewGaugeVec(
+				prometheus.GaugeOpts{
+					Name: "brigade_events_total",
+				},
+				[]string{"source", "type", "project"},
+			),
+			sourceEventsByPhase: prometheus.NewGaugeVec(
+				prometheus.GaugeOpts{
+					Name: "brigade_source_events_by_worker_phase",
+				},
+				[]string{"source", "type", "project", "workerPhase"},
+			),
+		}
+	}
+	newEvent := func(
+		source string,
+		eventType string,
+		phase sdk.WorkerPhase,
+	) sdk.Event {
+		return sdk.Event{
+			ProjectID: "italian",
+			Source:    source,
+			Type:      eventType,
+			Worker: &sdk.Worker{
+				Status: sdk.WorkerStatus{Phase: phase},
+			},
+		}
+	}
+	eventsClient := &sdkTesting.MockEventsClient{
+		ListFn: func(
+			context.Context,
+			*sdk.EventsSelector,
+			*meta.ListOptions,
+		) (sdk.EventList, error) {
+			return sdk.EventList{
+				Items: []sdk.Event{
+					newEvent("brigade.sh/github", "push", sdk.WorkerPhaseSucceeded),
+					newEvent("brigade.sh/github", "push", sdk.WorkerPhaseRunning),
+					newEvent("brigade.sh/github", "issue", sdk.WorkerPhaseFailed),
+					newEvent("brigade.sh/cli", "exec", sdk.WorkerPhaseSucceeded),
+				},
+			}, nil
+		},
+	}
+	testCases := []struct {
+		name       string
+		exporter   *metricsExporter
+		assertions func(*metricsExporter, error)
+	}{
+		{
+			name: "error listing events",
+			exporter: newExporter(
+				&sdkTesting.MockEventsClient{
+					ListFn: func(
+						context.Context,
+						*sdk.EventsSelector,
+						*meta.ListOptions,
+					) (sdk.EventList, error) {
+						return sdk.EventList{}, errors.New("something went wrong")
+					},
+				},
+				metricsExporterConfig{},
+			),
+			assertions: func(exporter *metricsExporter, err error) {
+				require.Error(t, err)
+				require.Equal(t, "something went wrong", err.Error())
+				require.Equal(t, 0, testutil.CollectAndCount(exporter.eventsBySource))
+			},
+		},
+		{
+			name:     "no patterns",
+			exporter: newExporter(eventsClient, metricsExporterConfig{}),
+			assertions: func(exporter *metricsExporter, err error) {
+				require.NoError(t, err)
+				require.Equal(t, 3, testutil.CollectAndCount(exporter.eventsBySource))
+				require.Equal(
+					t,
+					2.0,
+					testutil.ToFloat64(
+						exporter.eventsBySource.WithLabelValues(
+							"brigade.sh/github",
+							"push",
+							"italian",
+						),
+					),
+				)
+				require.Equal(
+					t,
+					4,
+					testutil.CollectAndCount(exporter.sourceEventsByPhase),
+				)
+				require.Equal(
+					t,
+					1.0,
+					testutil.ToFloat64(
+						exporter.sourceEventsByPhase.WithLabelValues(
+							"brigade.sh/github",
+							"push",
+							"italian",
+							string(sdk.WorkerPhaseRunning),
+						),
+					),
+				)
+			},
+		},
+		{
+			name: "patterns",
+			exporter: newExporter(
+				eventsClient,
+				metricsExporterConfig{
+					EventSourcePattern: regexp.MustCompile("^brigade.sh/github$"),
+					EventTypePattern:   regexp.MustCompile("^push$"),
+				},
+			),
+			assertions: func(exporter *metricsExporter, err error) {
+				require.NoError(t, err)
+				require.Equal(t, 3, testutil.CollectAndCount(exporter.eventsBySource))
+				for labels, expected := range map[[2]string]float64{
+					{"brigade.sh/github", "push"}:  2,
+					{"brigade.sh/github", "other"}: 1,
+					{"other", "other"}:             1,
+				} {
+					require.Equal(
+						t,
+						expected,
+						testutil.ToFloat64(
+							exporter.eventsBySource.WithLabelValues(
+								labels[0],
+								labels[1],
+								"italian",
+							),
+						),
+					)
+				}
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			err := testCase.exporter.recordEventCountsBySource(context.Background())
+			testCase.assertions(testCase.exporter, err)
+		})
+	}
+}
+
+func TestRecordDurations(t *testing.T) {
+	start := time.Now()
+	before := start.Add(-time.Minute)
+	created := start.Add(57 * time.Second)
+	started := start.Add(time.Minute)
+	ended := started.Add(5 * time.Second)
+	newExporter := func(eventsClient sdk.EventsClient) *metricsExporter {
+		return &metricsExporter{
+			coreClient: &sdkTesting.MockCoreClient{
+				EventsClient: eventsClient,
+			},
+			workerDurations: prometheus.NewHistogramVec(
+				prometheus.HistogramOpts{
+					Name:    "brigade_worker_duration_seconds",
+					Help:    "The duration of finished workers",
+					Buckets: []float64{1, 10},
+				},
+				[]string{"project", "workerPhase"},
+			),
+			jobDurations: prometheus.NewHistogramVec(
+				prometheus.HistogramOpts{
+					Name:    "brigade_job_duration_seconds",
+					Help:    "The duration of finished jobs",
+					Buckets: []float64{1, 10},
+				},
+				[]string{"project", "jobPhase"},
+			),
+			observed: newDedupeStore(time.Hour, start),
+			config: metricsExporterConfig{
+				Collectors: map[string]collectorConfig{
+					collectorQueue: {Disabled: true},
+				},
+			},
+		}
+	}
+	testCases := []struct {
+		name       string
+		exporter   *metricsExporter
+		assertions func(*metricsExporter, error)
+	}{
+		{
+			name: "error listing events",
+			exporter: newExporter(
+				&sdkTesting.MockEventsClient{
+					ListFn: func(
+						context.Context,
+						*sdk.EventsSelector,
+						*meta.ListOptions,
+					) (sdk.EventList, error) {
+						return sdk.EventList{}, errors.New("something went wrong")
+					},
+				},
+			),
+			assertions: func(exporter *metricsExporter, err error) {
+				require.Error(t, err)
+				require.Equal(t, "something went wrong", err.Error())
+				require.Equal(t, 0, testutil.CollectAndCount(exporter.workerDurations))
+				require.Equal(t, 0, testutil.CollectAndCount(exporter.jobDurations))
+			},
+		},
+		{
+			name: "success",
+			exporter: newExporter(
+				&sdkTesting.MockEventsClient{
+					ListFn: func(
+						context.Context,
+						*sdk.EventsSelector,
+						*meta.ListOptions,
+					) (sdk.EventList, error) {
+						return sdk.EventList{
+							Items: []sdk.Event{
+								{ // A finished Worker with one finished Job
+									ObjectMeta: meta.ObjectMeta{
+										ID:      "foo",
+										Created: &created,
+									},
+									ProjectID: "italian",
+									Worker: &sdk.Worker{
+										Status: sdk.WorkerStatus{
+											Phase:   sdk.WorkerPhaseSucceeded,
+											Started: &started,
+											Ended:   &ended,
+										},
+										Jobs: []sdk.Job{
+											{
+												Name: "build",
+												Status: &sdk.JobStatus{
+													Phase:   sdk.JobPhaseSucceeded,
+													Started: &started,
+													Ended:   &ended,
+												},
+											},
+										},
+									},
+								},
+								{ // A running Worker with one finished and one running Job
+									ObjectMeta: meta.ObjectMeta{
+										ID:      "bar",
+										Created: &created,
+									},
+									ProjectID: "italian",
+									Worker: &sdk.Worker{
+										Status: sdk.WorkerStatus{
+											Phase:   sdk.WorkerPhaseRunning,
+											Started: &started,
+										},
+										Jobs: []sdk.Job{
+											{
+												Name: "build",
+												Status: &sdk.JobStatus{
+													Phase:   sdk.JobPhaseFailed,
+													Started: &started,
+													Ended:   &ended,
+												},
+											},
+											{
+												Name: "test",
+												Status: &sdk.JobStatus{
+													Phase:   sdk.JobPhaseRunning,
+													Started: &started,
+												},
+											},
+										},
+									},
+								},
+								{ // A Worker that finished before the exporter started
+									ObjectMeta: meta.ObjectMeta{
+										ID:      "bat",
+										Created: &before,
+									},
+									ProjectID: "italian",
+									Worker: &sdk.Worker{
+										Status: sdk.WorkerStatus{
+											Phase:   sdk.WorkerPhaseFailed,
+											Started: &before,
+											Ended:   &before,
+										},
+									},
+								},
+							},
+						}, nil
+					},
+				},
+			),
+			assertions: func(exporter *metricsExporter, err error) {
+				require.NoError(t, err)
+				// Recording a second time must not observe anything again
+				require.NoError(t, exporter.recordDurations(context.Background()))
+				// The Worker that finished before the exporter started and the Worker
+				// that is still running must not have been observed
+				require.Equal(t, 1, testutil.CollectAndCount(exporter.workerDurations))
+				count, sum := histogramSamples(
+					t,
+					exporter.workerDurations,
+					prometheus.Labels{
+						"project":     "italian",
+						"workerPhase": string(sdk.WorkerPhaseSucceeded),
+					},
+				)
+				assert.Equal(t, uint64(1), count)
+				assert.Equal(t, 5.0, sum)
+				// The Job that is still running must not have been observed
+				require.Equal(t, 2, testutil.CollectAndCount(exporter.jobDurations))
+				for _, phase := range []sdk.JobPhase{
+					sdk.JobPhaseSucceeded,
+					sdk.JobPhaseFailed,
+				} {
+					count, sum = histogramSamples(
+						t,
+						exporter.jobDurations,
+						prometheus.Labels{
+							"project":  "italian",
+							"jobPhase": string(phase),
+						},
+					)
+					assert.Equal(t, uint64(1), count)
+					assert.Equal(t, 5.0, sum)
+				}
+			},
+		},
+		{
+			name: "listing stops at events too old to matter",
+			exporter: func() *metricsExporter {
+				var pages int
+				exporter := newExporter(
+					&sdkTesting.MockEventsClient{
+						ListFn: func(
+							context.Context,
+							*sdk.EventsSelector,
+							*meta.ListOptions,
+						) (sdk.EventList, error) {
+							pages++
+							if pages > 1 {
+								return sdk.EventList{}, errors.New("listed too much")
+							}
+							return sdk.EventList{
+								ListMeta: meta.ListMeta{Continue: "more"},
+								Items: []sdk.Event{
+									{ // Created too long ago to have finished recently
+										ObjectMeta: meta.ObjectMeta{
+											ID:      "bat",
+											Created: &before,
+										},
+										ProjectID: "italian",
+										Worker: &sdk.Worker{
+											Status: sdk.WorkerStatus{
+												Phase:   sdk.WorkerPhaseSucceeded,
+												Started: &started,
+												Ended:   &ended,
+											},
+										},
+									},
+								},
+							}, nil
+						},
+					},
+				)
+				exporter.config.MaxWorkerLifetime = 30 * time.Second
+				return exporter
+			}(),
+			assertions: func(exporter *metricsExporter, err error) {
+				require.NoError(t, err)
+				require.Equal(t, 0, testutil.CollectAndCount(exporter.workerDurations))
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			err := testCase.exporter.recordDurations(context.Background())
+			testCase.assertions(testCase.exporter, err)
+		})
+	}
+}
+
+func TestRecordQueueWaits(t *testing.T) {
+	start := time.Now()
+	before := start.Add(-time.Minute)
+	created := start.Add(57 * time.Second)
+	started := start.Add(time.Minute)
+	newExporter := func(eventsClient sdk.EventsClient) *metricsExporter {
+		return &metricsExporter{
+			coreClient: &sdkTesting.MockCoreClient{
+				EventsClient: eventsClient,
+			},
+			queueWaits: prometheus.NewHistogramVec(
+				prometheus.HistogramOpts{
+					Name: "brigade_event_queue_wait_seconds",
+					Help: "The time between the creation of an event and the " +
+						"start of its worker",
+					Buckets: []float64{1, 10},
+				},
+				[]string{"project"},
+			),
+			observed: newDedupeStore(time.Hour, start),
+			config: metricsExporterConfig{
+				Collectors: map[string]collectorConfig{
+					collectorDurations: {Disabled: true},
+				},
+			},
+		}
+	}
+	testCases := []struct {
+		name       string
+		exporter   *metricsExporter
+		assertions func(*metricsExporter, error)
+	}{
+		{
+			name: "error listing events",
+			exporter: newExporter(
+				&sdkTesting.MockEventsClient{
+					ListFn: func(
+						context.Context,
+						*sdk.EventsSelector,
+						*meta.ListOptions,
+					) (sdk.EventList, error) {
+						return sdk.EventList{}, errors.New("something went wrong")
+					},
+				},
+			),
+			assertions: func(exporter *metricsExporter, err error) {
+				require.Error(t, err)
+				require.Equal(t, "something went wrong", err.Error())
+				require.Equal(t, 0, testutil.CollectAndCount(exporter.queueWaits))
+			},
+		},
+		{
+			name: "success",
+			exporter: newExporter(
+				&sdkTesting.MockEventsClient{
+					ListFn: func(
+						context.Context,
+						*sdk.EventsSelector,
+						*meta.ListOptions,
+					) (sdk.EventList, error) {
+						return sdk.EventList{
+							Items: []sdk.Event{
+								{ // A finished Worker
+									ObjectMeta: meta.ObjectMeta{
+										ID:      "foo",
+										Created: &created,
+									},
+									ProjectID: "italian",
+									Worker: &sdk.Worker{
+										Status: sdk.WorkerStatus{
+											Phase:   sdk.WorkerPhaseSucceeded,
+											Started: &started,
+										},
+									},
+								},
+								{ // A running Worker
+									ObjectMeta: meta.ObjectMeta{
+										ID:      "bar",
+										Created: &created,
+									},
+									ProjectID: "italian",
+									Worker: &sdk.Worker{
+										Status: sdk.WorkerStatus{
+											Phase:   sdk.WorkerPhaseRunning,
+											Started: &started,
+										},
+									},
+								},
+								{ // A Worker that started before the exporter started
+									ObjectMeta: meta.ObjectMeta{
+										ID:      "bat",
+										Created: &before,
+									},
+									ProjectID: "italian",
+									Worker: &sdk.Worker{
+										Status: sdk.WorkerStatus{
+											Phase:   sdk.WorkerPhaseFailed,
+											Started: &before,
+										},
+									},
+								},
+							},
+						}, nil
+					},
+				},
+			),
+			assertions: func(exporter *metricsExporter, err error) {
+				require.NoError(t, err)
+				// Recording a second time must not observe anything again
+				require.NoError(t, exporter.recordQueueWaits(context.Background()))
+				// Both Workers that started after the exporter started should have had
+				// their queue wait observed
+				require.Equal(t, 1, testutil.CollectAndCount(exporter.queueWaits))
+				count, sum := histogramSamples(
+					t,
+					exporter.queueWaits,
+					prometheus.Labels{"project": "italian"},
+				)
+				assert.Equal(t, uint64(2), count)
+				assert.Equal(t, 6.0, sum)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			err := testCase.exporter.recordQueueWaits(context.Background())
+			testCase.assertions(testCase.exporter, err)
+		})
+	}
+}
+
+func TestObservationRetention(t *testing.T) {
+	testCases := []struct {
+		name      string
+		config    metricsExporterConfig
+		retention time.Duration
+	}{
+		{
+			name: "short intervals",
+			config: metricsExporterConfig{
+				ScrapeInterval:     5 * time.Second,
+				BackoffMaxInterval: 5 * time.Minute,
+			},
+			retention: minObservationRetention,
+		},
+		{
+			name: "long durations interval",
+			config: metricsExporterConfig{
+				ScrapeInterval:     5 * time.Second,
+				BackoffMaxInterval: 5 * time.Minute,
+				Collectors: map[string]collectorConfig{
+					collectorDurations: {Interval: time.Hour},
+				},
+			},
+			retention: 2*time.Hour + 10*time.Minute,
+		},
+		{
+			name: "long queue interval",
+			config: metricsExporterConfig{
+				ScrapeInterval: 5 * time.Second,
+				Collectors: map[string]collectorConfig{
+					collectorDurations: {Interval: time.Hour},
+					collectorQueue:     {Interval: 2 * time.Hour},
+				},
+			},
+			retention: 4 * time.Hour,
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			exporter := &metricsExporter{config: testCase.config}
+			require.Equal(t, testCase.retention, exporter.observationRetention())
+		})
+	}
+}
+
+func TestStartedWorkerPhases(t *testing.T) {
+	phases := startedWorkerPhases()
+	require.Contains(t, phases, sdk.WorkerPhaseRunning)
+	require.Contains(t, phases, sdk.WorkerPhaseSchedulingFailed)
+	for _, phase := range sdk.WorkerPhasesTerminal() {
+		require.Contains(t, phases, phase)
+	}
+	require.NotContains(t, phases, sdk.WorkerPhasePending)
+	require.NotContains(t, phases, sdk.WorkerPhaseStarting)
+}
+
+func TestObserveRecentWorkers(t *testing.T) {
+	created := time.Now()
+	started := created.Add(3 * time.Second)
+	ended := started.Add(5 * time.Second)
+	var listCalls int
+	listErr := errors.New("something went wrong")
+	exporter := newMetricsExporter(
+		&sdkTesting.MockAPIClient{
+			CoreClient: &sdkTesting.MockCoreClient{
+				EventsClient: &sdkTesting.MockEventsClient{
+					ListFn: func(
+						context.Context,
+						*sdk.EventsSelector,
+						*meta.ListOptions,
+					) (sdk.EventList, error) {
+						listCalls++
+						if listErr != nil {
+							return sdk.EventList{}, listErr
+						}
+						return sdk.EventList{
+							Items: []sdk.Event{
+								{
+									ObjectMeta: meta.ObjectMeta{
+										ID:      "123",
+										Created: &created,
+									},
+									ProjectID: "italian",
+									Worker: &sdk.Worker{
+										Status: sdk.WorkerStatus{
+											Phase:   sdk.WorkerPhaseSucceeded,
+											Started: &started,
+											Ended:   &ended,
+										},
+									},
+								},
+							},
+						}, nil
+					},
+				},
+			},
+			AuthnClient: &sdkTesting.MockAuthnClient{},
+		},
+		metricsExporterConfig{},
+	)
+	// A failed listing must not spare the queue collector from listing
+	require.Error(t, exporter.recordDurations(context.Background()))
+	listErr = nil
+	require.NoError(t, exporter.recordQueueWaits(context.Background()))
+	require.Equal(t, 2, listCalls)
+	// A single listing should have observed both durations and queue waits
+	require.Equal(t, 1, testutil.CollectAndCount(exporter.workerDurations))
+	require.Equal(t, 1, testutil.CollectAndCount(exporter.queueWaits))
+	// The listing made on behalf of the queue collector should have served the
+	// durations collector
+	require.NoError(t, exporter.recordDurations(context.Background()))
+	require.Equal(t, 2, listCalls)
+	// But the durations collector's next run should list again, and serve the
+	// queue collector
+	require.NoError(t, exporter.recordDurations(context.Background()))
+	require.Equal(t, 3, listCalls)
+	require.NoError(t, exporter.recordQueueWaits(context.Background()))
+	require.Equal(t, 3, listCalls)
+}
+
+func TestRecordOldestPendingEventAges(t *testing.T) {
+	now := time.Now()
+	older := now.Add(-time.Hour)
+	newer := now.Add(-time.Minute)
+	testCases := []struct {
+		name       string
+		exporter   *metricsExporter
+		assertions func(*metricsExporter, error)
+	}{
+		{
+			name: "error listing events",
+			exporter: &metricsExporter{
+				coreClient: &sdkTesting.MockCoreClient{
+					EventsClient: &sdkTesting.MockEventsClient{
+						ListFn: func(
+							context.Context,
+							*sdk.EventsSelector,
+							*meta.ListOptions,
+						) (sdk.EventList, error) {
+							return sdk.EventList{}, errors.New("something went wrong")
+						},
+					},
+				},
+				oldestPendingAges: prometheus.NewGaugeVec(
+					prometheus.GaugeOpts{
+						Name: "brigade_oldest_pending_event_age_seconds",
+					},
+					[]string{"project"},
+				),
+			},
+			assertions: func(exporter *metricsExporter, err error) {
+				require.Error(t, err)
+				require.Equal(t, "something went wrong", err.Error())
+				require.Equal(
+					t,
+					0,
+					testutil.CollectAndCount(exporter.oldestPendingAges),
+				)
+			},
+		},
+		{
+			name: "success",
+			exporter: &metricsExporter{
+				coreClient: &sdkTesting.MockCoreClient{
+					EventsClient: &sdkTesting.MockEventsClient{
+						ListFn: func(
+							_ context.Context,
+							selector *sdk.EventsSelector,
+							_ *meta.ListOptions,
+						) (sdk.EventList, error) {
+							require.Equal(
+								t,
+								[]sdk.WorkerPhase{sdk.WorkerPhasePending},
+								selector.WorkerPhases,
+							)
+							return sdk.EventList{
+								Items: []sdk.Event{
+									{
+										ObjectMeta: meta.ObjectMeta{Created: &newer},
+										ProjectID:  "italian",
+									},
+									{
+										ObjectMeta: meta.ObjectMeta{Created: &older},
+										ProjectID:  "italian",
+									},
+									{
+										ObjectMeta: meta.ObjectMeta{Created: &newer},
+										ProjectID:  "tunisian",
+									},
+								},
+							}, nil
+						},
+					},
+				},
+				oldestPendingAges: prometheus.NewGaugeVec(
+					prometheus.GaugeOpts{
+						Name: "brigade_oldest_pending_event_age_seconds",
+					},
+					[]string{"project"},
+				),
+			},
+			assertions: func(exporter *metricsExporter, err error) {
+				require.NoError(t, err)
+				require.Equal(
+					t,
+					2,
+					testutil.CollectAndCount(exporter.oldestPendingAges),
+				)
+				for project, expected := range map[string]time.Duration{
+					"italian":  time.Hour,
+					"tunisian": time.Minute,
+				} {
+					assert.InDelta(
+						t,
+						expected.Seconds(),
+						testutil.ToFloat64(
+							exporter.oldestPendingAges.With(
+								prometheus.Labels{"project": project},
+							),
+						),
+						5,
+					)
+				}
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			err :=
+				testCase.exporter.recordOldestPendingEventAges(context.Background())
+			testCase.assertions(testCase.exporter, err)
+		})
+	}
+}
+
+func TestRecordRunningWorkersCount(t *testing.T) {
+	testCases := []struct {
+		name       string
+		exporter   *metricsExporter
+		assertions func(*metricsExporter, error)
+	}{
+		{
+			name: "error counting running workers",
+			exporter: &metricsExporter{
+				substrateClient: &sdkTesting.MockSubstrateClient{
+					CountRunningWorkersFn: func(
+						context.Context,
+						*sdk.RunningWorkerCountOptions,
+					) (sdk.SubstrateWorkerCount, error) {
+						return sdk.SubstrateWorkerCount{},
+							errors.New("something went wrong")
+					},
+				},
+				runningWorkersGauge: prometheus.NewGauge(prometheus.GaugeOpts{}),
+				saturationGauge:     prometheus.NewGauge(prometheus.GaugeOpts{}),
+			},
+			assertions: func(exporter *metricsExporter, err error) {
+				require.Error(t, err)
+				require.Equal(t, "something went wrong", err.Error())
+				require.Equal(
+					t,
+					0.0,
+					testutil.ToFloat64(exporter.runningWorkersGauge),
+				)
+			},
+		},
+		{
+			name: "success; concurrency limit unknown",
+			exporter: &metricsExporter{
+				substrateClient: &sdkTesting.MockSubstrateClient{
+					CountRunningWorkersFn: func(
+						context.Context,
+						*sdk.RunningWorkerCountOptions,
+					) (sdk.SubstrateWorkerCount, error) {
+						return sdk.SubstrateWorkerCount{Count: 3}, nil
+					},
+				},
+				runningWorkersGauge: prometheus.NewGauge(prometheus.GaugeOpts{}),
+				saturationGauge:     prometheus.NewGauge(prometheus.GaugeOpts{}),
+			},
+			assertions: func(exporter *metricsExporter, err error) {
+				require.NoError(t, err)
+				require.Equal(
+					t,
+					3.0,
+					testutil.ToFloat64(exporter.runningWorkersGauge),
+				)
+				require.Equal(t, 0.0, testutil.ToFloat64(exporter.saturationGauge))
+			},
+		},
+		{
+			name: "success; concurrency limit known",
+			exporter: &metricsExporter{
+				config: metricsExporterConfig{
+					MaxConcurrentWorkers: 4,
+				},
+				substrateClient: &sdkTesting.MockSubstrateClient{
+					CountRunningWorkersFn: func(
+						context.Context,
+						*sdk.RunningWorkerCountOptions,
+					) (sdk.SubstrateWorkerCount, error) {
+						return sdk.SubstrateWorkerCount{Count: 3}, nil
+					},
+				},
+				runningWorkersGauge: prometheus.NewGauge(prometheus.GaugeOpts{}),
+				saturationGauge:     prometheus.NewGauge(prometheus.GaugeOpts{}),
+			},
+			assertions: func(exporter *metricsExporter, err error) {
+				require.NoError(t, err)
+				require.Equal(
+					t,
+					3.0,
+					testutil.ToFloat64(exporter.runningWorkersGauge),
+				)
+				require.Equal(t, 0.75, testutil.ToFloat64(exporter.saturationGauge))
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			err := testCase.exporter.recordRunningWorkersCount(context.Background())
+			testCase.assertions(testCase.exporter, err)
+		})
+	}
+}
+
+func TestRecordRunningJobsCount(t *testing.T) {
+	testCases := []struct {
+		name       string
+		exporter   *metricsExporter
+		assertions func(*metricsExporter, error)
+	}{
+		{
+			name: "error counting running jobs",
+			exporter: &metricsExporter{
+				substrateClient: &sdkTesting.MockSubstrateClient{
+					CountRunningJobsFn: func(
+						context.Context,
+						*sdk.RunningJobCountOptions,
+					) (sdk.SubstrateJobCount, error) {
+						return sdk.SubstrateJobCount{}, errors.New("something went wrong")
+					},
+				},
+				runningJobsGauge: prometheus.NewGauge(prometheus.GaugeOpts{}),
+			},
+			assertions: func(exporter *metricsExporter, err error) {
+				require.Error(t, err)
+				require.Equal(t, "something went wrong", err.Error())
+				require.Equal(t, 0.0, testutil.ToFloat64(exporter.runningJobsGauge))
+			},
+		},
+		{
+			name: "success",
+			exporter: &metricsExporter{
+				substrateClient: &sdkTesting.MockSubstrateClient{
+					CountRunningJobsFn: func(
+						context.Context,
+						*sdk.RunningJobCountOptions,
+					) (sdk.SubstrateJobCount, error) {
+						return sdk.SubstrateJobCount{Count: 5}, nil
+					},
+				},
+				runningJobsGauge: prometheus.NewGauge(prometheus.GaugeOpts{}),
+			},
+			assertions: func(exporter *metricsExporter, err error) {
+				require.NoError(t, err)
+				require.Equal(t, 5.0, testutil.ToFloat64(exporter.runningJobsGauge))
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			err := testCase.exporter.recordRunningJobsCount(context.Background())
+			testCase.assertions(testCase.exporter, err)
+		})
+	}
+}
+
+// histogramSamples returns the sample count and sum of the histogram having
+// the specified labels.
+func histogramSamples(
+	t *testing.T,
+	vec *prometheus.HistogramVec,
+	labels prometheus.Labels,
+) (uint64, float64) {
+	metric, ok := vec.With(labels).(prometheus.Metric)
+	require.True(t, ok)
+	m := &dto.Metric{}
+	require.NoError(t, metric.Write(m))
+	return m.GetHistogram().GetSampleCount(), m.GetHistogram().GetSampleSum()
+}
@@ -0,0 +1,391 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"time"
+
+	"github.com/brigadecore/brigade-foundations/version"
+	"github.com/pkg/errors"
+	dto "github.com/prometheus/client_model/go"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+)
+
+// otlpProtocol represents a transport for pushing metrics via the OpenTelemetry
+// Protocol (OTLP).
+type otlpProtocol string
+
+const (
+	// otlpProtocolGRPC is an otlpProtocol wherein metrics are pushed using
+	// gRPC.
+	otlpProtocolGRPC otlpProtocol = "grpc"
+	// otlpProtocolHTTP is an otlpProtocol wherein metrics are pushed as
+	// protobuf-encoded HTTP requests.
+	otlpProtocolHTTP otlpProtocol = "http/protobuf"
+)
+
+// otlpScopeName is the name of the instrumentation scope of all metrics
+// pushed via OTLP.
+const otlpScopeName = "github.com/brigadecore/brigade-metrics/exporter"
+
+// otlpHTTPPath is the path to which metrics are pushed when using
+// otlpProtocolHTTP and the configured endpoint specifies no path of its own.
+const otlpHTTPPath = "/v1/metrics"
+
+// otlpConfig represents configuration for pushing metrics to an OpenTelemetry
+// collector, or anything else that accepts OTLP.
+type otlpConfig struct {
+	// Enabled specifies whether metrics should be pushed via OTLP.
+	Enabled bool
+	// Protocol specifies the transport used to push metrics.
+	Protocol otlpProtocol
+	// Endpoint specifies where metrics are pushed. When Protocol is
+	// otlpProtocolGRPC, this is a host and port. When Protocol is
+	// otlpProtocolHTTP, this is a URL. If the URL has no path, otlpHTTPPath is
+	// used.
+	Endpoint string
+	// Insecure specifies whether a gRPC connection should be established
+	// without TLS. It has no effect when Protocol is otlpProtocolHTTP, in which
+	// case the scheme of the Endpoint decides.
+	Insecure bool
+	// Headers specifies additional headers (or gRPC metadata) to send with
+	// every push, e.g. for authentication.
+	Headers map[string]string
+	// Interval specifies how often metrics are pushed.
+	Interval time.Duration
+	// Timeout specifies the maximum amount of time any single push may take.
+	Timeout time.Duration
+	// ResourceAttributes specifies additional attributes of the resource that
+	// pushed metrics describe. These are added to, and can override, the
+	// attributes that identify the exporter and Brigade installation.
+	ResourceAttributes map[string]string
+}
+
+// otlpSink is a metricsSink that pushes metrics via OTLP.
+type otlpSink struct {
+	resource *resourcepb.Resource
+	// startTime is reported as the start time of all cumulative metrics.
+	startTime time.Time
+	// export sends a request using the configured transport.
+	export func(context.Context, *colmetricspb.ExportMetricsServiceRequest) error
+	// closeFn, if non-nil, releases any resources held by the transport.
+	closeFn func() error
+}
+
+// newOTLPSink returns an otlpSink that pushes metrics as configured. The
+// resource that pushed metrics describe is identified by the provided
+// attributes, with any from the configuration added on top.
+func newOTLPSink(
+	config otlpConfig,
+	resourceAttributes map[string]string,
+) (*otlpSink, error) {
+	attributes := map[string]string{}
+	for k, v := range resourceAttributes {
+		attributes[k] = v
+	}
+	for k, v := range config.ResourceAttributes {
+		attributes[k] = v
+	}
+	o := &otlpSink{
+		resource: &resourcepb.Resource{
+			Attributes: otlpAttributes(attributes),
+		},
+		startTime: time.Now(),
+	}
+	switch config.Protocol {
+	case otlpProtocolGRPC:
+		creds := credentials.NewTLS(&tls.Config{MinVersion: tls.VersionTLS12})
+		if config.Insecure {
+			creds = insecure.NewCredentials()
+		}
+		conn, err := grpc.Dial(
+			config.Endpoint,
+			grpc.WithTransportCredentials(creds),
+		)
+		if err != nil {
+			return nil, errors.Wrapf(
+				err,
+				"error connecting to OTLP endpoint %s",
+				config.Endpoint,
+			)
+		}
+		client := colmetricspb.NewMetricsServiceClient(conn)
+		o.export = func(
+			ctx context.Context,
+			req *colmetricspb.ExportMetricsServiceRequest,
+		) error {
+			if len(config.Headers) > 0 {
+				ctx = metadata.NewOutgoingContext(ctx, metadata.New(config.Headers))
+			}
+			_, err := client.Export(ctx, req)
+			return err
+		}
+		o.closeFn = conn.Close
+	case otlpProtocolHTTP:
+		endpoint, err := url.Parse(config.Endpoint)
+		if err != nil {
+			return nil, errors.Wrapf(
+				err,
+				"error parsing OTLP endpoint %s",
+				config.Endpoint,
+			)
+		}
+		if endpoint.Path == "" || endpoint.Path == "/" {
+			endpoint.Path = otlpHTTPPath
+		}
+		client := &http.Client{}
+		o.export = func(
+			ctx context.Context,
+			req *colmetricspb.ExportMetricsServiceRequest,
+		) error {
+			return otlpHTTPExport(ctx, client, endpoint.String(), config.Headers, req)
+		}
+	default:
+		return nil, errors.Errorf("unsupported OTLP protocol %q", config.Protocol)
+	}
+	return o, nil
+}
+
+// push implements metricsSink.
+func (o *otlpSink) push(
+	ctx context.Context,
+	families []*dto.MetricFamily,
+) error {
+	return o.export(ctx, o.request(families, time.Now()))
+}
+
+// Close implements io.Closer.
+func (o *otlpSink) Close() error {
+	if o.closeFn == nil {
+		return nil
+	}
+	return o.closeFn()
+}
+
+// request converts the provided metric families into an OTLP export request
+// describing their values as of the specified time.
+func (o *otlpSink) request(
+	families []*dto.MetricFamily,
+	now time.Time,
+) *colmetricspb.ExportMetricsServiceRequest {
+	startNanos := uint64(o.startTime.UnixNano())
+	nowNanos := uint64(now.UnixNano())
+	metrics := make([]*metricspb.Metric, 0, len(families))
+	for _, family := range families {
+		if metric := otlpMetric(family, startNanos, nowNanos); metric != nil {
+			metrics = append(metrics, metric)
+		}
+	}
+	return &colmetricspb.ExportMetricsServiceRequest{
+		ResourceMetrics: []*metricspb.ResourceMetrics{
+			{
+				Resource: o.resource,
+				ScopeMetrics: []*metricspb.ScopeMetrics{
+					{
+						Scope: &commonpb.InstrumentationScope{
+							Name:    otlpScopeName,
+							Version: version.Version(),
+						},
+						Metrics: metrics,
+					},
+				},
+			},
+		},
+	}
+}
+
+// otlpMetric converts the provided metric family into an OTLP metric. Gauges
+// and untyped metrics become gauges, counters become cumulative monotonic
+// sums, and histograms become cumulative histograms. Summaries, which the
+// exporter doesn't produce, are dropped, in which case nil is returned.
+func otlpMetric(
+	family *dto.MetricFamily,
+	startNanos uint64,
+	nowNanos uint64,
+) *metricspb.Metric {
+	metric := &metricspb.Metric{
+		Name:        family.GetName(),
+		Description: family.GetHelp(),
+	}
+	switch family.GetType() {
+	case dto.MetricType_GAUGE, dto.MetricType_UNTYPED:
+		points := make([]*metricspb.NumberDataPoint, 0, len(family.Metric))
+		for _, m := range family.Metric {
+			value := m.GetGauge().GetValue()
+			if family.GetType() == dto.MetricType_UNTYPED {
+				value = m.GetUntyped().GetValue()
+			}
+			points = append(points, &metricspb.NumberDataPoint{
+				Attributes:   otlpLabelAttributes(m.Label),
+				TimeUnixNano: nowNanos,
+				Value:        &metricspb.NumberDataPoint_AsDouble{AsDouble: value},
+			})
+		}
+		metric.Data = &metricspb.Metric_Gauge{
+			Gauge: &metricspb.Gauge{DataPoints: points},
+		}
+	case dto.MetricType_COUNTER:
+		points := make([]*metricspb.NumberDataPoint, 0, len(family.Metric))
+		for _, m := range family.Metric {
+			points = append(points, &metricspb.NumberDataPoint{
+				Attributes:        otlpLabelAttributes(m.Label),
+				StartTimeUnixNano: startNanos,
+				TimeUnixNano:      nowNanos,
+				Value: &metricspb.NumberDataPoint_AsDouble{
+					AsDouble: m.GetCounter().GetValue(),
+				},
+			})
+		}
+		metric.Data = &metricspb.Metric_Sum{
+			Sum: &metricspb.Sum{
+				DataPoints: points,
+				AggregationTemporality: metricspb.
+					AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE,
+				IsMonotonic: true,
+			},
+		}
+	case dto.MetricType_HISTOGRAM:
+		points := make([]*metricspb.HistogramDataPoint, 0, len(family.Metric))
+		for _, m := range family.Metric {
+			points = append(
+				points,
+				otlpHistogramDataPoint(m, startNanos, nowNanos),
+			)
+		}
+		metric.Data = &metricspb.Metric_Histogram{
+			Histogram: &metricspb.Histogram{
+				DataPoints: points,
+				AggregationTemporality: metricspb.
+					AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE,
+			},
+		}
+	default:
+		return nil
+	}
+	return metric
+}
+
+// otlpHistogramDataPoint converts the provided Prometheus histogram into an
+// OTLP histogram data point. Prometheus buckets are cumulative, while OTLP
+// buckets are not, and OTLP has an explicit overflow bucket in place of
+// Prometheus' implicit +Inf bucket.
+func otlpHistogramDataPoint(
+	m *dto.Metric,
+	startNanos uint64,
+	nowNanos uint64,
+) *metricspb.HistogramDataPoint {
+	histogram := m.GetHistogram()
+	buckets := make([]*dto.Bucket, 0, len(histogram.Bucket))
+	for _, bucket := range histogram.Bucket {
+		if !math.IsInf(bucket.GetUpperBound(), 1) {
+			buckets = append(buckets, bucket)
+		}
+	}
+	sort.Slice(buckets, func(i, j int) bool {
+		return buckets[i].GetUpperBound() < buckets[j].GetUpperBound()
+	})
+	bounds := make([]float64, len(buckets))
+	counts := make([]uint64, len(buckets)+1)
+	var previous uint64
+	for i, bucket := range buckets {
+		bounds[i] = bucket.GetUpperBound()
+		counts[i] = bucket.GetCumulativeCount() - previous
+		previous = bucket.GetCumulativeCount()
+	}
+	counts[len(buckets)] = histogram.GetSampleCount() - previous
+	sum := histogram.GetSampleSum()
+	return &metricspb.HistogramDataPoint{
+		Attributes:        otlpLabelAttributes(m.Label),
+		StartTimeUnixNano: startNanos,
+		TimeUnixNano:      nowNanos,
+		Count:             histogram.GetSampleCount(),
+		Sum:               &sum,
+		BucketCounts:      counts,
+		ExplicitBounds:    bounds,
+	}
+}
+
+// otlpLabelAttributes converts Prometheus labels into OTLP attributes.
+func otlpLabelAttributes(labels []*dto.LabelPair) []*commonpb.KeyValue {
+	attributes := make(map[string]string, len(labels))
+	for _, label := range labels {
+		attributes[label.GetName()] = label.GetValue()
+	}
+	return otlpAttributes(attributes)
+}
+
+// otlpAttributes converts the provided map into OTLP attributes, sorted by key
+// so that the result is deterministic.
+func otlpAttributes(attributes map[string]string) []*commonpb.KeyValue {
+	keys := make([]string, 0, len(attributes))
+	for k := range attributes {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	keyValues := make([]*commonpb.KeyValue, len(keys))
+	for i, k := range keys {
+		keyValues[i] = &commonpb.KeyValue{
+			Key: k,
+			Value: &commonpb.AnyValue{
+				Value: &commonpb.AnyValue_StringValue{
+					StringValue: attributes[k],
+				},
+			},
+		}
+	}
+	return keyValues
+}
+
+// otlpHTTPExport sends the provided request to the specified endpoint using
+// otlpProtocolHTTP.
+func otlpHTTPExport(
+	ctx context.Context,
+	client *http.Client,
+	endpoint string,
+	headers map[string]string,
+	req *colmetricspb.ExportMetricsServiceRequest,
+) error {
+	body, err := proto.Marshal(req)
+	if err != nil {
+		return errors.Wrap(err, "error marshaling OTLP request")
+	}
+	httpReq, err := http.NewRequestWithContext(
+		ctx,
+		http.MethodPost,
+		endpoint,
+		bytes.NewReader(body),
+	)
+	if err != nil {
+		return errors.Wrap(err, "error creating OTLP request")
+	}
+	httpReq.Header.Set("Content-Type", "application/x-protobuf")
+	for k, v := range headers {
+		httpReq.Header.Set(k, v)
+	}
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return errors.Wrap(err, "error sending OTLP request")
+	}
+	defer resp.Body.Close()
+	// Drain the body so the connection can be reused
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return errors.Errorf("received %d from OTLP endpoint", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/require"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+)
+
+// otlpTestFamilies returns one metric family of every type the exporter
+// produces.
+func otlpTestFamilies(t *testing.T) []*dto.MetricFamily {
+	registry := prometheus.NewRegistry()
+	gauge := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{Name: "test_gauge", Help: "A gauge"},
+		[]string{"project"},
+	)
+	gauge.WithLabelValues("italian").Set(3)
+	counter := prometheus.NewCounter(
+		prometheus.CounterOpts{Name: "test_counter", Help: "A counter"},
+	)
+	counter.Add(5)
+	histogram := prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "test_histogram",
+			Help:    "A histogram",
+			Buckets: []float64{1, 10},
+		},
+	)
+	for _, v := range []float64{0.5, 2, 3, 20} {
+		histogram.Observe(v)
+	}
+	registry.MustRegister(gauge, counter, histogram)
+	families, err := registry.Gather()
+	require.NoError(t, err)
+	return families
+}
+
+// otlpTestMetrics returns the metrics from the provided request, indexed by
+// name.
+func otlpTestMetrics(
+	t *testing.T,
+	req *colmetricspb.ExportMetricsServiceRequest,
+) map[string]*metricspb.Metric {
+	require.Len(t, req.ResourceMetrics, 1)
+	require.Len(t, req.ResourceMetrics[0].ScopeMetrics, 1)
+	metrics := map[string]*metricspb.Metric{}
+	for _, metric := range req.ResourceMetrics[0].ScopeMetrics[0].Metrics {
+		metrics[metric.Name] = metric
+	}
+	return metrics
+}
+
+func TestOTLPSinkRequest(t *testing.T) {
+	start := time.Unix(1000, 0)
+	now := start.Add(time.Minute)
+	sink := &otlpSink{startTime: start}
+	metrics := otlpTestMetrics(
+		t,
+		sink.request(otlpTestFamilies(t), now),
+	)
+	require.Len(t, metrics, 3)
+
+	gauge := metrics["test_gauge"].GetGauge()
+	require.NotNil(t, gauge)
+	require.Equal(t, "A gauge", metrics["test_gauge"].Description)
+	require.Len(t, gauge.DataPoints, 1)
+	require.Equal(t, 3.0, gauge.DataPoints[0].GetAsDouble())
+	require.Equal(t, uint64(now.UnixNano()), gauge.DataPoints[0].TimeUnixNano)
+	require.Equal(
+		t,
+		[]*commonpb.KeyValue{
+			{
+				Key: "project",
+				Value: &commonpb.AnyValue{
+					Value: &commonpb.AnyValue_StringValue{StringValue: "italian"},
+				},
+			},
+		},
+		gauge.DataPoints[0].Attributes,
+	)
+
+	sum := metrics["test_counter"].GetSum()
+	require.NotNil(t, sum)
+	require.True(t, sum.IsMonotonic)
+	require.Equal(
+		t,
+		metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE,
+		sum.AggregationTemporality,
+	)
+	require.Len(t, sum.DataPoints, 1)
+	require.Equal(t, 5.0, sum.DataPoints[0].GetAsDouble())
+	require.Equal(
+		t,
+		uint64(start.UnixNano()),
+		sum.DataPoints[0].StartTimeUnixNano,
+	)
+
+	histogram := metrics["test_histogram"].GetHistogram()
+	require.NotNil(t, histogram)
+	require.Len(t, histogram.DataPoints, 1)
+	point := histogram.DataPoints[0]
+	require.Equal(t, uint64(4), point.Count)
+	require.Equal(t, 25.5, point.GetSum())
+	require.Equal(t, []float64{1, 10}, point.ExplicitBounds)
+	// Buckets aren't cumulative and the last counts observations above every
+	// bound
+	require.Equal(t, []uint64{1, 2, 1}, point.BucketCounts)
+}
+
+func TestOTLPSinkHTTP(t *testing.T) {
+	received := make(chan *colmetricspb.ExportMetricsServiceRequest, 1)
+	server := httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			require.Equal(t, http.MethodPost, r.Method)
+			require.Equal(t, otlpHTTPPath, r.URL.Path)
+			require.Equal(
+				t,
+				"application/x-protobuf",
+				r.Header.Get("Content-Type"),
+			)
+			require.Equal(t, "Bearer foo", r.Header.Get("Authorization"))
+			body, err := ioutil.ReadAll(r.Body)
+			require.NoError(t, err)
+			req := &colmetricspb.ExportMetricsServiceRequest{}
+			require.NoError(t, proto.Unmarshal(body, req))
+			received <- req
+			w.WriteHeader(http.StatusOK)
+		}),
+	)
+	defer server.Close()
+	sink, err := newOTLPSink(
+		otlpConfig{
+			Protocol: otlpProtocolHTTP,
+			Endpoint: server.URL,
+			Headers:  map[string]string{"Authorization": "Bearer foo"},
+			ResourceAttributes: map[string]string{
+				"service.name": "overridden",
+			},
+		},
+		map[string]string{
+			"brigade.api.address": "https://brigade.example.com",
+			"service.name":        "brigade-metrics-exporter",
+		},
+	)
+	require.NoError(t, err)
+	defer sink.Close()
+	err = sink.push(context.Background(), otlpTestFamilies(t))
+	require.NoError(t, err)
+	req := <-received
+	require.Equal(
+		t,
+		otlpAttributes(map[string]string{
+			"brigade.api.address": "https://brigade.example.com",
+			"service.name":        "overridden",
+		}),
+		req.ResourceMetrics[0].Resource.Attributes,
+	)
+	require.Len(t, otlpTestMetrics(t, req), 3)
+}
+
+func TestOTLPSinkHTTPError(t *testing.T) {
+	server := httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}),
+	)
+	defer server.Close()
+	sink, err := newOTLPSink(
+		otlpConfig{
+			Protocol: otlpProtocolHTTP,
+			Endpoint: server.URL + "/custom/path",
+		},
+		nil,
+	)
+	require.NoError(t, err)
+	err = sink.push(context.Background(), otlpTestFamilies(t))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "received 503")
+}
+
+// fakeOTLPReceiver is a gRPC OTLP metrics service that records every request
+// it receives.
+type fakeOTLPReceiver struct {
+	colmetricspb.UnimplementedMetricsServiceServer
+	received chan *colmetricspb.ExportMetricsServiceRequest
+	metadata chan metadata.MD
+}
+
+func (f *fakeOTLPReceiver) Export(
+	ctx context.Context,
+	req *colmetricspb.ExportMetricsServiceRequest,
+) (*colmetricspb.ExportMetricsServiceResponse, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	f.metadata <- md
+	f.received <- req
+	return &colmetricspb.ExportMetricsServiceResponse{}, nil
+}
+
+func TestOTLPSinkGRPC(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	receiver := &fakeOTLPReceiver{
+		received: make(chan *colmetricspb.ExportMetricsServiceRequest, 1),
+		metadata: make(chan metadata.MD, 1),
+	}
+	server := grpc.NewServer()
+	colmetricspb.RegisterMetricsServiceServer(server, receiver)
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	defer server.Stop()
+	sink, err := newOTLPSink(
+		otlpConfig{
+			Protocol: otlpProtocolGRPC,
+			Endpoint: listener.Addr().String(),
+			Insecure: true,
+			Headers:  map[string]string{"x-tenant": "foo"},
+		},
+		map[string]string{"service.name": "brigade-metrics-exporter"},
+	)
+	require.NoError(t, err)
+	defer sink.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err = sink.push(ctx, otlpTestFamilies(t))
+	require.NoError(t, err)
+	require.Equal(t, []string{"foo"}, (<-receiver.metadata).Get("x-tenant"))
+	req := <-receiver.received
+	require.Equal(
+		t,
+		otlpAttributes(map[string]string{
+			"service.name": "brigade-metrics-exporter",
+		}),
+		req.ResourceMetrics[0].Resource.Attributes,
+	)
+	require.Len(t, otlpTestMetrics(t, req), 3)
+}
+
+func TestNewOTLPSinkUnsupportedProtocol(t *testing.T) {
+	_, err := newOTLPSink(otlpConfig{Protocol: "foo"}, nil)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "unsupported OTLP protocol")
+}
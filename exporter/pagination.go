@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/brigadecore/brigade/sdk/v3/meta"
+)
+
+// paginationMode represents a strategy for counting the items in a paginated
+// list of resources.
+type paginationMode string
+
+const (
+	// paginationModeRemainingCount is a paginationMode wherein the number of
+	// items in a list is determined by adding the number of items on the first
+	// page to the RemainingItemCount reported by the API server. This requires
+	// only one request per list. If the API server indicates that more pages
+	// exist, but does not report how many items remain, this falls back to
+	// walking all remaining pages.
+	paginationModeRemainingCount paginationMode = "remaining-count"
+	// paginationModeWalk is a paginationMode wherein the number of items in a
+	// list is determined by walking every page of the list. This requires one
+	// request per page, but does not depend upon the API server reporting an
+	// accurate RemainingItemCount.
+	paginationModeWalk paginationMode = "walk"
+)
+
+// pageFn retrieves a single page of a list of resources using the provided
+// context and list options and returns the number of items on that page along
+// with the list's metadata.
+type pageFn func(context.Context, *meta.ListOptions) (int, meta.ListMeta, error)
+
+// pager abstracts the details of paging through lists of resources retrieved
+// from the Brigade API.
+type pager struct {
+	// mode specifies how items are counted. The zero value is treated as
+	// paginationModeRemainingCount.
+	mode paginationMode
+	// pageSize specifies how many items should be requested per page. A value
+	// of zero defers to the API server's default.
+	pageSize int64
+	// requestTimeout specifies the maximum amount of time the retrieval of any
+	// single page may take. A value of zero means no timeout.
+	requestTimeout time.Duration
+}
+
+// forEachPage invokes the provided function once for every page of a list of
+// resources, stopping at the first error.
+func (p pager) forEachPage(ctx context.Context, fn pageFn) error {
+	_, err := p.walk(ctx, "", fn)
+	return err
+}
+
+// count returns the total number of items in a list of resources.
+func (p pager) count(ctx context.Context, fn pageFn) (int, error) {
+	if p.mode == paginationModeWalk {
+		return p.walk(ctx, "", fn)
+	}
+	count, listMeta, err := p.page(ctx, "", fn)
+	if err != nil {
+		return count, err
+	}
+	if listMeta.RemainingItemCount > 0 {
+		return count + int(listMeta.RemainingItemCount), nil
+	}
+	if listMeta.Continue == "" {
+		return count, nil
+	}
+	// The API server indicated there are more pages, but did not tell us how
+	// many items remain, so the only way to find out is to look.
+	remaining, err := p.walk(ctx, listMeta.Continue, fn)
+	return count + remaining, err
+}
+
+// walk invokes the provided function once for every page of a list of
+// resources, beginning with the page indicated by the provided continue value,
+// and returns the total number of items on all pages visited.
+func (p pager) walk(
+	ctx context.Context,
+	continueValue string,
+	fn pageFn,
+) (int, error) {
+	var total int
+	for {
+		count, listMeta, err := p.page(ctx, continueValue, fn)
+		if err != nil {
+			return total, err
+		}
+		total += count
+		if listMeta.Continue == "" {
+			return total, nil
+		}
+		continueValue = listMeta.Continue
+	}
+}
+
+// page retrieves a single page of a list of resources.
+func (p pager) page(
+	ctx context.Context,
+	continueValue string,
+	fn pageFn,
+) (int, meta.ListMeta, error) {
+	ctx, cancel := requestContext(ctx, p.requestTimeout)
+	defer cancel()
+	return fn(
+		ctx,
+		&meta.ListOptions{
+			Continue: continueValue,
+			Limit:    p.pageSize,
+		},
+	)
+}
+
+// requestContext returns a context for a single request to the Brigade API
+// that is canceled when the parent context is canceled or when the specified
+// timeout elapses, whichever comes first. A timeout of zero means no timeout.
+func requestContext(
+	ctx context.Context,
+	timeout time.Duration,
+) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
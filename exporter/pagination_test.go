@@ -0,0 +1,283 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/brigadecore/brigade/sdk/v3"
+	"github.com/brigadecore/brigade/sdk/v3/meta"
+	sdkTesting "github.com/brigadecore/brigade/sdk/v3/testing"
+	"github.com/stretchr/testify/require"
+)
+
+// projectPages returns a pageFn backed by a mock projects client that serves
+// the provided pages, each of which is keyed by the continue value used to
+// request it. Every set of list options received is appended to the provided
+// slice.
+func projectPages(
+	pages map[string]sdk.ProjectList,
+	receivedOpts *[]meta.ListOptions,
+) pageFn {
+	projectsClient := &sdkTesting.MockProjectsClient{
+		ListFn: func(
+			_ context.Context,
+			_ *sdk.ProjectsSelector,
+			opts *meta.ListOptions,
+		) (sdk.ProjectList, error) {
+			*receivedOpts = append(*receivedOpts, *opts)
+			page, ok := pages[opts.Continue]
+			if !ok {
+				return sdk.ProjectList{}, errors.New("something went wrong")
+			}
+			return page, nil
+		},
+	}
+	return func(
+		ctx context.Context,
+		opts *meta.ListOptions,
+	) (int, meta.ListMeta, error) {
+		projects, err := projectsClient.List(ctx, &sdk.ProjectsSelector{}, opts)
+		return len(projects.Items), projects.ListMeta, err
+	}
+}
+
+func TestPagerCount(t *testing.T) {
+	testCases := []struct {
+		name       string
+		pager      pager
+		pages      map[string]sdk.ProjectList
+		assertions func(count int, receivedOpts []meta.ListOptions, err error)
+	}{
+		{
+			name:  "error listing first page",
+			pager: pager{},
+			pages: map[string]sdk.ProjectList{},
+			assertions: func(_ int, _ []meta.ListOptions, err error) {
+				require.Error(t, err)
+				require.Equal(t, "something went wrong", err.Error())
+			},
+		},
+		{
+			name:  "remaining-count mode; single page",
+			pager: pager{mode: paginationModeRemainingCount},
+			pages: map[string]sdk.ProjectList{
+				"": {
+					Items: []sdk.Project{{}, {}},
+				},
+			},
+			assertions: func(count int, opts []meta.ListOptions, err error) {
+				require.NoError(t, err)
+				require.Equal(t, 2, count)
+				require.Len(t, opts, 1)
+			},
+		},
+		{
+			name:  "remaining-count mode; remaining item count reported",
+			pager: pager{mode: paginationModeRemainingCount},
+			pages: map[string]sdk.ProjectList{
+				"": {
+					ListMeta: meta.ListMeta{
+						Continue:           "foo",
+						RemainingItemCount: 3,
+					},
+					Items: []sdk.Project{{}, {}},
+				},
+			},
+			assertions: func(count int, opts []meta.ListOptions, err error) {
+				require.NoError(t, err)
+				require.Equal(t, 5, count)
+				// The remaining pages should not have been requested
+				require.Len(t, opts, 1)
+			},
+		},
+		{
+			name:  "remaining-count mode; remaining item count not reported",
+			pager: pager{mode: paginationModeRemainingCount},
+			pages: map[string]sdk.ProjectList{
+				"": {
+					ListMeta: meta.ListMeta{
+						Continue: "foo",
+					},
+					Items: []sdk.Project{{}, {}},
+				},
+				"foo": {
+					ListMeta: meta.ListMeta{
+						Continue: "bar",
+					},
+					Items: []sdk.Project{{}, {}},
+				},
+				"bar": {
+					Items: []sdk.Project{{}},
+				},
+			},
+			assertions: func(count int, opts []meta.ListOptions, err error) {
+				require.NoError(t, err)
+				require.Equal(t, 5, count)
+				require.Len(t, opts, 3)
+			},
+		},
+		{
+			name:  "remaining-count mode; error listing subsequent page",
+			pager: pager{mode: paginationModeRemainingCount},
+			pages: map[string]sdk.ProjectList{
+				"": {
+					ListMeta: meta.ListMeta{
+						Continue: "foo",
+					},
+					Items: []sdk.Project{{}, {}},
+				},
+			},
+			assertions: func(_ int, _ []meta.ListOptions, err error) {
+				require.Error(t, err)
+				require.Equal(t, "something went wrong", err.Error())
+			},
+		},
+		{
+			name:  "walk mode",
+			pager: pager{mode: paginationModeWalk},
+			pages: map[string]sdk.ProjectList{
+				"": {
+					ListMeta: meta.ListMeta{
+						Continue: "foo",
+						// This is deliberately inaccurate and should be ignored
+						RemainingItemCount: 42,
+					},
+					Items: []sdk.Project{{}, {}},
+				},
+				"foo": {
+					Items: []sdk.Project{{}},
+				},
+			},
+			assertions: func(count int, opts []meta.ListOptions, err error) {
+				require.NoError(t, err)
+				require.Equal(t, 3, count)
+				require.Len(t, opts, 2)
+			},
+		},
+		{
+			name: "page size",
+			pager: pager{
+				mode:     paginationModeWalk,
+				pageSize: 2,
+			},
+			pages: map[string]sdk.ProjectList{
+				"": {
+					ListMeta: meta.ListMeta{
+						Continue: "foo",
+					},
+					Items: []sdk.Project{{}, {}},
+				},
+				"foo": {
+					Items: []sdk.Project{{}},
+				},
+			},
+			assertions: func(count int, opts []meta.ListOptions, err error) {
+				require.NoError(t, err)
+				require.Equal(t, 3, count)
+				require.Equal(
+					t,
+					[]meta.ListOptions{
+						{Limit: 2},
+						{Continue: "foo", Limit: 2},
+					},
+					opts,
+				)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			receivedOpts := []meta.ListOptions{}
+			count, err := testCase.pager.count(
+				context.Background(),
+				projectPages(testCase.pages, &receivedOpts),
+			)
+			testCase.assertions(count, receivedOpts, err)
+		})
+	}
+}
+
+func TestPagerForEachPage(t *testing.T) {
+	testCases := []struct {
+		name       string
+		pager      pager
+		pages      map[string]sdk.ProjectList
+		assertions func(receivedOpts []meta.ListOptions, err error)
+	}{
+		{
+			name:  "error listing a page",
+			pager: pager{},
+			pages: map[string]sdk.ProjectList{
+				"": {
+					ListMeta: meta.ListMeta{
+						Continue: "foo",
+					},
+				},
+			},
+			assertions: func(opts []meta.ListOptions, err error) {
+				require.Error(t, err)
+				require.Equal(t, "something went wrong", err.Error())
+				require.Len(t, opts, 2)
+			},
+		},
+		{
+			name: "success",
+			// Even in remaining-count mode, every page should be visited
+			pager: pager{mode: paginationModeRemainingCount},
+			pages: map[string]sdk.ProjectList{
+				"": {
+					ListMeta: meta.ListMeta{
+						Continue:           "foo",
+						RemainingItemCount: 1,
+					},
+				},
+				"foo": {},
+			},
+			assertions: func(opts []meta.ListOptions, err error) {
+				require.NoError(t, err)
+				require.Equal(
+					t,
+					[]meta.ListOptions{
+						{},
+						{Continue: "foo"},
+					},
+					opts,
+				)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			receivedOpts := []meta.ListOptions{}
+			err := testCase.pager.forEachPage(
+				context.Background(),
+				projectPages(testCase.pages, &receivedOpts),
+			)
+			testCase.assertions(receivedOpts, err)
+		})
+	}
+}
+
+func TestPagerRequestTimeout(t *testing.T) {
+	p := pager{requestTimeout: time.Minute}
+	err := p.forEachPage(
+		context.Background(),
+		func(
+			ctx context.Context,
+			_ *meta.ListOptions,
+		) (int, meta.ListMeta, error) {
+			deadline, ok := ctx.Deadline()
+			require.True(t, ok)
+			require.WithinDuration(
+				t,
+				time.Now().Add(time.Minute),
+				deadline,
+				time.Second,
+			)
+			return 0, meta.ListMeta{}, nil
+		},
+	)
+	require.NoError(t, err)
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/brigadecore/brigade/sdk/v3"
+	"github.com/brigadecore/brigade/sdk/v3/meta"
+	"github.com/pkg/errors"
+)
+
+// preflightMode represents a strategy for verifying, at startup, that the
+// exporter can query the Brigade API.
+type preflightMode string
+
+const (
+	// preflightModeFail is a preflightMode wherein the exporter refuses to
+	// start if it cannot reach the Brigade API, if its token is not accepted,
+	// or if it is not authorized to query everything its enabled collectors
+	// query.
+	preflightModeFail preflightMode = "fail"
+	// preflightModeDegrade is a preflightMode wherein the exporter refuses to
+	// start if it cannot reach the Brigade API or if its token is not accepted,
+	// but starts without any collector that queries something it is not
+	// authorized to query.
+	preflightModeDegrade preflightMode = "degrade"
+	// preflightModeOff is a preflightMode wherein the exporter starts without
+	// verifying anything.
+	preflightModeOff preflightMode = "off"
+)
+
+// preflightRetryInterval is how long preflight waits before retrying a check
+// that failed for the first time. The wait doubles with each consecutive
+// failure until it reaches preflightRetryMaxInterval.
+const (
+	preflightRetryInterval    = time.Second
+	preflightRetryMaxInterval = 15 * time.Second
+)
+
+// preflight verifies, as dictated by the configured PreflightMode, that the
+// Brigade API is reachable, that it accepts the exporter's token, and that
+// the exporter is authorized to list every resource queried by each enabled
+// collector. It must be called before the exporter is started or registered,
+// since it may disable collectors. The API server often starts alongside the
+// exporter, so a failure to reach it is retried for up to the configured
+// PreflightTimeout before the exporter gives up.
+func (m *metricsExporter) preflight(ctx context.Context) error {
+	if m.config.PreflightMode == preflightModeOff {
+		return nil
+	}
+	if err := m.retryPreflightCheck(ctx, "ping", m.ping); err != nil {
+		return errors.Wrap(err, "error pinging the Brigade API server")
+	}
+	var principal sdk.PrincipalReference
+	err := m.retryPreflightCheck(
+		ctx,
+		"whoami",
+		func(ctx context.Context) error {
+			var err error
+			principal, err = m.whoAmI(ctx)
+			return err
+		},
+	)
+	if err != nil {
+		var authnErr *meta.ErrAuthentication
+		if errors.As(err, &authnErr) {
+			return errors.Wrap(
+				err,
+				"the Brigade API server did not accept the API token; check that "+
+					"API_TOKEN is correct and has not been revoked",
+			)
+		}
+		return errors.Wrap(err, "error verifying the API token")
+	}
+	appLogger.info(
+		"authenticated to the Brigade API server",
+		"principalType", principal.Type,
+		"principalID", principal.ID,
+	)
+	// Each resource is checked only once, even if several collectors query it
+	authorized := map[string]bool{}
+	for _, c := range m.enabledCollectors() {
+		for _, resource := range c.resources {
+			if _, ok := authorized[resource]; ok {
+				continue
+			}
+			err := m.checkResource(ctx, resource)
+			var authzErr *meta.ErrAuthorization
+			if err != nil && !errors.As(err, &authzErr) {
+				return errors.Wrapf(err, "error listing %s", resource)
+			}
+			authorized[resource] = err == nil
+		}
+	}
+	unauthorized := map[string]string{}
+	problems := []string{}
+	for _, c := range m.enabledCollectors() {
+		denied := []string{}
+		for _, resource := range c.resources {
+			if !authorized[resource] {
+				denied = append(denied, resource)
+			}
+		}
+		if len(denied) > 0 {
+			problem := fmt.Sprintf(
+				"collector %q is not authorized to list %s",
+				c.name,
+				strings.Join(denied, " or "),
+			)
+			unauthorized[c.name] = problem
+			problems = append(problems, problem)
+		}
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	if m.config.PreflightMode != preflightModeDegrade {
+		return errors.Errorf(
+			"%s %q lacks permissions needed by enabled collectors; grant it the "+
+				"READER role, disable those collectors, or set PREFLIGHT_MODE to %q "+
+				"to disable them automatically:\n  %s",
+			principal.Type,
+			principal.ID,
+			preflightModeDegrade,
+			strings.Join(problems, "\n  "),
+		)
+	}
+	m.disableCollectors(unauthorized)
+	if len(m.enabledCollectors()) == 0 {
+		return errors.Errorf(
+			"%s %q is not authorized to list anything queried by any enabled "+
+				"collector; grant it the READER role",
+			principal.Type,
+			principal.ID,
+		)
+	}
+	return nil
+}
+
+// retryPreflightCheck invokes the provided check until it succeeds, fails in a
+// way that retrying cannot fix, or the configured PreflightTimeout would elapse
+// before the next attempt. Rejection of the exporter's token or of its
+// permissions cannot be fixed by retrying. The error from the final attempt,
+// if any, is returned.
+func (m *metricsExporter) retryPreflightCheck(
+	ctx context.Context,
+	check string,
+	fn func(context.Context) error,
+) error {
+	deadline := time.Now().Add(m.config.PreflightTimeout)
+	for failures := 0; ; failures++ {
+		err := fn(ctx)
+		if err == nil {
+			return nil
+		}
+		var authnErr *meta.ErrAuthentication
+		var authzErr *meta.ErrAuthorization
+		if errors.As(err, &authnErr) || errors.As(err, &authzErr) {
+			return err
+		}
+		delay := backoffDelay(
+			preflightRetryInterval,
+			preflightRetryMaxInterval,
+			failures,
+			rand.Float64(), // nolint: gosec
+		)
+		if time.Now().Add(delay).After(deadline) {
+			return err
+		}
+		appLogger.warn(
+			"preflight check failed; retrying",
+			"check", check,
+			"retryIn", delay,
+			"error", err,
+		)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return err
+		}
+	}
+}
+
+// disableCollectors disables the specified collectors, logging the provided
+// reason for disabling each.
+func (m *metricsExporter) disableCollectors(reasons map[string]string) {
+	// Copy the collector configuration rather than modify what was provided
+	collectors := make(map[string]collectorConfig, len(m.config.Collectors))
+	for name, config := range m.config.Collectors {
+		collectors[name] = config
+	}
+	for _, c := range m.enabledCollectors() {
+		reason, ok := reasons[c.name]
+		if !ok {
+			continue
+		}
+		appLogger.warn("disabling collector", "collector", c.name, "reason", reason)
+		config := collectors[c.name]
+		config.Disabled = true
+		collectors[c.name] = config
+		// Error counts and backoffs were initialized for every enabled collector
+		for _, class := range errorClasses() {
+			m.scrapeErrors.DeleteLabelValues(c.name, class)
+		}
+		m.backoffs.DeleteLabelValues(c.name)
+	}
+	m.config.Collectors = collectors
+}
+
+// ping pings the Brigade API server.
+func (m *metricsExporter) ping(ctx context.Context) error {
+	ctx, cancel := requestContext(ctx, m.config.APIRequestTimeout)
+	defer cancel()
+	_, err := m.systemClient.Ping(ctx, nil)
+	return err
+}
+
+// whoAmI returns a reference to the principal the Brigade API server
+// authenticates the exporter as.
+func (m *metricsExporter) whoAmI(
+	ctx context.Context,
+) (sdk.PrincipalReference, error) {
+	ctx, cancel := requestContext(ctx, m.config.APIRequestTimeout)
+	defer cancel()
+	return m.authnClient.WhoAmI(ctx)
+}
+
+// checkResource makes the least expensive request possible for the specified
+// resource that still requires the same permissions as the requests made by
+// collectors that query it.
+func (m *metricsExporter) checkResource(
+	ctx context.Context,
+	resource string,
+) error {
+	ctx, cancel := requestContext(ctx, m.config.APIRequestTimeout)
+	defer cancel()
+	opts := &meta.ListOptions{Limit: 1}
+	var err error
+	switch resource {
+	case resourceProjects:
+		_, err = m.coreClient.Projects().List(ctx, &sdk.ProjectsSelector{}, opts)
+	case resourceUsers:
+		_, err = m.authnClient.Users().List(ctx, &sdk.UsersSelector{}, opts)
+	case resourceServiceAccounts:
+		_, err = m.authnClient.ServiceAccounts().List(
+			ctx,
+			&sdk.ServiceAccountsSelector{},
+			opts,
+		)
+	case resourceEvents:
+		_, err = m.coreClient.Events().List(ctx, &sdk.EventsSelector{}, opts)
+	case resourceRunningWorkers:
+		_, err = m.substrateClient.CountRunningWorkers(ctx, nil)
+	case resourceRunningJobs:
+		_, err = m.substrateClient.CountRunningJobs(ctx, nil)
+	default:
+		err = errors.Errorf("unknown resource %q", resource)
+	}
+	return err
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/brigadecore/brigade/sdk/v3"
+	"github.com/brigadecore/brigade/sdk/v3/restmachinery"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetricsExporterPreflight(t *testing.T) {
+	testCases := []struct {
+		name       string
+		mode       preflightMode
+		token      string
+		timeout    time.Duration
+		setup      func(*fakeAPI)
+		assertions func(*testing.T, *metricsExporter, *fakeAPI, error)
+	}{
+		{
+			name:  "off",
+			mode:  preflightModeOff,
+			token: "bogus",
+			setup: func(*fakeAPI) {},
+			assertions: func(
+				t *testing.T,
+				_ *metricsExporter,
+				api *fakeAPI,
+				err error,
+			) {
+				require.NoError(t, err)
+				require.Zero(t, api.requestCount("/v2/ping"))
+			},
+		},
+		{
+			name:  "every check passes",
+			mode:  preflightModeFail,
+			token: fakeAPIToken,
+			setup: func(*fakeAPI) {},
+			assertions: func(
+				t *testing.T,
+				exporter *metricsExporter,
+				api *fakeAPI,
+				err error,
+			) {
+				require.NoError(t, err)
+				require.Len(t, exporter.enabledCollectors(), len(collectorNames()))
+				// Events are listed only once, even though several collectors list
+				// them
+				require.Equal(t, 1, api.requestCount("/v2/events"))
+			},
+		},
+		{
+			name:    "API server unavailable",
+			mode:    preflightModeFail,
+			token:   fakeAPIToken,
+			timeout: 1500 * time.Millisecond,
+			setup: func(api *fakeAPI) {
+				api.fail("/v2/ping", http.StatusInternalServerError)
+			},
+			assertions: func(
+				t *testing.T,
+				_ *metricsExporter,
+				api *fakeAPI,
+				err error,
+			) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "error pinging")
+				// The second retry would have been due after the timeout elapsed
+				require.Equal(t, 2, api.requestCount("/v2/ping"))
+			},
+		},
+		{
+			name:    "API server becomes available",
+			mode:    preflightModeFail,
+			token:   fakeAPIToken,
+			timeout: time.Minute,
+			setup: func(api *fakeAPI) {
+				api.fail("/v2/ping", http.StatusInternalServerError)
+				time.AfterFunc(100*time.Millisecond, func() {
+					api.fail("/v2/ping", 0)
+				})
+			},
+			assertions: func(
+				t *testing.T,
+				_ *metricsExporter,
+				api *fakeAPI,
+				err error,
+			) {
+				require.NoError(t, err)
+				require.Equal(t, 2, api.requestCount("/v2/ping"))
+			},
+		},
+		{
+			name:    "token not accepted",
+			mode:    preflightModeDegrade,
+			token:   "bogus",
+			timeout: time.Minute,
+			setup:   func(*fakeAPI) {},
+			assertions: func(
+				t *testing.T,
+				_ *metricsExporter,
+				api *fakeAPI,
+				err error,
+			) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "did not accept the API token")
+				// Rejection of the token is not retried
+				require.Equal(t, 1, api.requestCount("/v2/whoami"))
+			},
+		},
+		{
+			name:  "error listing a resource",
+			mode:  preflightModeDegrade,
+			token: fakeAPIToken,
+			setup: func(api *fakeAPI) {
+				api.fail("/v2/users", http.StatusInternalServerError)
+			},
+			assertions: func(
+				t *testing.T,
+				_ *metricsExporter,
+				_ *fakeAPI,
+				err error,
+			) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "error listing users")
+			},
+		},
+		{
+			name:  "resource not authorized",
+			mode:  preflightModeFail,
+			token: fakeAPIToken,
+			setup: func(api *fakeAPI) {
+				api.fail("/v2/projects", http.StatusForbidden)
+			},
+			assertions: func(
+				t *testing.T,
+				_ *metricsExporter,
+				_ *fakeAPI,
+				err error,
+			) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), `SERVICE_ACCOUNT "brigade-metrics"`)
+				require.Contains(t, err.Error(), "READER")
+				require.Contains(
+					t,
+					err.Error(),
+					`collector "projects" is not authorized to list projects`,
+				)
+				require.Contains(
+					t,
+					err.Error(),
+					`collector "project_events_by_worker_phase" is not authorized `+
+						`to list projects`,
+				)
+				require.NotContains(t, err.Error(), `collector "users"`)
+			},
+		},
+		{
+			name:  "resource not authorized; degrade",
+			mode:  preflightModeDegrade,
+			token: fakeAPIToken,
+			setup: func(api *fakeAPI) {
+				api.fail("/v2/events", http.StatusForbidden)
+			},
+			assertions: func(
+				t *testing.T,
+				exporter *metricsExporter,
+				_ *fakeAPI,
+				err error,
+			) {
+				require.NoError(t, err)
+				enabled := []string{}
+				for _, c := range exporter.enabledCollectors() {
+					enabled = append(enabled, c.name)
+				}
+				require.Equal(
+					t,
+					[]string{
+						collectorProjects,
+						collectorUsers,
+						collectorServiceAccounts,
+						collectorSubstrateWorkers,
+						collectorSubstrateJobs,
+					},
+					enabled,
+				)
+				// Disabled collectors no longer report errors
+				require.Equal(
+					t,
+					len(enabled)*len(errorClasses()),
+					testutil.CollectAndCount(exporter.scrapeErrors),
+				)
+			},
+		},
+		{
+			name:  "nothing authorized; degrade",
+			mode:  preflightModeDegrade,
+			token: fakeAPIToken,
+			setup: func(api *fakeAPI) {
+				for _, path := range []string{
+					"/v2/projects",
+					"/v2/users",
+					"/v2/service-accounts",
+					"/v2/events",
+					"/v2/substrate/running-workers",
+					"/v2/substrate/running-jobs",
+				} {
+					api.fail(path, http.StatusForbidden)
+				}
+			},
+			assertions: func(
+				t *testing.T,
+				_ *metricsExporter,
+				_ *fakeAPI,
+				err error,
+			) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "not authorized to list anything")
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			api := newFakeAPI(t, fakeAPIFixturesForEndToEnd())
+			testCase.setup(api)
+			exporter := newMetricsExporter(
+				sdk.NewAPIClient(
+					api.address(),
+					testCase.token,
+					&restmachinery.APIClientOptions{},
+				),
+				metricsExporterConfig{
+					APIRequestTimeout: time.Second,
+					PreflightMode:     testCase.mode,
+					PreflightTimeout:  testCase.timeout,
+				},
+			)
+			err := exporter.preflight(context.Background())
+			testCase.assertions(t, exporter, api, err)
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// metricsSink is a destination to which metrics are pushed, for consumers
+// that can't, or would rather not, scrape the exporter. A sink that holds
+// resources, such as a connection, may also implement io.Closer, in which case
+// it is closed when the pusher that owns it stops.
+type metricsSink interface {
+	// push sends the provided metric families to the sink.
+	push(ctx context.Context, families []*dto.MetricFamily) error
+}
+
+// pusher periodically gathers metrics and pushes them to a metricsSink.
+type pusher struct {
+	// name identifies the sink in log messages.
+	name     string
+	gatherer prometheus.Gatherer
+	sink     metricsSink
+	// interval specifies how often metrics are pushed.
+	interval time.Duration
+	// timeout specifies the maximum amount of time any single push may take. A
+	// value of zero means no timeout.
+	timeout time.Duration
+}
+
+// run pushes metrics every interval until the provided context is canceled.
+func (p *pusher) run(ctx context.Context) {
+	if closer, ok := p.sink.(io.Closer); ok {
+		defer func() {
+			if err := closer.Close(); err != nil {
+				appLogger.error("error closing sink", "sink", p.name, "error", err)
+			}
+		}()
+	}
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	failures := failureTracker{}
+	for {
+		select {
+		case <-ticker.C:
+			start := time.Now()
+			err := p.pushOnce(ctx)
+			if ctx.Err() != nil {
+				// The exporter is shutting down. This isn't worth reporting.
+				return
+			}
+			appLogger.logOutcome(
+				&failures,
+				"push",
+				err,
+				"sink", p.name,
+				"duration", time.Since(start),
+			)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// pushOnce gathers metrics and pushes them to the sink once.
+func (p *pusher) pushOnce(ctx context.Context) error {
+	families, err := p.gatherer.Gather()
+	if err != nil {
+		// Gather returns as many metrics as it could alongside any error, so
+		// whatever was gathered is still worth pushing
+		appLogger.warn(
+			"error gathering metrics to push",
+			"sink", p.name,
+			"error", err,
+		)
+	}
+	if len(families) == 0 {
+		return nil
+	}
+	ctx, cancel := requestContext(ctx, p.timeout)
+	defer cancel()
+	return p.sink.push(ctx, families)
+}
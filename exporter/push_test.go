@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeMetricsSink is a metricsSink that records what is pushed to it.
+type fakeMetricsSink struct {
+	pushes [][]*dto.MetricFamily
+	err    error
+	closed bool
+	mu     sync.Mutex
+}
+
+func (f *fakeMetricsSink) push(
+	_ context.Context,
+	families []*dto.MetricFamily,
+) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.pushes = append(f.pushes, families)
+	return f.err
+}
+
+func (f *fakeMetricsSink) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.closed = true
+	return nil
+}
+
+func (f *fakeMetricsSink) pushCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.pushes)
+}
+
+func (f *fakeMetricsSink) isClosed() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.closed
+}
+
+func TestPusherPushOnce(t *testing.T) {
+	testCases := []struct {
+		name       string
+		gatherer   func() prometheus.Gatherer
+		sink       *fakeMetricsSink
+		assertions func(*testing.T, *fakeMetricsSink, error)
+	}{
+		{
+			name:     "nothing to push",
+			gatherer: func() prometheus.Gatherer { return prometheus.NewRegistry() },
+			sink:     &fakeMetricsSink{},
+			assertions: func(t *testing.T, sink *fakeMetricsSink, err error) {
+				require.NoError(t, err)
+				require.Equal(t, 0, sink.pushCount())
+			},
+		},
+		{
+			name: "error pushing",
+			gatherer: func() prometheus.Gatherer {
+				registry := prometheus.NewRegistry()
+				registry.MustRegister(
+					prometheus.NewGauge(prometheus.GaugeOpts{Name: "test_gauge"}),
+				)
+				return registry
+			},
+			sink: &fakeMetricsSink{err: errors.New("something went wrong")},
+			assertions: func(t *testing.T, sink *fakeMetricsSink, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "something went wrong")
+			},
+		},
+		{
+			name: "success",
+			gatherer: func() prometheus.Gatherer {
+				registry := prometheus.NewRegistry()
+				registry.MustRegister(
+					prometheus.NewGauge(prometheus.GaugeOpts{Name: "test_gauge"}),
+				)
+				return registry
+			},
+			sink: &fakeMetricsSink{},
+			assertions: func(t *testing.T, sink *fakeMetricsSink, err error) {
+				require.NoError(t, err)
+				require.Len(t, sink.pushes, 1)
+				require.Len(t, sink.pushes[0], 1)
+				require.Equal(t, "test_gauge", sink.pushes[0][0].GetName())
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			p := &pusher{
+				name:     "test sink",
+				gatherer: testCase.gatherer(),
+				sink:     testCase.sink,
+				interval: time.Second,
+			}
+			err := p.pushOnce(context.Background())
+			testCase.assertions(t, testCase.sink, err)
+		})
+	}
+}
+
+func TestPusherRun(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(
+		prometheus.NewGauge(prometheus.GaugeOpts{Name: "test_gauge"}),
+	)
+	sink := &fakeMetricsSink{}
+	p := &pusher{
+		name:     "test sink",
+		gatherer: registry,
+		sink:     sink,
+		interval: 10 * time.Millisecond,
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		p.run(ctx)
+		close(done)
+	}()
+	require.Eventually(
+		t,
+		func() bool { return sink.pushCount() >= 2 },
+		time.Second,
+		10*time.Millisecond,
+	)
+	cancel()
+	<-done
+	// The sink is closed once the pusher stops
+	require.True(t, sink.isClosed())
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// pushgatewayConfig represents configuration for pushing metrics to a
+// Prometheus Pushgateway.
+type pushgatewayConfig struct {
+	// Enabled specifies whether metrics should be pushed to a Pushgateway.
+	Enabled bool
+	// URL specifies the address of the Pushgateway.
+	URL string
+	// Job specifies the job label of pushed metrics.
+	Job string
+	// Grouping specifies labels, in addition to Job, that identify the group of
+	// pushed metrics. Each push replaces every metric in that group.
+	Grouping map[string]string
+	// Headers specifies additional headers to send with every push, e.g. for
+	// authentication.
+	Headers map[string]string
+	// Interval specifies how often metrics are pushed.
+	Interval time.Duration
+	// Timeout specifies the maximum amount of time any single push may take.
+	Timeout time.Duration
+}
+
+// pushgatewaySink is a metricsSink that pushes metrics to a Prometheus
+// Pushgateway.
+type pushgatewaySink struct {
+	config pushgatewayConfig
+	client *http.Client
+}
+
+// newPushgatewaySink returns a pushgatewaySink that pushes metrics as
+// configured.
+func newPushgatewaySink(config pushgatewayConfig) *pushgatewaySink {
+	return &pushgatewaySink{
+		config: config,
+		client: &http.Client{},
+	}
+}
+
+// push implements metricsSink.
+func (p *pushgatewaySink) push(
+	ctx context.Context,
+	families []*dto.MetricFamily,
+) error {
+	pusher := push.New(p.config.URL, p.config.Job).
+		Gatherer(
+			prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+				return families, nil
+			}),
+		).
+		Client(
+			&pushgatewayDoer{
+				ctx:     ctx,
+				client:  p.client,
+				headers: p.config.Headers,
+			},
+		)
+	for name, value := range p.config.Grouping {
+		pusher.Grouping(name, value)
+	}
+	// Push, unlike Add, replaces the entire group, so metrics that the exporter
+	// has stopped reporting don't linger
+	return pusher.Push()
+}
+
+// pushgatewayDoer is a push.HTTPDoer that bounds every request it sends by a
+// context and adds configured headers to it. The push package has no other
+// means of doing either.
+type pushgatewayDoer struct {
+	ctx     context.Context
+	client  *http.Client
+	headers map[string]string
+}
+
+// Do implements push.HTTPDoer.
+func (p *pushgatewayDoer) Do(req *http.Request) (*http.Response, error) {
+	req = req.WithContext(p.ctx)
+	for k, v := range p.headers {
+		req.Header.Set(k, v)
+	}
+	return p.client.Do(req)
+}
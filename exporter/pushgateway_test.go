@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPushgatewaySink(t *testing.T) {
+	testCases := []struct {
+		name       string
+		statusCode int
+		assertions func(*testing.T, *http.Request, string, error)
+	}{
+		{
+			name:       "error pushing",
+			statusCode: http.StatusInternalServerError,
+			assertions: func(t *testing.T, _ *http.Request, _ string, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "500")
+			},
+		},
+		{
+			name:       "success",
+			statusCode: http.StatusOK,
+			assertions: func(
+				t *testing.T,
+				req *http.Request,
+				body string,
+				err error,
+			) {
+				require.NoError(t, err)
+				// The whole group is replaced
+				require.Equal(t, http.MethodPut, req.Method)
+				require.Equal(
+					t,
+					"/metrics/job/brigade-metrics-exporter/cluster/prod",
+					req.URL.Path,
+				)
+				require.Equal(t, "Bearer foo", req.Header.Get("Authorization"))
+				require.Contains(t, body, "brigade_projects_total")
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			var req *http.Request
+			var body []byte
+			server := httptest.NewServer(
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					var err error
+					req = r
+					body, err = ioutil.ReadAll(r.Body)
+					require.NoError(t, err)
+					w.WriteHeader(testCase.statusCode)
+				}),
+			)
+			defer server.Close()
+			registry := prometheus.NewRegistry()
+			registry.MustRegister(
+				prometheus.NewGauge(
+					prometheus.GaugeOpts{Name: "brigade_projects_total"},
+				),
+			)
+			families, err := registry.Gather()
+			require.NoError(t, err)
+			sink := newPushgatewaySink(
+				pushgatewayConfig{
+					URL:      server.URL,
+					Job:      "brigade-metrics-exporter",
+					Grouping: map[string]string{"cluster": "prod"},
+					Headers:  map[string]string{"Authorization": "Bearer foo"},
+				},
+			)
+			err = sink.push(context.Background(), families)
+			testCase.assertions(t, req, string(body), err)
+		})
+	}
+}
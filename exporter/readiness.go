@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// collectorStatus tracks the outcome of recent runs of a single collector.
+type collectorStatus struct {
+	// lastSuccess is when the collector last ran successfully. It is the zero
+	// value if the collector has never succeeded.
+	lastSuccess time.Time
+	// failingSince is when the collector began failing, i.e. the time of its
+	// first failure since it last succeeded. It is the zero value if the most
+	// recent run of the collector succeeded.
+	failingSince time.Time
+	// failures decides which failures of the collector are logged.
+	failures failureTracker
+	// consecutiveFailures is the number of times the collector has failed since
+	// it last succeeded.
+	consecutiveFailures int
+	// backoff is how long the collector waits before its next run while it is
+	// backing off after consecutive failures. It is zero if the collector is
+	// not backing off.
+	backoff time.Duration
+}
+
+// record updates the status to reflect a run of the collector that finished
+// at the specified time with the specified error, which is nil if the run
+// succeeded.
+func (c *collectorStatus) record(err error, now time.Time) {
+	if err == nil {
+		c.lastSuccess = now
+		c.failingSince = time.Time{}
+		c.consecutiveFailures = 0
+		return
+	}
+	c.consecutiveFailures++
+	if c.failingSince.IsZero() {
+		c.failingSince = now
+	}
+}
+
+// readinessReport is the body of a response to a readiness check.
+type readinessReport struct {
+	// Ready indicates whether the exporter is able to collect metrics from the
+	// Brigade API.
+	Ready bool `json:"ready"`
+	// Reason explains why the exporter is not ready.
+	Reason string `json:"reason,omitempty"`
+	// Collectors reports the status of every enabled collector, keyed by
+	// collector name.
+	Collectors map[string]collectorReadiness `json:"collectors"`
+}
+
+// collectorReadiness reports the status of a single collector.
+type collectorReadiness struct {
+	// Healthy indicates whether the most recent run of the collector
+	// succeeded. It is false if the collector has not yet run.
+	Healthy      bool       `json:"healthy"`
+	LastSuccess  *time.Time `json:"lastSuccess,omitempty"`
+	FailingSince *time.Time `json:"failingSince,omitempty"`
+}
+
+// readiness reports whether, as of the specified time, the exporter is ready,
+// along with the status of every enabled collector. The exporter is not ready
+// until at least one collector has succeeded, and is not ready whenever every
+// collector has been failing for longer than the configured
+// ReadinessFailureThreshold. This makes the exporter's readiness reflect
+// whether it can reach the Brigade API and whether its token is still valid.
+func (m *metricsExporter) readiness(now time.Time) readinessReport {
+	m.collectorsUpMu.Lock()
+	defer m.collectorsUpMu.Unlock()
+	report := readinessReport{
+		Collectors: map[string]collectorReadiness{},
+	}
+	enabled := m.enabledCollectors()
+	// With no collectors enabled, there is nothing to wait for, much as
+	// brigade_up is 1 in that case
+	succeeded := len(enabled) == 0
+	allFailing := len(enabled) > 0
+	for _, c := range enabled {
+		readiness := collectorReadiness{}
+		status, ok := m.collectorStatuses[c.name]
+		if !ok {
+			// The collector hasn't run yet
+			report.Collectors[c.name] = readiness
+			allFailing = false
+			continue
+		}
+		if !status.lastSuccess.IsZero() {
+			lastSuccess := status.lastSuccess
+			readiness.LastSuccess = &lastSuccess
+			succeeded = true
+		}
+		if status.failingSince.IsZero() {
+			readiness.Healthy = true
+			allFailing = false
+		} else {
+			failingSince := status.failingSince
+			readiness.FailingSince = &failingSince
+			if now.Sub(failingSince) <= m.config.ReadinessFailureThreshold {
+				allFailing = false
+			}
+		}
+		report.Collectors[c.name] = readiness
+	}
+	switch {
+	case !succeeded:
+		report.Reason = "no collector has succeeded yet"
+	case allFailing:
+		report.Reason = "every collector has been failing for longer than " +
+			m.config.ReadinessFailureThreshold.String()
+	default:
+		report.Ready = true
+	}
+	return report
+}
+
+// readyz is an HTTP handler that reports the exporter's readiness. It responds
+// with a 200 if the exporter is ready and a 503 if it isn't, and either way
+// describes the status of every enabled collector in a JSON body. Since
+// /readyz is never authenticated, errors encountered by collectors are not
+// described; they are logged instead. When the exporter is operating in
+// collectionModeOnDemand, the Brigade API is otherwise queried only when
+// metrics are scraped, so this first refreshes metrics just as a scrape would,
+// subject to the same minimum refresh interval.
+func (m *metricsExporter) readyz(w http.ResponseWriter, _ *http.Request) {
+	if m.config.CollectionMode == collectionModeOnDemand {
+		m.refresh()
+	}
+	report := m.readiness(time.Now())
+	body, err := json.Marshal(report)
+	if err != nil {
+		appLogger.error("error marshaling readiness report", "error", err)
+		http.Error(
+			w,
+			http.StatusText(http.StatusInternalServerError),
+			http.StatusInternalServerError,
+		)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if report.Ready {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	_, _ = w.Write(body)
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	sdkTesting "github.com/brigadecore/brigade/sdk/v3/testing"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCollectorStatusRecord(t *testing.T) {
+	start := time.Now()
+	status := &collectorStatus{}
+	status.record(errors.New("something went wrong"), start)
+	require.Equal(
+		t,
+		collectorStatus{
+			failingSince:        start,
+			consecutiveFailures: 1,
+		},
+		*status,
+	)
+	// Failing again doesn't reset when the collector began failing
+	status.record(errors.New("something else went wrong"), start.Add(time.Minute))
+	require.Equal(
+		t,
+		collectorStatus{
+			failingSince:        start,
+			consecutiveFailures: 2,
+		},
+		*status,
+	)
+	status.record(nil, start.Add(2*time.Minute))
+	require.Equal(
+		t,
+		collectorStatus{lastSuccess: start.Add(2 * time.Minute)},
+		*status,
+	)
+}
+
+func TestMetricsExporterReadiness(t *testing.T) {
+	now := time.Now()
+	testCases := []struct {
+		name       string
+		statuses   map[string]*collectorStatus
+		assertions func(*testing.T, readinessReport)
+	}{
+		{
+			name:     "no collector has run",
+			statuses: map[string]*collectorStatus{},
+			assertions: func(t *testing.T, report readinessReport) {
+				require.False(t, report.Ready)
+				require.Equal(t, "no collector has succeeded yet", report.Reason)
+				require.Equal(
+					t,
+					map[string]collectorReadiness{
+						collectorProjects: {},
+						collectorUsers:    {},
+					},
+					report.Collectors,
+				)
+			},
+		},
+		{
+			name: "no collector has succeeded",
+			statuses: map[string]*collectorStatus{
+				collectorProjects: {
+					failingSince: now.Add(-time.Second),
+				},
+			},
+			assertions: func(t *testing.T, report readinessReport) {
+				require.False(t, report.Ready)
+				require.Equal(t, "no collector has succeeded yet", report.Reason)
+				require.False(t, report.Collectors[collectorProjects].Healthy)
+				require.NotNil(t, report.Collectors[collectorProjects].FailingSince)
+			},
+		},
+		{
+			name: "one collector has succeeded",
+			statuses: map[string]*collectorStatus{
+				collectorProjects: {lastSuccess: now.Add(-time.Second)},
+			},
+			assertions: func(t *testing.T, report readinessReport) {
+				require.True(t, report.Ready)
+				require.Empty(t, report.Reason)
+				require.True(t, report.Collectors[collectorProjects].Healthy)
+				require.False(t, report.Collectors[collectorUsers].Healthy)
+			},
+		},
+		{
+			name: "every collector failing, but not for long",
+			statuses: map[string]*collectorStatus{
+				collectorProjects: {
+					lastSuccess:  now.Add(-2 * time.Minute),
+					failingSince: now.Add(-time.Minute),
+				},
+				collectorUsers: {
+					failingSince: now.Add(-10 * time.Minute),
+				},
+			},
+			assertions: func(t *testing.T, report readinessReport) {
+				require.True(t, report.Ready)
+			},
+		},
+		{
+			name: "one collector failing for long",
+			statuses: map[string]*collectorStatus{
+				collectorProjects: {lastSuccess: now.Add(-time.Second)},
+				collectorUsers: {
+					failingSince: now.Add(-10 * time.Minute),
+				},
+			},
+			assertions: func(t *testing.T, report readinessReport) {
+				require.True(t, report.Ready)
+			},
+		},
+		{
+			name: "every collector failing for long",
+			statuses: map[string]*collectorStatus{
+				collectorProjects: {
+					lastSuccess:  now.Add(-20 * time.Minute),
+					failingSince: now.Add(-10 * time.Minute),
+				},
+				collectorUsers: {
+					failingSince: now.Add(-10 * time.Minute),
+				},
+			},
+			assertions: func(t *testing.T, report readinessReport) {
+				require.False(t, report.Ready)
+				require.Equal(
+					t,
+					"every collector has been failing for longer than 5m0s",
+					report.Reason,
+				)
+				lastSuccess := now.Add(-20 * time.Minute)
+				failingSince := now.Add(-10 * time.Minute)
+				require.Equal(
+					t,
+					collectorReadiness{
+						LastSuccess:  &lastSuccess,
+						FailingSince: &failingSince,
+					},
+					report.Collectors[collectorProjects],
+				)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			// Only the projects and users collectors are enabled
+			collectors := map[string]collectorConfig{}
+			for _, name := range collectorNames() {
+				if name != collectorProjects && name != collectorUsers {
+					collectors[name] = collectorConfig{Disabled: true}
+				}
+			}
+			exporter := newMetricsExporter(
+				&sdkTesting.MockAPIClient{
+					CoreClient:  &sdkTesting.MockCoreClient{},
+					AuthnClient: &sdkTesting.MockAuthnClient{},
+				},
+				metricsExporterConfig{
+					ReadinessFailureThreshold: 5 * time.Minute,
+					Collectors:                collectors,
+				},
+			)
+			exporter.collectorStatuses = testCase.statuses
+			testCase.assertions(t, exporter.readiness(now))
+		})
+	}
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/brigadecore/brigade-foundations/version"
+	"github.com/golang/snappy"
+	"github.com/pkg/errors"
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// remoteWriteConfig represents configuration for sending metrics to a
+// Prometheus remote-write endpoint.
+type remoteWriteConfig struct {
+	// Enabled specifies whether metrics should be sent to a remote-write
+	// endpoint.
+	Enabled bool
+	// URL specifies the address of the remote-write endpoint.
+	URL string
+	// Headers specifies additional headers to send with every request, e.g.
+	// for authentication.
+	Headers map[string]string
+	// Labels specifies labels added to every series sent, much as Prometheus
+	// adds target labels to every series it scrapes. A label that a metric
+	// already has is not overridden.
+	Labels map[string]string
+	// Interval specifies how often metrics are sent.
+	Interval time.Duration
+	// Timeout specifies the maximum amount of time any single request may take.
+	Timeout time.Duration
+}
+
+// remoteWriteSink is a metricsSink that sends metrics to a Prometheus
+// remote-write endpoint.
+type remoteWriteSink struct {
+	config remoteWriteConfig
+	client *http.Client
+}
+
+// newRemoteWriteSink returns a remoteWriteSink that sends metrics as
+// configured.
+func newRemoteWriteSink(config remoteWriteConfig) *remoteWriteSink {
+	return &remoteWriteSink{
+		config: config,
+		client: &http.Client{},
+	}
+}
+
+// remoteWriteLabel is a label of a remoteWriteSeries.
+type remoteWriteLabel struct {
+	name  string
+	value string
+}
+
+// remoteWriteSeries is a single sample of a single time series, as sent to a
+// remote-write endpoint.
+type remoteWriteSeries struct {
+	// labels are sorted by name and include the metric name as __name__.
+	labels      []remoteWriteLabel
+	value       float64
+	timestampMs int64
+}
+
+// push implements metricsSink.
+func (r *remoteWriteSink) push(
+	ctx context.Context,
+	families []*dto.MetricFamily,
+) error {
+	series := remoteWriteSeriesFor(families, r.config.Labels, time.Now())
+	body := snappy.Encode(nil, remoteWriteRequest(series))
+	req, err := http.NewRequestWithContext(
+		ctx,
+		http.MethodPost,
+		r.config.URL,
+		bytes.NewReader(body),
+	)
+	if err != nil {
+		return errors.Wrap(err, "error creating remote-write request")
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("User-Agent", "brigade-metrics-exporter/"+version.Version())
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	for k, v := range r.config.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "error sending remote-write request")
+	}
+	defer resp.Body.Close()
+	// Drain the body so the connection can be reused
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return errors.Errorf(
+			"received %d from remote-write endpoint",
+			resp.StatusCode,
+		)
+	}
+	return nil
+}
+
+// remoteWriteSeriesFor converts the provided metric families into the series
+// Prometheus would have stored had it scraped them at the specified time.
+// Histograms and summaries are broken down into their _bucket (or quantile),
+// _sum, and _count series. The provided extra labels are added to every
+// series.
+func remoteWriteSeriesFor(
+	families []*dto.MetricFamily,
+	extraLabels map[string]string,
+	now time.Time,
+) []remoteWriteSeries {
+	series := []remoteWriteSeries{}
+	for _, family := range families {
+		name := family.GetName()
+		for _, m := range family.Metric {
+			timestampMs := now.UnixNano() / int64(time.Millisecond)
+			if m.TimestampMs != nil {
+				timestampMs = m.GetTimestampMs()
+			}
+			add := func(
+				name string,
+				value float64,
+				extra ...remoteWriteLabel,
+			) {
+				series = append(series, remoteWriteSeries{
+					labels: remoteWriteLabels(
+						name,
+						m.Label,
+						extra,
+						extraLabels,
+					),
+					value:       value,
+					timestampMs: timestampMs,
+				})
+			}
+			switch family.GetType() {
+			case dto.MetricType_COUNTER:
+				add(name, m.GetCounter().GetValue())
+			case dto.MetricType_GAUGE:
+				add(name, m.GetGauge().GetValue())
+			case dto.MetricType_UNTYPED:
+				add(name, m.GetUntyped().GetValue())
+			case dto.MetricType_HISTOGRAM:
+				histogram := m.GetHistogram()
+				var sawInf bool
+				for _, bucket := range histogram.Bucket {
+					sawInf = sawInf || math.IsInf(bucket.GetUpperBound(), 1)
+					add(
+						name+"_bucket",
+						float64(bucket.GetCumulativeCount()),
+						remoteWriteLabel{
+							name:  "le",
+							value: formatFloat(bucket.GetUpperBound()),
+						},
+					)
+				}
+				if !sawInf {
+					add(
+						name+"_bucket",
+						float64(histogram.GetSampleCount()),
+						remoteWriteLabel{name: "le", value: "+Inf"},
+					)
+				}
+				add(name+"_sum", histogram.GetSampleSum())
+				add(name+"_count", float64(histogram.GetSampleCount()))
+			case dto.MetricType_SUMMARY:
+				summary := m.GetSummary()
+				for _, quantile := range summary.Quantile {
+					add(
+						name,
+						quantile.GetValue(),
+						remoteWriteLabel{
+							name:  "quantile",
+							value: formatFloat(quantile.GetQuantile()),
+						},
+					)
+				}
+				add(name+"_sum", summary.GetSampleSum())
+				add(name+"_count", float64(summary.GetSampleCount()))
+			}
+		}
+	}
+	return series
+}
+
+// remoteWriteLabels returns the complete, sorted set of labels for a series
+// having the specified name, the provided metric labels, and any labels
+// specific to the series (e.g. le). The provided extra labels are added only
+// where they don't collide with any other label.
+func remoteWriteLabels(
+	name string,
+	metricLabels []*dto.LabelPair,
+	seriesLabels []remoteWriteLabel,
+	extraLabels map[string]string,
+) []remoteWriteLabel {
+	labels := make(map[string]string, len(metricLabels)+len(extraLabels)+2)
+	for k, v := range extraLabels {
+		labels[k] = v
+	}
+	for _, label := range metricLabels {
+		labels[label.GetName()] = label.GetValue()
+	}
+	for _, label := range seriesLabels {
+		labels[label.name] = label.value
+	}
+	labels["__name__"] = name
+	sorted := make([]remoteWriteLabel, 0, len(labels))
+	for k, v := range labels {
+		sorted = append(sorted, remoteWriteLabel{name: k, value: v})
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].name < sorted[j].name
+	})
+	return sorted
+}
+
+// remoteWriteRequest encodes the provided series as a protobuf
+// prometheus.WriteRequest. Encoding the handful of fields involved by hand
+// avoids depending upon the whole of Prometheus for its generated types.
+func remoteWriteRequest(series []remoteWriteSeries) []byte {
+	var req []byte
+	for _, s := range series {
+		var ts []byte
+		for _, label := range s.labels {
+			var l []byte
+			l = protowire.AppendTag(l, 1, protowire.BytesType)
+			l = protowire.AppendString(l, label.name)
+			l = protowire.AppendTag(l, 2, protowire.BytesType)
+			l = protowire.AppendString(l, label.value)
+			ts = protowire.AppendTag(ts, 1, protowire.BytesType)
+			ts = protowire.AppendBytes(ts, l)
+		}
+		var sample []byte
+		sample = protowire.AppendTag(sample, 1, protowire.Fixed64Type)
+		sample = protowire.AppendFixed64(sample, math.Float64bits(s.value))
+		sample = protowire.AppendTag(sample, 2, protowire.VarintType)
+		sample = protowire.AppendVarint(sample, uint64(s.timestampMs))
+		ts = protowire.AppendTag(ts, 2, protowire.BytesType)
+		ts = protowire.AppendBytes(ts, sample)
+		req = protowire.AppendTag(req, 1, protowire.BytesType)
+		req = protowire.AppendBytes(req, ts)
+	}
+	return req
+}
+
+// formatFloat formats the provided value the same way the Prometheus
+// exposition format does.
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	case math.IsNaN(f):
+		return "NaN"
+	default:
+		return strconv.FormatFloat(f, 'g', -1, 64)
+	}
+}
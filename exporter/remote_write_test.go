@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// decodeRemoteWriteRequest decodes a protobuf prometheus.WriteRequest. It is
+// the inverse of remoteWriteRequest.
+func decodeRemoteWriteRequest(
+	t *testing.T,
+	req []byte,
+) []remoteWriteSeries {
+	// fields calls fn with the number and raw value of every field in b
+	fields := func(b []byte, fn func(protowire.Number, []byte)) {
+		for len(b) > 0 {
+			num, typ, n := protowire.ConsumeTag(b)
+			require.GreaterOrEqual(t, n, 0)
+			b = b[n:]
+			m := protowire.ConsumeFieldValue(num, typ, b)
+			require.GreaterOrEqual(t, m, 0)
+			value := b[:m]
+			if typ == protowire.BytesType {
+				value, _ = protowire.ConsumeBytes(value)
+			}
+			fn(num, value)
+			b = b[m:]
+		}
+	}
+	series := []remoteWriteSeries{}
+	fields(req, func(_ protowire.Number, ts []byte) {
+		s := remoteWriteSeries{}
+		fields(ts, func(num protowire.Number, value []byte) {
+			switch num {
+			case 1:
+				label := remoteWriteLabel{}
+				fields(value, func(num protowire.Number, value []byte) {
+					if num == 1 {
+						label.name = string(value)
+					} else {
+						label.value = string(value)
+					}
+				})
+				s.labels = append(s.labels, label)
+			case 2:
+				fields(value, func(num protowire.Number, value []byte) {
+					if num == 1 {
+						bits, _ := protowire.ConsumeFixed64(value)
+						s.value = math.Float64frombits(bits)
+					} else {
+						v, _ := protowire.ConsumeVarint(value)
+						s.timestampMs = int64(v)
+					}
+				})
+			}
+		})
+		series = append(series, s)
+	})
+	return series
+}
+
+func TestRemoteWriteSeriesFor(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	gauge := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{Name: "test_gauge"},
+		[]string{"project"},
+	)
+	gauge.WithLabelValues("italian").Set(3)
+	histogram := prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "test_histogram",
+			Buckets: []float64{1},
+		},
+	)
+	histogram.Observe(0.5)
+	histogram.Observe(2)
+	registry.MustRegister(gauge, histogram)
+	families, err := registry.Gather()
+	require.NoError(t, err)
+	now := time.Unix(1000, 0)
+	series := remoteWriteSeriesFor(
+		families,
+		// The metric's own project label takes precedence over this one
+		map[string]string{"job": "brigade", "project": "other"},
+		now,
+	)
+	job := remoteWriteLabel{name: "job", value: "brigade"}
+	require.Equal(
+		t,
+		[]remoteWriteSeries{
+			{
+				labels: []remoteWriteLabel{
+					{name: "__name__", value: "test_gauge"},
+					job,
+					{name: "project", value: "italian"},
+				},
+				value:       3,
+				timestampMs: 1000000,
+			},
+			{
+				labels: []remoteWriteLabel{
+					{name: "__name__", value: "test_histogram_bucket"},
+					job,
+					{name: "le", value: "1"},
+					{name: "project", value: "other"},
+				},
+				value:       1,
+				timestampMs: 1000000,
+			},
+			{
+				labels: []remoteWriteLabel{
+					{name: "__name__", value: "test_histogram_bucket"},
+					job,
+					{name: "le", value: "+Inf"},
+					{name: "project", value: "other"},
+				},
+				value:       2,
+				timestampMs: 1000000,
+			},
+			{
+				labels: []remoteWriteLabel{
+					{name: "__name__", value: "test_histogram_sum"},
+					job,
+					{name: "project", value: "other"},
+				},
+				value:       2.5,
+				timestampMs: 1000000,
+			},
+			{
+				labels: []remoteWriteLabel{
+					{name: "__name__", value: "test_histogram_count"},
+					job,
+					{name: "project", value: "other"},
+				},
+				value:       2,
+				timestampMs: 1000000,
+			},
+		},
+		series,
+	)
+}
+
+func TestRemoteWriteSink(t *testing.T) {
+	testCases := []struct {
+		name       string
+		statusCode int
+		assertions func(*testing.T, *http.Request, []byte, error)
+	}{
+		{
+			name:       "error sending",
+			statusCode: http.StatusBadRequest,
+			assertions: func(t *testing.T, _ *http.Request, _ []byte, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "received 400")
+			},
+		},
+		{
+			name:       "success",
+			statusCode: http.StatusNoContent,
+			assertions: func(
+				t *testing.T,
+				req *http.Request,
+				body []byte,
+				err error,
+			) {
+				require.NoError(t, err)
+				require.Equal(t, http.MethodPost, req.Method)
+				require.Equal(t, "/api/v1/write", req.URL.Path)
+				require.Equal(t, "snappy", req.Header.Get("Content-Encoding"))
+				require.Equal(
+					t,
+					"application/x-protobuf",
+					req.Header.Get("Content-Type"),
+				)
+				require.Equal(
+					t,
+					"0.1.0",
+					req.Header.Get("X-Prometheus-Remote-Write-Version"),
+				)
+				require.Equal(t, "Bearer foo", req.Header.Get("Authorization"))
+				decoded, err := snappy.Decode(nil, body)
+				require.NoError(t, err)
+				series := decodeRemoteWriteRequest(t, decoded)
+				require.Len(t, series, 1)
+				require.Equal(
+					t,
+					[]remoteWriteLabel{
+						{name: "__name__", value: "brigade_projects_total"},
+						{name: "job", value: "brigade"},
+					},
+					series[0].labels,
+				)
+				require.Equal(t, 3.0, series[0].value)
+				require.NotZero(t, series[0].timestampMs)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			var req *http.Request
+			var body []byte
+			server := httptest.NewServer(
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					var err error
+					req = r
+					body, err = ioutil.ReadAll(r.Body)
+					require.NoError(t, err)
+					w.WriteHeader(testCase.statusCode)
+				}),
+			)
+			defer server.Close()
+			registry := prometheus.NewRegistry()
+			gauge := prometheus.NewGauge(
+				prometheus.GaugeOpts{Name: "brigade_projects_total"},
+			)
+			gauge.Set(3)
+			registry.MustRegister(gauge)
+			families, err := registry.Gather()
+			require.NoError(t, err)
+			sink := newRemoteWriteSink(
+				remoteWriteConfig{
+					URL:     server.URL + "/api/v1/write",
+					Headers: map[string]string{"Authorization": "Bearer foo"},
+					Labels:  map[string]string{"job": "brigade"},
+				},
+			)
+			err = sink.push(context.Background(), families)
+			testCase.assertions(t, req, body, err)
+		})
+	}
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	libHTTP "github.com/brigadecore/brigade-foundations/http"
+	"github.com/pkg/errors"
+)
+
+// clientAuthConfig represents configuration for requiring clients of the HTTPS
+// server to authenticate themselves with certificates (i.e. mutual TLS).
+type clientAuthConfig struct {
+	// Enabled specifies whether clients must present a certificate.
+	Enabled bool
+	// CAPath is the path to a bundle of PEM-encoded x509 certificates of the
+	// CAs that client certificates must be signed by.
+	CAPath string
+	// AllowedClients optionally restricts which clients are accepted. A client
+	// is accepted if its certificate's subject common name or any of its
+	// subject alternative names (DNS names, email addresses, URIs, or IP
+	// addresses) is listed. If empty, any client presenting a certificate
+	// signed by a CA in the bundle is accepted.
+	AllowedClients []string
+}
+
+// newServer returns a server for the provided handler. Servers that don't use
+// TLS are provided by brigade-foundations. HTTPS servers are instead provided
+// by tlsServer, which supports client certificate authentication and picks up
+// changes to its certificate, key, and client CA bundle without restarting.
+func newServer(
+	handler http.Handler,
+	config libHTTP.ServerConfig,
+	clientAuth clientAuthConfig,
+) (libHTTP.Server, error) {
+	if !config.TLSEnabled {
+		if clientAuth.Enabled {
+			return nil, errors.New(
+				"client certificate authentication requires TLS to be enabled",
+			)
+		}
+		return libHTTP.NewServer(handler, &config), nil
+	}
+	reloader := &tlsReloader{
+		cert: &secretFile{path: config.TLSCertPath},
+		key:  &secretFile{path: config.TLSKeyPath},
+	}
+	if clientAuth.Enabled {
+		reloader.clientCA = &secretFile{path: clientAuth.CAPath}
+	}
+	// Load everything once up front so that a misconfiguration is reported
+	// immediately
+	if _, _, err := reloader.load(); err != nil {
+		return nil, err
+	}
+	return &tlsServer{
+		port:       config.Port,
+		handler:    handler,
+		reloader:   reloader,
+		clientAuth: clientAuth,
+	}, nil
+}
+
+// tlsServer is a libHTTP.Server that serves HTTPS.
+type tlsServer struct {
+	port       int
+	handler    http.Handler
+	reloader   *tlsReloader
+	clientAuth clientAuthConfig
+}
+
+// ListenAndServe implements libHTTP.Server.
+func (t *tlsServer) ListenAndServe(ctx context.Context) error {
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", t.port))
+	if err != nil {
+		return errors.Wrapf(err, "error listening on port %d", t.port)
+	}
+	appLogger.info(
+		"Server is listening with TLS enabled",
+		"address", fmt.Sprintf("0.0.0.0:%d", t.port),
+	)
+	return t.serve(ctx, listener)
+}
+
+// serve serves HTTPS on the provided listener until the provided context is
+// canceled. This function always returns a non-nil error.
+func (t *tlsServer) serve(ctx context.Context, listener net.Listener) error {
+	srv := &http.Server{
+		Handler:           t.handler,
+		ReadHeaderTimeout: 30 * time.Second,
+	}
+	errCh := make(chan error)
+	go func() {
+		err := srv.Serve(t.tlsListener(listener))
+		select {
+		case errCh <- err:
+		case <-ctx.Done():
+		}
+	}()
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+		// Five second grace period on shutdown
+		shutdownCtx, cancel :=
+			context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+		return ctx.Err()
+	}
+}
+
+// tlsListener wraps the provided listener so that every connection it accepts
+// uses TLS as configured by tlsConfig. TLS is terminated here rather than by
+// http.Server.ServeTLS because older Go releases, including the one this
+// project is built with, disregard GetConfigForClient when deciding whether
+// ServeTLS was configured with a certificate and, finding none, fail trying to
+// load one from files.
+func (t *tlsServer) tlsListener(listener net.Listener) net.Listener {
+	return tls.NewListener(
+		listener,
+		&tls.Config{
+			MinVersion:         tls.VersionTLS12,
+			GetConfigForClient: t.tlsConfig,
+		},
+	)
+}
+
+// tlsConfig returns TLS configuration for a single connection, reflecting the
+// current contents of the certificate, key, and client CA bundle.
+func (t *tlsServer) tlsConfig(*tls.ClientHelloInfo) (*tls.Config, error) {
+	cert, clientCAs, err := t.reloader.load()
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{*cert},
+	}
+	if t.clientAuth.Enabled {
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+		config.ClientCAs = clientCAs
+		if len(t.clientAuth.AllowedClients) > 0 {
+			config.VerifyPeerCertificate = t.verifyClientAllowed
+		}
+	}
+	return config, nil
+}
+
+// verifyClientAllowed refuses any client whose (already verified) certificate
+// doesn't identify it as one of the allowed clients.
+func (t *tlsServer) verifyClientAllowed(
+	_ [][]byte,
+	verifiedChains [][]*x509.Certificate,
+) error {
+	if len(verifiedChains) == 0 || len(verifiedChains[0]) == 0 {
+		return errors.New("no verified client certificate")
+	}
+	leaf := verifiedChains[0][0]
+	names := []string{leaf.Subject.CommonName}
+	names = append(names, leaf.DNSNames...)
+	names = append(names, leaf.EmailAddresses...)
+	for _, uri := range leaf.URIs {
+		names = append(names, uri.String())
+	}
+	for _, ip := range leaf.IPAddresses {
+		names = append(names, ip.String())
+	}
+	for _, allowed := range t.clientAuth.AllowedClients {
+		for _, name := range names {
+			if name != "" && name == allowed {
+				return nil
+			}
+		}
+	}
+	return errors.Errorf(
+		"client certificate for %q is not among the allowed clients",
+		leaf.Subject.CommonName,
+	)
+}
+
+// tlsReloader loads a certificate, its key, and optionally a client CA bundle
+// from files, and loads them again whenever any of those files change. If the
+// files change but can't be loaded, e.g. because a new certificate has been
+// written but its key hasn't yet, whatever was last loaded successfully
+// continues to be used.
+type tlsReloader struct {
+	cert     *secretFile
+	key      *secretFile
+	clientCA *secretFile
+	// The contents of each file as of when they were last loaded successfully
+	certPEM     string
+	keyPEM      string
+	clientCAPEM string
+	certificate *tls.Certificate
+	clientCAs   *x509.CertPool
+	mu          sync.Mutex
+}
+
+// load returns the certificate and client CA pool reflecting the current
+// contents of the files.
+func (t *tlsReloader) load() (*tls.Certificate, *x509.CertPool, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	cert, key, clientCA, err := t.read()
+	if err == nil &&
+		(cert != t.certPEM || key != t.keyPEM || clientCA != t.clientCAPEM) {
+		err = t.parse(cert, key, clientCA)
+	}
+	if err != nil {
+		if t.certificate == nil {
+			return nil, nil, err
+		}
+		appLogger.warn(
+			"error reloading TLS certificates; continuing to use those "+
+				"previously loaded",
+			"error", err,
+		)
+	}
+	return t.certificate, t.clientCAs, nil
+}
+
+// read returns the current contents of the certificate, key, and client CA
+// bundle files.
+func (t *tlsReloader) read() (string, string, string, error) {
+	cert, err := t.cert.value()
+	if err != nil {
+		return "", "", "", err
+	}
+	key, err := t.key.value()
+	if err != nil {
+		return "", "", "", err
+	}
+	var clientCA string
+	if t.clientCA != nil {
+		if clientCA, err = t.clientCA.value(); err != nil {
+			return "", "", "", err
+		}
+	}
+	return cert, key, clientCA, nil
+}
+
+// parse parses the provided certificate, key, and client CA bundle and, if
+// successful, replaces those last loaded.
+func (t *tlsReloader) parse(cert, key, clientCA string) error {
+	certificate, err := tls.X509KeyPair([]byte(cert), []byte(key))
+	if err != nil {
+		return errors.Wrapf(
+			err,
+			"error loading TLS certificate %s and key %s",
+			t.cert.path,
+			t.key.path,
+		)
+	}
+	var clientCAs *x509.CertPool
+	if t.clientCA != nil {
+		clientCAs = x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM([]byte(clientCA)) {
+			return errors.Errorf(
+				"no PEM-encoded certificates found in client CA bundle %s",
+				t.clientCA.path,
+			)
+		}
+	}
+	t.certPEM = cert
+	t.keyPEM = key
+	t.clientCAPEM = clientCA
+	t.certificate = &certificate
+	t.clientCAs = clientCAs
+	return nil
+}
@@ -0,0 +1,419 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	libHTTP "github.com/brigadecore/brigade-foundations/http"
+	"github.com/stretchr/testify/require"
+)
+
+// testCA is a certificate authority that issues certificates for tests.
+type testCA struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM []byte
+}
+
+func newTestCA(t *testing.T, name string) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err :=
+		x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return &testCA{
+		cert:    cert,
+		key:     key,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+	}
+}
+
+// issue returns a PEM-encoded certificate and key for the specified common
+// name and DNS names. Server certificates are also valid for 127.0.0.1.
+func (c *testCA) issue(
+	t *testing.T,
+	commonName string,
+	dnsNames []string,
+	server bool,
+) ([]byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		DNSNames:     dnsNames,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	if server {
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+		template.IPAddresses = []net.IP{net.ParseIP("127.0.0.1")}
+	}
+	der, err :=
+		x509.CreateCertificate(rand.Reader, template, c.cert, &key.PublicKey, c.key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+// writeTLSFile writes a file and ensures its modification time changes, even
+// on filesystems with coarse timestamps.
+func writeTLSFile(t *testing.T, path string, contents []byte) {
+	modTime := time.Now()
+	if info, err := os.Stat(path); err == nil {
+		modTime = info.ModTime().Add(time.Second)
+	}
+	require.NoError(t, os.WriteFile(path, contents, 0600))
+	require.NoError(t, os.Chtimes(path, modTime, modTime))
+}
+
+func TestTLSServer(t *testing.T) {
+	serverCA := newTestCA(t, "server-ca")
+	clientCA := newTestCA(t, "client-ca")
+	otherCA := newTestCA(t, "other-ca")
+	// get requests / from the server at the specified address, presenting the
+	// specified client certificate, if any. Every request uses a new
+	// connection, so changes to the server's TLS configuration are seen.
+	get := func(
+		address string,
+		certPEM []byte,
+		keyPEM []byte,
+	) (*http.Response, error) {
+		roots := x509.NewCertPool()
+		roots.AddCert(serverCA.cert)
+		tlsConfig := &tls.Config{
+			MinVersion: tls.VersionTLS12,
+			RootCAs:    roots,
+		}
+		if certPEM != nil {
+			cert, err := tls.X509KeyPair(certPEM, keyPEM)
+			if err != nil {
+				return nil, err
+			}
+			tlsConfig.Certificates = []tls.Certificate{cert}
+		}
+		client := &http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig:   tlsConfig,
+				DisableKeepAlives: true,
+			},
+		}
+		resp, err := client.Get("https://" + address + "/")
+		if err == nil {
+			resp.Body.Close()
+		}
+		return resp, err
+	}
+	testCases := []struct {
+		name       string
+		clientAuth func(dir string) clientAuthConfig
+		assertions func(t *testing.T, dir string, address string)
+	}{
+		{
+			name: "client certificates not required",
+			clientAuth: func(string) clientAuthConfig {
+				return clientAuthConfig{}
+			},
+			assertions: func(t *testing.T, _ string, address string) {
+				resp, err := get(address, nil, nil)
+				require.NoError(t, err)
+				require.Equal(t, http.StatusOK, resp.StatusCode)
+			},
+		},
+		{
+			name: "client certificates required",
+			clientAuth: func(dir string) clientAuthConfig {
+				return clientAuthConfig{
+					Enabled: true,
+					CAPath:  filepath.Join(dir, "client-ca.crt"),
+				}
+			},
+			assertions: func(t *testing.T, _ string, address string) {
+				_, err := get(address, nil, nil)
+				require.Error(t, err)
+				cert, key := otherCA.issue(t, "prometheus", nil, false)
+				_, err = get(address, cert, key)
+				require.Error(t, err)
+				cert, key = clientCA.issue(t, "prometheus", nil, false)
+				resp, err := get(address, cert, key)
+				require.NoError(t, err)
+				require.Equal(t, http.StatusOK, resp.StatusCode)
+			},
+		},
+		{
+			name: "only allowed clients accepted",
+			clientAuth: func(dir string) clientAuthConfig {
+				return clientAuthConfig{
+					Enabled:        true,
+					CAPath:         filepath.Join(dir, "client-ca.crt"),
+					AllowedClients: []string{"prometheus", "scraper.example.com"},
+				}
+			},
+			assertions: func(t *testing.T, _ string, address string) {
+				cert, key := clientCA.issue(t, "grafana", nil, false)
+				_, err := get(address, cert, key)
+				require.Error(t, err)
+				// Matching common name
+				cert, key = clientCA.issue(t, "prometheus", nil, false)
+				resp, err := get(address, cert, key)
+				require.NoError(t, err)
+				require.Equal(t, http.StatusOK, resp.StatusCode)
+				// Matching subject alternative name
+				cert, key = clientCA.issue(
+					t,
+					"scraper",
+					[]string{"scraper.example.com"},
+					false,
+				)
+				resp, err = get(address, cert, key)
+				require.NoError(t, err)
+				require.Equal(t, http.StatusOK, resp.StatusCode)
+			},
+		},
+		{
+			name: "certificates and client CA reloaded",
+			clientAuth: func(dir string) clientAuthConfig {
+				return clientAuthConfig{
+					Enabled: true,
+					CAPath:  filepath.Join(dir, "client-ca.crt"),
+				}
+			},
+			assertions: func(t *testing.T, dir string, address string) {
+				cert, key := otherCA.issue(t, "prometheus", nil, false)
+				_, err := get(address, cert, key)
+				require.Error(t, err)
+				// The client CA bundle is updated to include the other CA
+				writeTLSFile(
+					t,
+					filepath.Join(dir, "client-ca.crt"),
+					append(append([]byte{}, clientCA.certPEM...), otherCA.certPEM...),
+				)
+				resp, err := get(address, cert, key)
+				require.NoError(t, err)
+				require.Equal(t, http.StatusOK, resp.StatusCode)
+				// The server's certificate is rotated
+				serverCert, serverKey := serverCA.issue(t, "rotated", nil, true)
+				writeTLSFile(t, filepath.Join(dir, "tls.crt"), serverCert)
+				writeTLSFile(t, filepath.Join(dir, "tls.key"), serverKey)
+				resp, err = get(address, cert, key)
+				require.NoError(t, err)
+				require.Equal(
+					t,
+					"rotated",
+					resp.TLS.PeerCertificates[0].Subject.CommonName,
+				)
+				// A broken certificate doesn't replace the working one
+				writeTLSFile(t, filepath.Join(dir, "tls.crt"), []byte("foo"))
+				resp, err = get(address, cert, key)
+				require.NoError(t, err)
+				require.Equal(
+					t,
+					"rotated",
+					resp.TLS.PeerCertificates[0].Subject.CommonName,
+				)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			dir := t.TempDir()
+			serverCert, serverKey := serverCA.issue(t, "exporter", nil, true)
+			writeTLSFile(t, filepath.Join(dir, "tls.crt"), serverCert)
+			writeTLSFile(t, filepath.Join(dir, "tls.key"), serverKey)
+			writeTLSFile(t, filepath.Join(dir, "client-ca.crt"), clientCA.certPEM)
+			server, err := newServer(
+				http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+					w.WriteHeader(http.StatusOK)
+				}),
+				libHTTP.ServerConfig{
+					TLSEnabled:  true,
+					TLSCertPath: filepath.Join(dir, "tls.crt"),
+					TLSKeyPath:  filepath.Join(dir, "tls.key"),
+				},
+				testCase.clientAuth(dir),
+			)
+			require.NoError(t, err)
+			tlsServer, ok := server.(*tlsServer)
+			require.True(t, ok)
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			require.NoError(t, err)
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan struct{})
+			go func() {
+				_ = tlsServer.serve(ctx, listener)
+				close(done)
+			}()
+			defer func() {
+				cancel()
+				<-done
+			}()
+			testCase.assertions(t, dir, listener.Addr().String())
+		})
+	}
+}
+
+func TestTLSServerListener(t *testing.T) {
+	serverCA := newTestCA(t, "server-ca")
+	dir := t.TempDir()
+	serverCert, serverKey := serverCA.issue(t, "localhost", nil, true)
+	writeTLSFile(t, filepath.Join(dir, "tls.crt"), serverCert)
+	writeTLSFile(t, filepath.Join(dir, "tls.key"), serverKey)
+	server, err := newServer(
+		http.NotFoundHandler(),
+		libHTTP.ServerConfig{
+			TLSEnabled:  true,
+			TLSCertPath: filepath.Join(dir, "tls.crt"),
+			TLSKeyPath:  filepath.Join(dir, "tls.key"),
+		},
+		clientAuthConfig{},
+	)
+	require.NoError(t, err)
+	tlsServer, ok := server.(*tlsServer)
+	require.True(t, ok)
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	listener := tlsServer.tlsListener(inner)
+	defer listener.Close()
+	// Complete a handshake without involving http.Server at all, so this passes
+	// or fails the same way regardless of how the Go release in use implements
+	// http.Server.ServeTLS
+	errCh := make(chan error, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			errCh <- err
+			return
+		}
+		defer conn.Close()
+		tlsConn, ok := conn.(*tls.Conn)
+		if !ok {
+			errCh <- errors.New("accepted connection does not use TLS")
+			return
+		}
+		errCh <- tlsConn.Handshake()
+	}()
+	roots := x509.NewCertPool()
+	roots.AddCert(serverCA.cert)
+	conn, err := tls.Dial(
+		"tcp",
+		inner.Addr().String(),
+		&tls.Config{
+			MinVersion: tls.VersionTLS12,
+			RootCAs:    roots,
+		},
+	)
+	require.NoError(t, err)
+	defer conn.Close()
+	require.NoError(t, <-errCh)
+	require.Equal(
+		t,
+		"localhost",
+		conn.ConnectionState().PeerCertificates[0].Subject.CommonName,
+	)
+}
+
+func TestNewServer(t *testing.T) {
+	testCases := []struct {
+		name       string
+		config     func(dir string) (libHTTP.ServerConfig, clientAuthConfig)
+		assertions func(*testing.T, libHTTP.Server, error)
+	}{
+		{
+			name: "client auth without TLS",
+			config: func(string) (libHTTP.ServerConfig, clientAuthConfig) {
+				return libHTTP.ServerConfig{}, clientAuthConfig{Enabled: true}
+			},
+			assertions: func(t *testing.T, _ libHTTP.Server, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "requires TLS to be enabled")
+			},
+		},
+		{
+			name: "certificate does not exist",
+			config: func(dir string) (libHTTP.ServerConfig, clientAuthConfig) {
+				return libHTTP.ServerConfig{
+					TLSEnabled:  true,
+					TLSCertPath: filepath.Join(dir, "missing.crt"),
+					TLSKeyPath:  filepath.Join(dir, "tls.key"),
+				}, clientAuthConfig{}
+			},
+			assertions: func(t *testing.T, _ libHTTP.Server, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "missing.crt")
+			},
+		},
+		{
+			name: "client CA bundle contains no certificates",
+			config: func(dir string) (libHTTP.ServerConfig, clientAuthConfig) {
+				return libHTTP.ServerConfig{
+					TLSEnabled:  true,
+					TLSCertPath: filepath.Join(dir, "tls.crt"),
+					TLSKeyPath:  filepath.Join(dir, "tls.key"),
+				}, clientAuthConfig{
+					Enabled: true,
+					CAPath:  filepath.Join(dir, "tls.key"),
+				}
+			},
+			assertions: func(t *testing.T, _ libHTTP.Server, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "no PEM-encoded certificates")
+			},
+		},
+		{
+			name: "without TLS",
+			config: func(string) (libHTTP.ServerConfig, clientAuthConfig) {
+				return libHTTP.ServerConfig{}, clientAuthConfig{}
+			},
+			assertions: func(t *testing.T, server libHTTP.Server, err error) {
+				require.NoError(t, err)
+				_, ok := server.(*tlsServer)
+				require.False(t, ok)
+			},
+		},
+	}
+	ca := newTestCA(t, "server-ca")
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			dir := t.TempDir()
+			cert, key := ca.issue(t, "exporter", nil, true)
+			writeTLSFile(t, filepath.Join(dir, "tls.crt"), cert)
+			writeTLSFile(t, filepath.Join(dir, "tls.key"), key)
+			serverConfig, clientAuthConfig := testCase.config(dir)
+			server, err := newServer(
+				http.NotFoundHandler(),
+				serverConfig,
+				clientAuthConfig,
+			)
+			testCase.assertions(t, server, err)
+		})
+	}
+}
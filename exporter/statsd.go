@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// statsdFlavor represents a dialect of the StatsD protocol.
+type statsdFlavor string
+
+const (
+	// statsdFlavorDogStatsD is a statsdFlavor wherein Prometheus labels are
+	// sent as DogStatsD tags.
+	statsdFlavorDogStatsD statsdFlavor = "dogstatsd"
+	// statsdFlavorStatsD is a statsdFlavor wherein, for lack of tags,
+	// Prometheus labels are folded into metric names, Graphite style.
+	statsdFlavorStatsD statsdFlavor = "statsd"
+)
+
+// statsdMaxPacketSize is the largest UDP packet sent to a StatsD server. This
+// is the size recommended by Datadog for avoiding fragmentation on most
+// networks.
+const statsdMaxPacketSize = 1432
+
+// statsdConfig represents configuration for sending metrics to a StatsD or
+// DogStatsD server.
+type statsdConfig struct {
+	// Enabled specifies whether metrics should be sent to a StatsD server.
+	Enabled bool
+	// Flavor specifies the dialect of the StatsD protocol to use.
+	Flavor statsdFlavor
+	// Host specifies the host of the StatsD server.
+	Host string
+	// Port specifies the UDP port of the StatsD server.
+	Port int
+	// Prefix is prepended to the name of every metric sent.
+	Prefix string
+	// Tags specifies tags added to every metric sent. They are ignored by
+	// statsdFlavorStatsD.
+	Tags map[string]string
+	// Interval specifies how often metrics are sent.
+	Interval time.Duration
+}
+
+// statsdSink is a metricsSink that sends metrics to a StatsD or DogStatsD
+// server. Every metric is sent as a gauge. Counters are sent with their
+// cumulative value, and histograms are broken down into their buckets, sum,
+// and count, since StatsD has no means of accepting either pre-aggregated.
+type statsdSink struct {
+	config statsdConfig
+	conn   net.Conn
+}
+
+// newStatsdSink returns a statsdSink that sends metrics as configured.
+func newStatsdSink(config statsdConfig) (*statsdSink, error) {
+	address := net.JoinHostPort(config.Host, strconv.Itoa(config.Port))
+	conn, err := net.Dial("udp", address)
+	if err != nil {
+		return nil, errors.Wrapf(
+			err,
+			"error connecting to StatsD server %s",
+			address,
+		)
+	}
+	return &statsdSink{
+		config: config,
+		conn:   conn,
+	}, nil
+}
+
+// push implements metricsSink.
+func (s *statsdSink) push(
+	ctx context.Context,
+	families []*dto.MetricFamily,
+) error {
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := s.conn.SetWriteDeadline(deadline); err != nil {
+			return errors.Wrap(err, "error setting StatsD write deadline")
+		}
+	}
+	// Lines are batched into as few packets as possible without exceeding
+	// statsdMaxPacketSize
+	packet := make([]byte, 0, statsdMaxPacketSize)
+	for _, line := range s.lines(families) {
+		if len(packet) > 0 && len(packet)+1+len(line) > statsdMaxPacketSize {
+			if _, err := s.conn.Write(packet); err != nil {
+				return errors.Wrap(err, "error writing to StatsD server")
+			}
+			packet = packet[:0]
+		}
+		if len(packet) > 0 {
+			packet = append(packet, '\n')
+		}
+		packet = append(packet, line...)
+	}
+	if len(packet) > 0 {
+		if _, err := s.conn.Write(packet); err != nil {
+			return errors.Wrap(err, "error writing to StatsD server")
+		}
+	}
+	return nil
+}
+
+// Close implements io.Closer.
+func (s *statsdSink) Close() error {
+	return s.conn.Close()
+}
+
+// lines converts the provided metric families into StatsD lines.
+func (s *statsdSink) lines(families []*dto.MetricFamily) []string {
+	lines := []string{}
+	for _, family := range families {
+		name := family.GetName()
+		for _, m := range family.Metric {
+			labels := make(map[string]string, len(m.Label))
+			for _, label := range m.Label {
+				labels[label.GetName()] = label.GetValue()
+			}
+			switch family.GetType() {
+			case dto.MetricType_GAUGE:
+				lines = append(lines, s.line(name, m.GetGauge().GetValue(), labels))
+			case dto.MetricType_COUNTER:
+				lines = append(
+					lines,
+					s.line(name, m.GetCounter().GetValue(), labels),
+				)
+			case dto.MetricType_UNTYPED:
+				lines = append(
+					lines,
+					s.line(name, m.GetUntyped().GetValue(), labels),
+				)
+			case dto.MetricType_HISTOGRAM:
+				// Prometheus' implicit +Inf bucket is not sent, since it's the same
+				// as the count
+				histogram := m.GetHistogram()
+				for _, bucket := range histogram.Bucket {
+					bucketLabels := make(map[string]string, len(labels)+1)
+					for k, v := range labels {
+						bucketLabels[k] = v
+					}
+					bucketLabels["le"] = formatFloat(bucket.GetUpperBound())
+					lines = append(
+						lines,
+						s.line(
+							name+"_bucket",
+							float64(bucket.GetCumulativeCount()),
+							bucketLabels,
+						),
+					)
+				}
+				lines = append(
+					lines,
+					s.line(name+"_sum", histogram.GetSampleSum(), labels),
+					s.line(
+						name+"_count",
+						float64(histogram.GetSampleCount()),
+						labels,
+					),
+				)
+			}
+		}
+	}
+	return lines
+}
+
+// line formats a single StatsD gauge having the specified name, value, and
+// labels.
+func (s *statsdSink) line(
+	name string,
+	value float64,
+	labels map[string]string,
+) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var line strings.Builder
+	line.WriteString(s.config.Prefix)
+	line.WriteString(name)
+	if s.config.Flavor == statsdFlavorStatsD {
+		for _, k := range keys {
+			line.WriteString(".")
+			line.WriteString(statsdNameSanitizer.Replace(k))
+			line.WriteString(".")
+			line.WriteString(statsdNameSanitizer.Replace(labels[k]))
+		}
+	}
+	line.WriteString(":")
+	line.WriteString(strconv.FormatFloat(value, 'f', -1, 64))
+	line.WriteString("|g")
+	if s.config.Flavor == statsdFlavorDogStatsD {
+		tags := make([]string, 0, len(keys)+len(s.config.Tags))
+		for _, k := range keys {
+			tags = append(tags, statsdTag(k, labels[k]))
+		}
+		tagKeys := make([]string, 0, len(s.config.Tags))
+		for k := range s.config.Tags {
+			// Labels take precedence over configured tags
+			if _, ok := labels[k]; !ok {
+				tagKeys = append(tagKeys, k)
+			}
+		}
+		sort.Strings(tagKeys)
+		for _, k := range tagKeys {
+			tags = append(tags, statsdTag(k, s.config.Tags[k]))
+		}
+		if len(tags) > 0 {
+			line.WriteString("|#")
+			line.WriteString(strings.Join(tags, ","))
+		}
+	}
+	return line.String()
+}
+
+// statsdTag formats a single DogStatsD tag.
+func statsdTag(key, value string) string {
+	return statsdTagSanitizer.Replace(key) + ":" +
+		statsdTagSanitizer.Replace(value)
+}
+
+// statsdNameSanitizer replaces characters that can't appear in a segment of a
+// StatsD metric name.
+var statsdNameSanitizer = strings.NewReplacer(
+	".", "_",
+	":", "_",
+	"|", "_",
+	"@", "_",
+	"/", "_",
+	" ", "_",
+	"\n", "_",
+)
+
+// statsdTagSanitizer replaces characters that can't appear in a DogStatsD tag.
+var statsdTagSanitizer = strings.NewReplacer(
+	",", "_",
+	"|", "_",
+	"#", "_",
+	" ", "_",
+	"\n", "_",
+)
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/require"
+)
+
+// statsdTestFamilies returns a gauge, a counter, and a histogram, each with
+// labels.
+func statsdTestFamilies(t *testing.T) []*dto.MetricFamily {
+	registry := prometheus.NewRegistry()
+	gauge := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{Name: "test_gauge"},
+		[]string{"project", "source"},
+	)
+	gauge.WithLabelValues("italian", "brigade.sh/cli").Set(3)
+	counter := prometheus.NewCounter(prometheus.CounterOpts{Name: "test_total"})
+	counter.Add(5)
+	histogram := prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "test_seconds",
+			Buckets: []float64{1},
+		},
+		[]string{"project"},
+	)
+	histogram.WithLabelValues("thai").Observe(0.5)
+	registry.MustRegister(gauge, counter, histogram)
+	families, err := registry.Gather()
+	require.NoError(t, err)
+	return families
+}
+
+func TestStatsdSinkLines(t *testing.T) {
+	testCases := []struct {
+		name     string
+		config   statsdConfig
+		expected []string
+	}{
+		{
+			name: "dogstatsd",
+			config: statsdConfig{
+				Flavor: statsdFlavorDogStatsD,
+				Prefix: "brigade.",
+				Tags:   map[string]string{"env": "prod", "project": "ignored"},
+			},
+			expected: []string{
+				"brigade.test_gauge:3|g|#project:italian,source:brigade.sh/cli," +
+					"env:prod",
+				"brigade.test_seconds_bucket:1|g|#le:1,project:thai,env:prod",
+				"brigade.test_seconds_sum:0.5|g|#project:thai,env:prod",
+				"brigade.test_seconds_count:1|g|#project:thai,env:prod",
+				"brigade.test_total:5|g|#env:prod,project:ignored",
+			},
+		},
+		{
+			name: "statsd",
+			config: statsdConfig{
+				Flavor: statsdFlavorStatsD,
+				Tags:   map[string]string{"env": "prod"},
+			},
+			expected: []string{
+				"test_gauge.project.italian.source.brigade_sh_cli:3|g",
+				"test_seconds_bucket.le.1.project.thai:1|g",
+				"test_seconds_sum.project.thai:0.5|g",
+				"test_seconds_count.project.thai:1|g",
+				"test_total:5|g",
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			sink := &statsdSink{config: testCase.config}
+			require.Equal(
+				t,
+				testCase.expected,
+				sink.lines(statsdTestFamilies(t)),
+			)
+		})
+	}
+}
+
+func TestStatsdSink(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer conn.Close()
+	addr, ok := conn.LocalAddr().(*net.UDPAddr)
+	require.True(t, ok)
+	sink, err := newStatsdSink(
+		statsdConfig{
+			Flavor: statsdFlavorDogStatsD,
+			Host:   addr.IP.String(),
+			Port:   addr.Port,
+		},
+	)
+	require.NoError(t, err)
+	defer sink.Close()
+	// Enough metrics that they can't all fit in one packet
+	registry := prometheus.NewRegistry()
+	gauge := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{Name: "test_gauge"},
+		[]string{"project"},
+	)
+	for i := 0; i < 100; i++ {
+		gauge.WithLabelValues(fmt.Sprintf("project-%d", i)).Set(float64(i))
+	}
+	registry.MustRegister(gauge)
+	families, err := registry.Gather()
+	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	require.NoError(t, sink.push(ctx, families))
+	lines := []string{}
+	buf := make([]byte, 65535)
+	var packets int
+	for len(lines) < 100 {
+		require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+		n, _, err := conn.ReadFrom(buf)
+		require.NoError(t, err)
+		require.LessOrEqual(t, n, statsdMaxPacketSize)
+		lines = append(lines, strings.Split(string(buf[:n]), "\n")...)
+		packets++
+	}
+	require.Greater(t, packets, 1)
+	require.Len(t, lines, 100)
+	require.Contains(t, lines, "test_gauge:42|g|#project:project-42")
+}